@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/heartbeat"
+)
+
+const defaultInterval = 30 * time.Second
+
+func checkErr(err error) {
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+}
+
+// agent runs on a proxy and pushes a signed heartbeat with local metrics
+// to [Agent] report_url every interval_sec until SIGINT or SIGTERM
+func main() {
+	cfgPath := flag.String("config-path", "agent.toml", "Set path of toml file with config")
+	flag.Parse()
+
+	cfg, err := config.Load(*cfgPath)
+	checkErr(err)
+	checkErr(cfg.Agent.Validate())
+
+	interval := time.Second * time.Duration(cfg.Agent.IntervalSec)
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	collector := heartbeat.NewCollector(cfg.Agent, interval/2)
+	sender := heartbeat.NewSender(cfg.Agent)
+
+	log.Printf("agent: sending heartbeats of %s to %s every %s", cfg.Agent.Proxy, cfg.Agent.ReportURL, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		hb := collector.Collect(ctx)
+		for _, e := range hb.Errors {
+			log.Printf("agent: %s", e)
+		}
+		if err := sender.Send(hb); err != nil {
+			log.Printf("agent: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Println("agent: exiting")
+			return
+		}
+	}
+}
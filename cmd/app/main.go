@@ -4,17 +4,19 @@ import (
 	"context"
 	"flag"
 	"log"
-	"net"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
 	"time"
 
+	"go-cf-zone-switch/cmd/internal/dbconfig"
+	"go-cf-zone-switch/pkg/admin"
 	"go-cf-zone-switch/pkg/at"
 	"go-cf-zone-switch/pkg/config"
 	"go-cf-zone-switch/pkg/db"
+	"go-cf-zone-switch/pkg/heartbeat"
 	"go-cf-zone-switch/pkg/notifications"
+	"go-cf-zone-switch/pkg/probe"
 	"go-cf-zone-switch/pkg/servers"
 	"go-cf-zone-switch/pkg/switcher"
 )
@@ -32,6 +34,7 @@ func checkErr(err error) {
 
 func main() {
 	cfgPath := flag.String("config-path", "config.toml", "Set path of toml file with config ")
+	probeMode := flag.Bool("probe", false, "Run as a probe agent, only check the proxies and report them to [Probe] report_url")
 
 	// config loading
 	flag.Parse()
@@ -40,8 +43,13 @@ func main() {
 
 	log.Printf("Config loaded %s ", *cfgPath)
 
+	if *probeMode {
+		runProbe(cfg)
+		return
+	}
+
 	// create database
-	storage, err := db.NewStorage()
+	storage, err := db.NewStorage(dbconfig.Options(cfg.Db))
 	checkErr(err)
 	defer storage.Close()
 
@@ -53,28 +61,47 @@ func main() {
 
 	notifier := getNotifier(cfg)
 
+	pool := loadProxyPool(storage, cfg)
+
 	switcher := switcher.NewSwitcher(cfg, storage, notifier)
+	switcher.UsePool(pool)
+	switcher.UseDomainLinks(repo.DomainURL)
+
+	startMonitoring(ctx, cfg, storage, pool, statusReceiver(ctx, cfg, storage, pool, switcher, notifier), notifier)
+
+	startDnsRefresh(ctx, cfg, switcher)
+
+	domainsSync := startDomainDataSync(ctx, storage, repo, cfg, notifier)
+
+	startProxyConfigurator(ctx, storage, cfg, pool, notifier)
 
-	startMonitoring(ctx, cfg, switcher, notifier)
+	startChecksCompaction(ctx, storage, cfg)
 
-	startDomainDataSync(ctx, storage, repo, cfg, notifier)
+	startCertMonitoring(ctx, storage, cfg, pool, notifier)
 
-	startProxyConfigurator(ctx, storage, cfg, notifier)
+	startAdmin(ctx, cfg, storage, pool, domainsSync)
 
 	sigs := make(chan os.Signal, 1)
 	done := make(chan bool, 1)
 
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	go func() {
-		select {
-		case sig := <-sigs:
-			log.Println(sig)
-			cancel() // Cancel context on signal
-		case <-ctx.Done():
-			log.Println("app: context canceled")
+		for {
+			select {
+			case sig := <-sigs:
+				if sig == syscall.SIGHUP {
+					reloadProxies(*cfgPath, pool)
+					continue
+				}
+				log.Println(sig)
+				cancel() // Cancel context on signal
+			case <-ctx.Done():
+				log.Println("app: context canceled")
+			}
+			done <- true
+			return
 		}
-		done <- true
 	}()
 
 	log.Println("app: awaiting signal or context cancellation")
@@ -83,28 +110,101 @@ func main() {
 	log.Println("app: exiting")
 }
 
-func startMonitoring(ctx context.Context, cfg *config.Config, reporter servers.StatusReceiver, notifier Notifier) {
+// loadProxyPool reads the persisted pool and applies the proxies of the config to it
+func loadProxyPool(storage *db.DbStorage, cfg *config.Config) *servers.Pool {
+	pool, err := servers.NewPool(storage)
+	checkErr(err)
+	checkErr(pool.Reconcile(cfg.Servers.Proxy))
+	return pool
+}
+
+// reloadProxies applies the proxies of the config file to the pool on SIGHUP,
+// other settings need a restart
+func reloadProxies(cfgPath string, pool *servers.Pool) {
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		log.Printf("app: reload failed, keeping the current proxies: %v", err)
+		return
+	}
+	if err := pool.Reconcile(cfg.Servers.Proxy); err != nil {
+		log.Printf("app: reload failed: %v", err)
+		return
+	}
+	log.Printf("app: proxies reloaded from %s", cfgPath)
+}
+
+func startMonitoring(ctx context.Context, cfg *config.Config, storage *db.DbStorage, pool *servers.Pool, reporter servers.StatusReceiver, notifier Notifier) {
+	monitoring := newMonitoring(cfg, servers.NewStorageCanaries(storage), reporter, notifier)
+
+	monitoring.UsePool(pool)
+	monitoring.Start(ctx)
+}
+
+// newMonitoring creates the monitor, canary checks pick domains from canaries when it is not nil
+func newMonitoring(cfg *config.Config, canaries servers.CanarySource, reporter servers.StatusReceiver, notifier Notifier) *servers.ServerMonitor {
 	checkInterval := time.Second * time.Duration(cfg.Servers.CheckIntervalSec)
 	timeout := time.Second * time.Duration(cfg.Servers.TimeoutSec)
 
-	monitoring := servers.NewServerMonitoring(checkInterval, timeout, reporter, notifier)
+	check, err := servers.CheckOptionsFromConfig(cfg.Servers.Check)
+	checkErr(err)
+	check.Canaries = canaries
 
-	for _, proxy := range cfg.Servers.Proxy {
-		schema := "http"
-		if strings.HasPrefix(proxy, "https://") {
-			schema = "https"
-		}
+	return servers.NewServerMonitoring(checkInterval, timeout, cfg.Servers.CheckConcurrency, check, reporter, notifier)
+}
 
-		proxy = strings.TrimPrefix(strings.TrimPrefix(proxy, "http://"), "https://")
+// statusReceiver passes the local checks through the quorum of the probe agents when [Quorum] listen is set
+// and then through the heartbeats of the proxy agents when [Heartbeat] listen is set, so a missing heartbeat
+// fails a proxy whatever the vantage points see
+func statusReceiver(ctx context.Context, cfg *config.Config, storage *db.DbStorage, pool *servers.Pool, sw *switcher.Switcher, notifier Notifier) servers.StatusReceiver {
+	var receiver servers.StatusReceiver = sw
+
+	if cfg.Heartbeat.Listen != "" {
+		tracker, err := heartbeat.NewTracker(cfg.Heartbeat, storage, pool, receiver)
+		checkErr(err)
+		tracker.UsePool(pool)
+		heartbeat.NewServer(cfg.Heartbeat, tracker).Start(ctx)
+		receiver = tracker
+	}
 
-		h, p, err := net.SplitHostPort(proxy)
-		if err != nil {
-			panic(err)
-		}
-		monitoring.AddServer(h, p, proxy, schema)
+	if cfg.Quorum.Listen != "" {
+		checkInterval := time.Second * time.Duration(cfg.Servers.CheckIntervalSec)
+		quorum := probe.NewQuorum(cfg.Quorum, checkInterval, receiver, notifier)
+		probe.NewServer(cfg.Quorum, quorum).Start(ctx)
+		receiver = quorum
 	}
+	return receiver
+}
 
+// runProbe checks the proxies of the config and reports every round to the main instance
+// until SIGINT or SIGTERM
+func runProbe(cfg *config.Config) {
+	checkErr(cfg.Probe.Validate())
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	monitoring := newMonitoring(cfg, nil, probe.NewReporter(cfg.Probe), getNotifier(cfg))
+	for _, p := range cfg.Servers.Proxy {
+		monitoring.AddProxy(p)
+	}
 	monitoring.Start(ctx)
+
+	log.Printf("app: probe %s reporting to %s", cfg.Probe.Name, cfg.Probe.ReportURL)
+	<-ctx.Done()
+	log.Println("app: exiting")
+}
+
+func startDnsRefresh(ctx context.Context, cfg *config.Config, sw *switcher.Switcher) {
+	interval := time.Minute * time.Duration(cfg.Servers.DnsRefreshIntervalMin)
+	switch {
+	case interval == 0:
+		interval = switcher.DefaultDnsRefreshInterval
+	case interval < 0:
+		log.Println("app: DNS state refresh disabled")
+		return
+	}
+
+	sw.StartDnsRefresh(ctx, interval)
 }
 
 func getNotifier(cfg *config.Config) notifications.Notifier {
@@ -114,16 +214,70 @@ func getNotifier(cfg *config.Config) notifications.Notifier {
 	return notifier
 }
 
-func startDomainDataSync(ctx context.Context, storage *db.DbStorage, repo *at.RemoteRepository, config *config.Config, notifier Notifier) {
+func startDomainDataSync(ctx context.Context, storage *db.DbStorage, repo *at.RemoteRepository, config *config.Config, notifier Notifier) *at.DbDomainsUpdater {
 	updateInterval := time.Duration(config.At.DomainsUpdateMin) * time.Minute
 
 	updater := at.NewDbDomainsSync(storage, repo, updateInterval, notifier)
+	updater.Guard = syncGuard(config)
+	updater.Mode = config.At.SyncMode
 
 	updater.Start(ctx)
+	return updater
+}
+
+func syncGuard(cfg *config.Config) at.SyncGuard {
+	return at.NewSyncGuard(cfg.At.Guard.MaxRemovedPercent, cfg.At.Guard.MaxTokenLosses, cfg.At.Guard.MaxHostingChanges)
 }
 
-func startProxyConfigurator(ctx context.Context, storage *db.DbStorage, config *config.Config, notifier Notifier) {
+func startProxyConfigurator(ctx context.Context, storage *db.DbStorage, config *config.Config, pool *servers.Pool, notifier Notifier) {
 	configUpdater := servers.NewProxyConfigUpdater(storage, &config.Servers, notifier)
+	configUpdater.UsePool(pool)
 
 	configUpdater.Start(ctx)
 }
+
+// startAdmin serves the admin API when [Admin] listen is set
+func startAdmin(ctx context.Context, cfg *config.Config, storage *db.DbStorage, pool *servers.Pool, domainsSync *at.DbDomainsUpdater) {
+	if cfg.Admin.Listen == "" {
+		return
+	}
+	server := admin.NewServer(cfg.Admin, pool, storage)
+	server.UseSync(domainsSync)
+	server.Start(ctx)
+}
+
+// startCertMonitoring checks the certificates of the enabled proxies when [Servers.Certs] is enabled
+func startCertMonitoring(ctx context.Context, storage *db.DbStorage, cfg *config.Config, pool *servers.Pool, notifier Notifier) {
+	if !cfg.Servers.Certs.Enabled {
+		return
+	}
+
+	timeout := time.Second * time.Duration(cfg.Servers.TimeoutSec)
+	opts := servers.CertOptionsFromConfig(cfg.Servers.Certs)
+	servers.NewCertMonitor(opts, timeout, storage, pool.Enabled, notifier).Start(ctx)
+}
+
+// startChecksCompaction applies the health checks retention policy every hour
+func startChecksCompaction(ctx context.Context, storage *db.DbStorage, cfg *config.Config) {
+	policy := dbconfig.Retention(cfg.Db)
+
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for {
+			removed, err := storage.CompactServerChecks(policy, time.Now())
+			if err != nil {
+				log.Printf("app: failed to compact server checks: %v", err)
+			} else if removed > 0 {
+				log.Printf("app: compacted server checks, %d rows removed", removed)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
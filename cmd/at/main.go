@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 
+	"go-cf-zone-switch/cmd/internal/dbconfig"
 	"go-cf-zone-switch/pkg/at"
 	"go-cf-zone-switch/pkg/config"
 	"go-cf-zone-switch/pkg/db"
@@ -23,7 +24,7 @@ func main() {
 	}
 
 
-	storage, err := db.NewStorage()
+	storage, err := db.NewStorage(dbconfig.Options(cfg.Db))
 	checkErr(err)
 
 	defer storage.Close()
@@ -35,15 +36,8 @@ func main() {
 	// Get all domains
 	domains, err := repo.GetAllDomains()
 
-	dbRows := []db.DomainRow{}
-	for _, d := range domains {
-		dbRows = append(dbRows, db.DomainRow{
-			Domain: d.Domain,
-			HostingIP: d.HostingIP,
-			CfApiToken: d.CfApiToken,
-		})
-	}
-	
+	dbRows := at.ToDomainRows(domains)
+
 	err = storage.SaveDomains(dbRows)
 	checkErr(err)
 
@@ -56,7 +50,7 @@ func main() {
 		if i > 100 {
 			break
 		}
-		log.Printf("Domain: %s, Token: %s, Hosting IP: %s\n", domain.Domain, domain.CfApiToken, domain.HostingIP)
+		log.Printf("Domain: %s, Token: %s, Hosting IP: %s, %s\n", domain.Domain, domain.CfApiToken, domain.HostingIP, repo.DomainURL(domain.DomainRecordID))
 	}
 }
 
@@ -34,7 +34,7 @@ func main() {
 	domains, _ := atr.GetAllDomains()
 
 	for _, domain := range domains {
-		c := cf.NewApiClient(domain.CfApiToken)
+		c := cf.NewApiClient(domain.CfApiToken.Reveal())
 		ip, err := c.GetDomainIP(domain.Domain)
 		if err != nil {
 			log.Panicln(err)
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+)
+
+func runCerts(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("certs", flag.ExitOnError)
+	host := fs.String("host", "", "Show only this proxy host")
+	asJSON := fs.Bool("json", false, "Print checks as JSON")
+	_ = fs.Parse(args)
+
+	storage, err := openReadOnly(cfg)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	all, err := storage.GetCertChecks()
+	if err != nil {
+		return err
+	}
+	checks := []db.CertCheck{}
+	for _, c := range all {
+		if *host == "" || c.Host == *host {
+			checks = append(checks, c)
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(checks)
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tDOMAIN\tEXPIRES\tDAYS\tVALID\tCHECKED\tERROR")
+	for _, c := range checks {
+		expires, days := "-", "-"
+		if c.HasCert() {
+			expires = c.NotAfter.Format(time.DateOnly)
+			days = fmt.Sprint(c.DaysLeft(now))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%s\t%s\n", c.Host, c.Domain, expires, days, c.Valid,
+			c.CheckedAt.Format(time.DateTime), c.Error)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"go-cf-zone-switch/cmd/internal/dbconfig"
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+)
+
+var dbCommands = []command{
+	{name: "migrate", usage: "db migrate [-dry-run]  apply pending schema migrations", run: runDbMigrate},
+	{name: "genkey", usage: "db genkey [-id name]  print a new token key entry", run: runDbGenkey},
+	{name: "reencrypt", usage: "db reencrypt  seal all tokens with the active key", run: runDbReencrypt},
+	{name: "export", usage: "db export [-o file] [-redact]  dump all data as JSON", run: runDbExport},
+	{name: "import", usage: "db import [-merge] file  load a JSON dump", run: runDbImport},
+}
+
+func runDb(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("db: missing subcommand, one of %s", commandNames(dbCommands))
+	}
+
+	for _, c := range dbCommands {
+		if c.name == args[0] {
+			return c.run(cfg, args[1:])
+		}
+	}
+
+	return fmt.Errorf("db: unknown subcommand %q, one of %s", args[0], commandNames(dbCommands))
+}
+
+func runDbMigrate(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("db migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Only show pending migrations")
+	_ = fs.Parse(args)
+
+	if *dryRun {
+		storage, err := openReadOnly(cfg)
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+
+		version, err := storage.SchemaVersion()
+		if err != nil {
+			return err
+		}
+		pending, err := storage.PendingMigrations()
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("schema version %d, latest %d, %d pending\n", version, db.SchemaVersion(), len(pending))
+		for _, m := range pending {
+			fmt.Printf("  %d %s\n", m.Version, m.Name)
+		}
+		return nil
+	}
+
+	// migrations run when the storage is opened for writing
+	storage, err := db.NewStorage(dbconfig.Options(cfg.Db))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	applied := storage.AppliedMigrations()
+	fmt.Printf("schema version %d, %d applied\n", db.SchemaVersion(), len(applied))
+	for _, m := range applied {
+		fmt.Printf("  %d %s\n", m.Version, m.Name)
+	}
+	return nil
+}
+
+func runDbGenkey(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("db genkey", flag.ExitOnError)
+	id := fs.String("id", time.Now().Format("20060102"), "Key ID")
+	_ = fs.Parse(args)
+
+	entry, err := db.GenerateTokenKey(*id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(entry)
+	return nil
+}
+
+// runDbReencrypt is the second step of a key rotation: put the new key first
+// in the keys file, keep the old one below it, reencrypt, then drop the old key
+func runDbReencrypt(cfg *config.Config, args []string) error {
+	storage, err := db.NewStorage(dbconfig.Options(cfg.Db))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	count, err := storage.ReencryptTokens()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d tokens reencrypted\n", count)
+	return nil
+}
+
+func runDbExport(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("db export", flag.ExitOnError)
+	out := fs.String("o", "", "Write the dump to this file instead of stdout")
+	redact := fs.Bool("redact", false, "Leave Cloudflare tokens out of the dump")
+	_ = fs.Parse(args)
+
+	storage, err := openReadOnly(cfg)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	dump, err := db.Export(storage, *redact)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		return db.WriteDump(os.Stdout, dump)
+	}
+
+	f, err := os.OpenFile(*out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := db.WriteDump(f, dump); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%d domains, %d tokens, %d servers, %d switch records, %d server checks exported to %s\n",
+		len(dump.Domains), len(dump.Tokens), len(dump.Servers), len(dump.SwitchHistory), len(dump.ServerChecks), *out)
+	return nil
+}
+
+func runDbImport(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("db import", flag.ExitOnError)
+	merge := fs.Bool("merge", false, "Import into a db that already has data, rows with the same keys are overwritten")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("db import: expected the dump file")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dump, err := db.ReadDump(f)
+	if err != nil {
+		return err
+	}
+
+	err = db.ImportFile(dbconfig.Options(cfg.Db), dump, *merge)
+	if errors.Is(err, db.ErrNotEmpty) {
+		return fmt.Errorf("db import: %w, use -merge to import into it", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d domains, %d tokens, %d servers, %d switch records, %d server checks imported\n",
+		len(dump.Domains), len(dump.Tokens), len(dump.Servers), len(dump.SwitchHistory), len(dump.ServerChecks))
+	if dump.Redacted {
+		fmt.Println("dump was redacted, new domains have no Cloudflare tokens until the next sync")
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go-cf-zone-switch/pkg/at"
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+)
+
+func runDomains(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("domains", flag.ExitOnError)
+	domain := fs.String("domain", "", "Show only this domain")
+	byProxy := fs.Bool("by-proxy", false, "Show how domains are distributed over proxy servers")
+	hosting := fs.String("hosting", "", "Show only domains with a backend on this IP")
+	proxy := fs.String("proxy", "", "Show only domains observed on this proxy host")
+	_ = fs.Parse(args)
+
+	storage, err := openReadOnly(cfg)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	states, err := storage.GetDnsStates()
+	if err != nil {
+		return err
+	}
+
+	if *byProxy {
+		return printDistribution(states)
+	}
+
+	var rows []db.DomainRow
+	switch {
+	case *hosting != "":
+		rows, err = storage.GetDomainsByHostingIP(*hosting)
+	case *proxy != "":
+		rows, err = storage.GetDomainsByProxy(*proxy)
+	default:
+		rows, err = storage.GetAllDomains()
+	}
+	if err != nil {
+		return err
+	}
+
+	observed := make(map[string]db.DnsState, len(states))
+	for _, s := range states {
+		observed[s.Domain] = s
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DOMAIN\tHOSTING\tTOKEN\tMODIFIED\tOBSERVED\tAIRTABLE")
+	for _, row := range rows {
+		if *domain != "" && row.Domain != *domain {
+			continue
+		}
+
+		hostings := []string{}
+		for _, b := range row.Backends() {
+			hostings = append(hostings, b.IP)
+		}
+
+		token := "no"
+		if row.CfApiToken != "" {
+			token = "yes"
+		}
+
+		modified := "-"
+		if !row.SourceModifiedAt.IsZero() {
+			modified = row.SourceModifiedAt.Format("2006-01-02 15:04")
+		}
+
+		seen := "-"
+		if s, ok := observed[row.Domain]; ok {
+			seen = fmt.Sprintf("%s at %s", s.IP, s.ObservedAt.Format("2006-01-02 15:04"))
+		}
+
+		link := at.RecordURL(cfg.At.Base, cfg.At.DomainsTable, row.DomainRecordID)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", row.Domain, strings.Join(hostings, ","), token, modified, seen, link)
+	}
+
+	return w.Flush()
+}
+
+// printDistribution prints the number of domains per proxy from the observed DNS states
+func printDistribution(states []db.DnsState) error {
+	distribution := db.ProxyDistribution(states)
+
+	proxies := make([]string, 0, len(distribution))
+	for proxy := range distribution {
+		proxies = append(proxies, proxy)
+	}
+	sort.Strings(proxies)
+
+	var oldest time.Time
+	for _, s := range states {
+		if oldest.IsZero() || s.ObservedAt.Before(oldest) {
+			oldest = s.ObservedAt
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROXY\tDOMAINS")
+	for _, proxy := range proxies {
+		name := proxy
+		if name == "" {
+			name = "not on a proxy"
+		}
+		fmt.Fprintf(w, "%s\t%d\n", name, len(distribution[proxy]))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if !oldest.IsZero() {
+		fmt.Printf("\n%d domains observed, oldest observation %s\n", len(states), oldest.Format(time.DateTime))
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/servers"
+)
+
+func runHeartbeats(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("heartbeats", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print heartbeats as JSON")
+	_ = fs.Parse(args)
+
+	storage, err := openReadOnly(cfg)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	heartbeats, err := storage.GetHeartbeats()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(heartbeats)
+	}
+
+	current, err := servers.DomainConfigVersion(storage)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("current domain config version %s\n\n", current)
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tLAST SEEN\tSERVICE\tCONNECTIONS\tCONFIG\tDISK FREE\tLOAD\tERRORS")
+	for _, hb := range heartbeats {
+		service := "-"
+		if hb.Service != "" {
+			service = hb.Service + " up"
+			if !hb.ServiceUp {
+				service = hb.Service + " down: " + hb.ServiceError
+			}
+		}
+
+		version := "-"
+		if hb.ConfigVersion != "" {
+			version = hb.ConfigVersion
+			if hb.ConfigVersion != current {
+				version += " (behind)"
+			}
+		}
+
+		disk := "-"
+		if hb.DiskTotal > 0 {
+			disk = fmt.Sprintf("%.1f%%", float64(hb.DiskFree)/float64(hb.DiskTotal)*100)
+		}
+		load := "-"
+		if len(hb.Load) == 3 {
+			load = fmt.Sprintf("%.2f %.2f %.2f", hb.Load[0], hb.Load[1], hb.Load[2])
+		}
+
+		fmt.Fprintf(w, "%s\t%s ago\t%s\t%d\t%s\t%s\t%s\t%d\n", hb.Host, now.Sub(hb.ReceivedAt).Round(time.Second),
+			service, hb.Connections, version, disk, load, len(hb.Errors))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+)
+
+func runHistory(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	since := fs.Duration("since", time.Hour*24*7, "Show switches started within this duration")
+	from := fs.String("from", "", "Start of the range, RFC3339, overrides -since")
+	to := fs.String("to", "", "End of the range, RFC3339, defaults to now")
+	domain := fs.String("domain", "", "Show only switches of this domain")
+	asJSON := fs.Bool("json", false, "Print records as JSON")
+	_ = fs.Parse(args)
+
+	end := time.Now()
+	if *to != "" {
+		t, err := time.Parse(time.RFC3339, *to)
+		if err != nil {
+			return fmt.Errorf("history: bad -to: %w", err)
+		}
+		end = t
+	}
+
+	start := end.Add(-*since)
+	if *from != "" {
+		t, err := time.Parse(time.RFC3339, *from)
+		if err != nil {
+			return fmt.Errorf("history: bad -from: %w", err)
+		}
+		start = t
+	}
+
+	storage, err := openReadOnly(cfg)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	var records []db.SwitchRecord
+	if *domain != "" {
+		records, err = storage.GetDomainSwitchHistory(*domain, start, end)
+	} else {
+		records, err = storage.GetSwitchHistory(start, end)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if *domain != "" {
+		fmt.Fprintln(w, "STARTED\tTRIGGER\tFAILED\tTARGET\tSTATUS\tOLD IP\tNEW IP\tERROR")
+		for _, r := range records {
+			d := r.Domains[0]
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.StartedAt.Format(time.DateTime), r.Trigger,
+				r.FailedServer, r.TargetServer, d.Status, d.OldIP, d.NewIP, d.Error)
+		}
+		return w.Flush()
+	}
+
+	fmt.Fprintln(w, "STARTED\tTRIGGER\tFAILED\tTARGET\tSWITCHED\tSKIPPED\tFAILED\tDURATION\tERROR")
+	for _, r := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\n", r.StartedAt.Format(time.DateTime), r.Trigger,
+			r.FailedServer, r.TargetServer, r.Count(db.OutcomeSwitched), r.Count(db.OutcomeSkipped),
+			r.Count(db.OutcomeFailed), r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond), r.Error)
+	}
+	return w.Flush()
+}
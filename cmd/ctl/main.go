@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"go-cf-zone-switch/cmd/internal/dbconfig"
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+	"go-cf-zone-switch/pkg/notifications"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(cfg *config.Config, args []string) error
+}
+
+var commands = []command{
+	{name: "sync", usage: "sync [-force] [-dry-run]  sync domains from Airtable, -force applies a held sync through the admin API", run: runSync},
+	{name: "domains", usage: "domains [-domain name] [-hosting ip] [-proxy host] [-by-proxy]  list stored domains with observed IPs and Airtable links", run: runDomains},
+	{name: "history", usage: "history [-since 168h] [-from t] [-to t] [-domain name] [-json]  show switch history", run: runHistory},
+	{name: "uptime", usage: "uptime [-window 720h] [-host h] [-outages] [-json]  show proxy uptime, MTTR and outages", run: runUptime},
+	{name: "certs", usage: "certs [-host h] [-json]  show the last certificate checks of the proxies", run: runCerts},
+	{name: "heartbeats", usage: "heartbeats [-json]  show the last heartbeats of the proxy agents and their applied config version", run: runHeartbeats},
+	{name: "servers", usage: "servers <list|add|remove|enable|disable>  manage the proxy pool of the running app", run: runServers},
+	{name: "tokens", usage: "tokens <list|label|rotate|verify>  manage stored Cloudflare tokens", run: runTokens},
+	{name: "db", usage: "db <migrate|genkey|reencrypt|export|import>  manage the bolt file", run: runDb},
+}
+
+func main() {
+	cfgPath := flag.String("config-path", "config.toml", "Set path of toml file with config")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load(*cfgPath)
+	checkErr(err)
+
+	name := flag.Arg(0)
+	for _, c := range commands {
+		if c.name == name {
+			checkErr(c.run(cfg, flag.Args()[1:]))
+			return
+		}
+	}
+
+	log.Printf("unknown command %q", name)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-config-path config.toml] <command> [args]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s\n", c.usage)
+	}
+	fmt.Fprintln(flag.CommandLine.Output(), "\nFlags:")
+	flag.PrintDefaults()
+}
+
+func getNotifier(cfg *config.Config) notifications.Notifier {
+	notifier := notifications.NewStackNotifier()
+	notifier.AddNotifier(notifications.NewTelegramNotifier(cfg))
+
+	return notifier
+}
+
+func commandNames(commands []command) string {
+	names := make([]string, 0, len(commands))
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// openReadOnly opens the db with a shared lock for inspection commands
+func openReadOnly(cfg *config.Config) (*db.DbStorage, error) {
+	opts := dbconfig.Options(cfg.Db)
+	opts.ReadOnly = true
+	return db.NewStorage(opts)
+}
+
+func checkErr(err error) {
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"go-cf-zone-switch/pkg/admin"
+	"go-cf-zone-switch/pkg/config"
+)
+
+var serverCommands = []command{
+	{name: "list", usage: "servers list  show the proxy pool of the running app with health", run: runServersList},
+	{name: "add", usage: "servers add -name n -address a -port p [-public-ips ip,ip] [...]  add a proxy to the running app", run: runServersAdd},
+	{name: "remove", usage: "servers remove name  remove a proxy with its health and check history", run: runServersRemove},
+	{name: "enable", usage: "servers enable name  monitor and switch to the proxy again", run: runServersEnable},
+	{name: "disable", usage: "servers disable name  stop monitoring and switching to the proxy", run: runServersDisable},
+}
+
+// runServers changes the pool through the admin API, the running app holds the db lock
+func runServers(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("servers: missing subcommand, one of %s", commandNames(serverCommands))
+	}
+
+	for _, c := range serverCommands {
+		if c.name == args[0] {
+			return c.run(cfg, args[1:])
+		}
+	}
+
+	return fmt.Errorf("servers: unknown subcommand %q, one of %s", args[0], commandNames(serverCommands))
+}
+
+func runServersList(cfg *config.Config, args []string) error {
+	pool, err := admin.NewClient(cfg.Admin).Proxies()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tADDRESS\tPUBLIC IPS\tENABLED\tSTATUS\tLAST CHECK\tPRIORITY\tWEIGHT\tREGION\tSOURCE")
+	for _, p := range pool {
+		proxy := config.Proxy(p.Proxy)
+		status, lastCheck := "-", "-"
+		if h := p.Health; h != nil {
+			status = "down"
+			if h.IsUp {
+				status = "up"
+			}
+			lastCheck = h.LastCheck.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\t%d\t%d\t%s\t%s\n",
+			proxy.Name, proxy.HostPort(), proxy.IP(), p.Enabled, status, lastCheck,
+			proxy.Priority, proxy.Weight, orDash(proxy.Region), p.Source)
+	}
+	return w.Flush()
+}
+
+func runServersAdd(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("servers add", flag.ExitOnError)
+	var p config.Proxy
+	fs.StringVar(&p.Name, "name", "", "Name, default address:port")
+	fs.StringVar(&p.Address, "address", "", "Address the proxy is checked and updated on")
+	fs.IntVar(&p.Port, "port", 0, "Port")
+	fs.StringVar(&p.Scheme, "scheme", "http", "http or https")
+	publicIPs := fs.String("public-ips", "", "Comma separated A record targets, default the address")
+	fs.StringVar(&p.CheckType, "check-type", "", "tcp or http, default the global check")
+	fs.IntVar(&p.Priority, "priority", 0, "Lower is preferred as failover target")
+	fs.IntVar(&p.Weight, "weight", 1, "Higher is preferred among equal priority")
+	fs.StringVar(&p.Region, "region", "", "Region")
+	tags := fs.String("tags", "", "Comma separated tags")
+	fs.IntVar(&p.MaxDomains, "max-domains", 0, "Max domains switched to the proxy, 0 is unlimited")
+	fs.BoolVar(&p.NoFailover, "no-failover", false, "Never switch domains to the proxy")
+	_ = fs.Parse(args)
+
+	p.PublicIPs = splitList(*publicIPs)
+	p.Tags = splitList(*tags)
+
+	client, err := adminClient(cfg)
+	if err != nil {
+		return err
+	}
+	added, err := client.AddProxy(p)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("proxy %s added on %s\n", added.Proxy.Name, config.Proxy(added.Proxy).HostPort())
+	return nil
+}
+
+func runServersRemove(cfg *config.Config, args []string) error {
+	name, err := serverName("servers remove", args)
+	if err != nil {
+		return err
+	}
+	client, err := adminClient(cfg)
+	if err != nil {
+		return err
+	}
+	return client.RemoveProxy(name)
+}
+
+func runServersEnable(cfg *config.Config, args []string) error {
+	name, err := serverName("servers enable", args)
+	if err != nil {
+		return err
+	}
+	client, err := adminClient(cfg)
+	if err != nil {
+		return err
+	}
+	return client.SetEnabled(name, true)
+}
+
+func runServersDisable(cfg *config.Config, args []string) error {
+	name, err := serverName("servers disable", args)
+	if err != nil {
+		return err
+	}
+	client, err := adminClient(cfg)
+	if err != nil {
+		return err
+	}
+	return client.SetEnabled(name, false)
+}
+
+func serverName(cmd string, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("%s: expected the proxy name", cmd)
+	}
+	return args[0], nil
+}
+
+func adminClient(cfg *config.Config) (*admin.Client, error) {
+	if cfg.Admin.Listen == "" {
+		return nil, fmt.Errorf("servers: [Admin] listen is not set, the running app can't be changed")
+	}
+	return admin.NewClient(cfg.Admin), nil
+}
+
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"go-cf-zone-switch/cmd/internal/dbconfig"
+	"go-cf-zone-switch/pkg/admin"
+	"go-cf-zone-switch/pkg/at"
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+)
+
+// stdoutNotifier prints notifications instead of sending them
+type stdoutNotifier struct{}
+
+func (stdoutNotifier) Notify(message string) error {
+	fmt.Println(message)
+	return nil
+}
+
+func runSync(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	force := fs.Bool("force", false, "Apply the held sync, refused when the changes differ from the held ones. "+
+		"Runs in the app through the admin API when [Admin] listen is set, otherwise the app must be stopped")
+	dryRun := fs.Bool("dry-run", false, "Print the changes against a copy of the stored domains without saving them")
+	_ = fs.Parse(args)
+
+	if *force && !*dryRun && cfg.Admin.Listen != "" {
+		if err := admin.NewClient(cfg.Admin).ForceSync(); err != nil {
+			return err
+		}
+		fmt.Println("held sync applied")
+		return nil
+	}
+
+	var storage db.Storage
+	var notifier at.Notifier
+	if *dryRun {
+		memory, err := copyDomainsToMemory(cfg)
+		if err != nil {
+			return err
+		}
+		storage, notifier = memory, stdoutNotifier{}
+	} else {
+		dbStorage, err := db.NewStorage(dbconfig.Options(cfg.Db))
+		if err != nil {
+			return err
+		}
+		defer dbStorage.Close()
+		storage, notifier = dbStorage, getNotifier(cfg)
+	}
+
+	updater := at.NewDbDomainsSync(storage, at.NewRemoteRepository(cfg.At), 0, notifier)
+	updater.Guard = at.NewSyncGuard(cfg.At.Guard.MaxRemovedPercent, cfg.At.Guard.MaxTokenLosses, cfg.At.Guard.MaxHostingChanges)
+	updater.Mode = cfg.At.SyncMode
+
+	if *force {
+		return updater.ForceSync()
+	}
+
+	return updater.Sync()
+}
+
+// copyDomainsToMemory loads the stored domains and the held sync into a MemoryStorage
+func copyDomainsToMemory(cfg *config.Config) (*db.MemoryStorage, error) {
+	storage, err := openReadOnly(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer storage.Close()
+
+	domains, err := storage.GetAllDomains()
+	if err != nil {
+		return nil, err
+	}
+
+	held, err := storage.GetHeldSync()
+	if err != nil {
+		return nil, err
+	}
+
+	memory := db.NewMemoryStorage()
+	if err := memory.SaveHeldSync(held); err != nil {
+		return nil, err
+	}
+	return memory, memory.SaveDomains(domains)
+}
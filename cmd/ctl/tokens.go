@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go-cf-zone-switch/cmd/internal/dbconfig"
+	"go-cf-zone-switch/pkg/cf"
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+)
+
+var tokenCommands = []command{
+	{name: "list", usage: "tokens list  show stored Cloudflare tokens with their domain counts", run: runTokensList},
+	{name: "label", usage: "tokens label -id tk_... -label text  name a token", run: runTokensLabel},
+	{name: "rotate", usage: "tokens rotate -id tk_...  replace the token value read from stdin for all its domains", run: runTokensRotate},
+	{name: "verify", usage: "tokens verify [-id tk_...]  check tokens with Cloudflare and store the result", run: runTokensVerify},
+}
+
+func runTokens(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("tokens: missing subcommand, one of %s", commandNames(tokenCommands))
+	}
+
+	for _, c := range tokenCommands {
+		if c.name == args[0] {
+			return c.run(cfg, args[1:])
+		}
+	}
+
+	return fmt.Errorf("tokens: unknown subcommand %q, one of %s", args[0], commandNames(tokenCommands))
+}
+
+func runTokensList(cfg *config.Config, args []string) error {
+	storage, err := openReadOnly(cfg)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	tokens, err := storage.GetTokens()
+	if err != nil {
+		return err
+	}
+
+	counts := map[string]int{}
+	err = storage.ForEachDomain(func(d db.DomainRow) error {
+		counts[d.TokenRef]++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tLABEL\tACCOUNT\tDOMAINS\tSTATUS\tVERIFIED\tVALUE")
+	for _, t := range tokens {
+		verified := "-"
+		if !t.LastVerifiedAt.IsZero() {
+			verified = t.LastVerifiedAt.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			t.ID, orDash(t.Label), orDash(t.Account), counts[t.ID], t.Status, verified, t.Value)
+	}
+	return w.Flush()
+}
+
+func runTokensLabel(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("tokens label", flag.ExitOnError)
+	id := fs.String("id", "", "Token ID")
+	label := fs.String("label", "", "Label, empty clears it")
+	_ = fs.Parse(args)
+
+	if *id == "" {
+		return fmt.Errorf("tokens label: -id is required")
+	}
+
+	storage, err := db.NewStorage(dbconfig.Options(cfg.Db))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	return storage.SetTokenLabel(*id, *label)
+}
+
+// runTokensRotate reads the new value from stdin so it stays out of the shell history
+func runTokensRotate(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("tokens rotate", flag.ExitOnError)
+	id := fs.String("id", "", "Token ID")
+	_ = fs.Parse(args)
+
+	if *id == "" {
+		return fmt.Errorf("tokens rotate: -id is required")
+	}
+
+	fmt.Fprintln(os.Stderr, "new token value:")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("tokens rotate: read value: %w", err)
+	}
+	value := strings.TrimSpace(line)
+
+	storage, err := db.NewStorage(dbconfig.Options(cfg.Db))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	if err := storage.RotateToken(*id, db.Token(value)); err != nil {
+		return err
+	}
+
+	fmt.Printf("token %s rotated, run tokens verify -id %s to check it\n", *id, *id)
+	return nil
+}
+
+func runTokensVerify(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("tokens verify", flag.ExitOnError)
+	id := fs.String("id", "", "Verify only this token")
+	_ = fs.Parse(args)
+
+	storage, err := db.NewStorage(dbconfig.Options(cfg.Db))
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	tokens, err := storage.GetTokens()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, t := range tokens {
+		if *id != "" && t.ID != *id {
+			continue
+		}
+		found = true
+
+		result, valid, err := cf.VerifyToken(t.Value.Reveal())
+		if err != nil {
+			fmt.Printf("%s  error: %v\n", t.ID, err)
+			continue
+		}
+
+		status := db.TokenActive
+		if !valid {
+			status = db.TokenInvalid
+		}
+		if err := storage.SetTokenStatus(t.ID, status, time.Now()); err != nil {
+			return err
+		}
+		fmt.Printf("%s  %s %s\n", t.ID, status, result.Status)
+	}
+
+	if *id != "" && !found {
+		return fmt.Errorf("%w: %s", db.ErrTokenNotFound, *id)
+	}
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+)
+
+func runUptime(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("uptime", flag.ExitOnError)
+	window := fs.Duration("window", time.Hour*24*30, "Compute statistics over this duration")
+	host := fs.String("host", "", "Show only this proxy host")
+	outages := fs.Bool("outages", false, "List outage intervals")
+	asJSON := fs.Bool("json", false, "Print statistics as JSON")
+	_ = fs.Parse(args)
+
+	storage, err := openReadOnly(cfg)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	hosts := []string{*host}
+	if *host == "" {
+		if hosts, err = storage.GetCheckedHosts(); err != nil {
+			return err
+		}
+		sort.Strings(hosts)
+	}
+
+	end := time.Now()
+	start := end.Add(-*window)
+
+	stats := make([]db.UptimeStats, 0, len(hosts))
+	for _, h := range hosts {
+		s, err := storage.GetServerUptime(h, start, end)
+		if err != nil {
+			return err
+		}
+		stats = append(stats, s)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tCHECKS\tUPTIME\tOUTAGES\tMTTR")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s\t%d\t%.3f%%\t%d\t%s\n", s.Host, s.Checks, s.UptimePercent, len(s.Outages), s.MTTR.Round(time.Second))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if !*outages {
+		return nil
+	}
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tSTART\tEND\tDURATION\tREASON")
+	for _, s := range stats {
+		for _, o := range s.Outages {
+			finished := "ongoing"
+			if !o.End.IsZero() {
+				finished = o.End.Format(time.DateTime)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.Host, o.Start.Format(time.DateTime), finished,
+				o.Duration(end).Round(time.Second), o.Reason)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,42 @@
+// Package dbconfig maps the [Db] config section to the options of pkg/db for the commands
+package dbconfig
+
+import (
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+)
+
+func Options(cfg config.Db) db.Options {
+	return db.Options{
+		Path:        cfg.Path,
+		OpenTimeout: time.Second * time.Duration(cfg.OpenTimeoutSec),
+		NoSync:      cfg.NoSync,
+		NoGrowSync:  cfg.NoGrowSync,
+
+		TokenKeysFile: cfg.TokenKeysFile,
+		TokenKeysEnv:  cfg.TokenKeysEnv,
+	}
+}
+
+// Retention returns the checks retention policy, 0 uses the default
+// and a negative value disables deleting or downsampling
+func Retention(cfg config.Db) db.RetentionPolicy {
+	def := db.DefaultRetention()
+	orDefault := func(value int, unit, def time.Duration) time.Duration {
+		switch {
+		case value == 0:
+			return def
+		case value < 0:
+			return 0
+		}
+		return time.Duration(value) * unit
+	}
+
+	return db.RetentionPolicy{
+		Retention:          orDefault(cfg.ChecksRetentionDays, 24*time.Hour, def.Retention),
+		DownsampleAfter:    orDefault(cfg.ChecksDownsampleAfterHours, time.Hour, def.DownsampleAfter),
+		DownsampleInterval: orDefault(cfg.ChecksDownsampleIntervalMin, time.Minute, def.DownsampleInterval),
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 
+	"go-cf-zone-switch/cmd/internal/dbconfig"
 	"go-cf-zone-switch/pkg/at"
 	"go-cf-zone-switch/pkg/config"
 	"go-cf-zone-switch/pkg/db"
@@ -19,14 +20,17 @@ func main() {
 	}
 
 	// Open storage (DB)
-	storage, err := db.NewStorage()
+	storage, err := db.NewStorage(dbconfig.Options(cfg.Db))
+	if err != nil {
+		log.Fatalf("failed to open storage: %v", err)
+	}
+	defer storage.Close()
 
 	updater := at.NewDbDomainsSync(storage, at.NewRemoteRepository(cfg.At), 0, nil)
 	err = updater.Sync()
 	if err != nil {
-		log.Fatalf("failed to open storage: %v", err)
+		log.Fatalf("failed to sync domains: %v", err)
 	}
-	defer storage.Close()
 
 	// Find a healthy proxy server
 	servers, err := storage.GetProxyServers(true)
@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+)
+
+const syncTimeout = 5 * time.Minute
+
+// Client calls the admin API of a running app
+type Client struct {
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+}
+
+// NewClient connects to the listen address of the config, an empty host means localhost
+func NewClient(cfg config.Admin) *Client {
+	address := cfg.Listen
+	if host, port, err := net.SplitHostPort(address); err == nil && (host == "" || host == "0.0.0.0" || host == "::") {
+		address = net.JoinHostPort("127.0.0.1", port)
+	}
+
+	return &Client{
+		BaseURL: "http://" + address,
+		Token:   cfg.Token,
+		HTTP:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *Client) Proxies() ([]ProxyState, error) {
+	proxies := []ProxyState{}
+	return proxies, c.do("GET", "/proxies", nil, &proxies)
+}
+
+func (c *Client) AddProxy(proxy config.Proxy) (db.PoolProxy, error) {
+	var added db.PoolProxy
+	return added, c.do("POST", "/proxies", proxy, &added)
+}
+
+func (c *Client) RemoveProxy(name string) error {
+	return c.do("DELETE", "/proxies/"+name, nil, nil)
+}
+
+func (c *Client) SetEnabled(name string, enabled bool) error {
+	action := "/enable"
+	if !enabled {
+		action = "/disable"
+	}
+	return c.do("POST", "/proxies/"+name+action, nil, nil)
+}
+
+// ForceSync applies the held domains sync in the app, Airtable is read again so it waits up to syncTimeout
+func (c *Client) ForceSync() error {
+	long := *c
+	long.HTTP = &http.Client{Timeout: syncTimeout}
+	return long.do("POST", "/sync/force", nil, nil)
+}
+
+func (c *Client) do(method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("admin: %w, is the app running with [Admin] listen set?", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("admin: %s %s returned status %d", method, path, resp.StatusCode)
+		}
+		return fmt.Errorf("admin: %s", e.Error)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,183 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"go-cf-zone-switch/pkg/at"
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+	"go-cf-zone-switch/pkg/httpserver"
+	"go-cf-zone-switch/pkg/servers"
+)
+
+// Server is the HTTP API changing the proxy pool at runtime.
+// Every request needs the "Authorization: Bearer <token>" header.
+//
+//	GET    /proxies                list the pool with the health of the proxies
+//	POST   /proxies                add a proxy, the body is a proxy object
+//	DELETE /proxies/{name}         remove a proxy with its health and check history
+//	POST   /proxies/{name}/enable  start monitoring and switching to the proxy
+//	POST   /proxies/{name}/disable stop monitoring and switching to the proxy
+//	POST   /sync/force             apply the domains sync held by the guard
+type Server struct {
+	listen  string
+	token   string
+	pool    *servers.Pool
+	storage db.Storage // health of the pooled proxies
+	sync    ForceSyncer
+}
+
+// ProxyState is a pooled proxy with its last health check, no health before the first check
+type ProxyState struct {
+	db.PoolProxy
+	Health *db.ProxyServerRow `json:"health,omitempty"`
+}
+
+// ForceSyncer applies a held domains sync, implemented by at.DbDomainsUpdater
+type ForceSyncer interface {
+	ForceSync() error
+}
+
+func NewServer(cfg config.Admin, pool *servers.Pool, storage db.Storage) *Server {
+	return &Server{listen: cfg.Listen, token: cfg.Token, pool: pool, storage: storage}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /proxies", s.listProxies)
+	mux.HandleFunc("POST /proxies", s.addProxy)
+	mux.HandleFunc("DELETE /proxies/{name}", s.removeProxy)
+	mux.HandleFunc("POST /proxies/{name}/enable", s.setEnabled(true))
+	mux.HandleFunc("POST /proxies/{name}/disable", s.setEnabled(false))
+	mux.HandleFunc("POST /sync/force", s.forceSync)
+	return s.authorize(mux)
+}
+
+// UseSync lets the API force held syncs of the running domains sync
+func (s *Server) UseSync(sync ForceSyncer) {
+	s.sync = sync
+}
+
+// Start serves the API until the context is canceled
+func (s *Server) Start(ctx context.Context) {
+	httpserver.Start(ctx, "admin", s.listen, s.Handler())
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) listProxies(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.storage.GetProxyServers(false)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	health := make(map[string]db.ProxyServerRow, len(rows))
+	for _, row := range rows {
+		health[row.Host] = row
+	}
+
+	states := []ProxyState{}
+	for _, p := range s.pool.List() {
+		state := ProxyState{PoolProxy: p}
+		if h, ok := health[p.Proxy.Address]; ok {
+			state.Health = &h
+		}
+		states = append(states, state)
+	}
+	writeJSON(w, http.StatusOK, states)
+}
+
+func (s *Server) addProxy(w http.ResponseWriter, r *http.Request) {
+	var proxy config.Proxy
+	if err := json.NewDecoder(r.Body).Decode(&proxy); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.pool.Add(proxy); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	// the pool fills in defaults, answer with the stored proxy
+	for _, p := range s.pool.List() {
+		if p.Proxy.Address == proxy.Address {
+			writeJSON(w, http.StatusCreated, p)
+			return
+		}
+	}
+}
+
+func (s *Server) removeProxy(w http.ResponseWriter, r *http.Request) {
+	if err := s.pool.Remove(r.PathValue("name")); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) setEnabled(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.pool.SetEnabled(r.PathValue("name"), enabled); err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) forceSync(w http.ResponseWriter, r *http.Request) {
+	if s.sync == nil {
+		writeError(w, http.StatusNotFound, errors.New("no domains sync running"))
+		return
+	}
+	if err := s.sync.ForceSync(); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// statusOf maps pool and sync errors to HTTP statuses
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, servers.ErrInvalidProxy):
+		return http.StatusBadRequest
+	case errors.Is(err, servers.ErrProxyNotFound), errors.Is(err, at.ErrNoHeldSync):
+		return http.StatusNotFound
+	case errors.Is(err, servers.ErrProxyExists), errors.Is(err, at.ErrSyncHeld), errors.Is(err, at.ErrHeldSyncChanged):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("admin: failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-cf-zone-switch/pkg/at"
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+	"go-cf-zone-switch/pkg/servers"
+)
+
+func newTestServer(t *testing.T) (*Client, *servers.Pool, db.Storage) {
+	storage := db.NewMemoryStorage()
+	pool, err := servers.NewPool(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewServer(config.Admin{Token: "secret"}, pool, storage).Handler())
+	t.Cleanup(srv.Close)
+
+	client := NewClient(config.Admin{Listen: strings.TrimPrefix(srv.URL, "http://"), Token: "secret"})
+	return client, pool, storage
+}
+
+func TestServer_ManagesPool(t *testing.T) {
+	client, pool, storage := newTestServer(t)
+
+	added, err := client.AddProxy(config.Proxy{Address: "10.0.0.1", Port: 5214, Region: "eu"})
+	if err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	if added.Proxy.Name != "10.0.0.1:5214" || added.Proxy.Scheme != "http" || !added.Enabled || added.Source != db.PoolSourceApi {
+		t.Errorf("Expected the stored proxy with defaults, got %+v", added)
+	}
+
+	if err := client.SetEnabled(added.Proxy.Name, false); err != nil {
+		t.Errorf("Failed to disable: %v", err)
+	}
+	_ = storage.SaveProxyServers([]db.ProxyServerRow{{Host: "10.0.0.1", IsUp: true}})
+	proxies, err := client.Proxies()
+	if err != nil || len(proxies) != 1 || proxies[0].Enabled || proxies[0].Proxy.Region != "eu" || proxies[0].Health == nil || !proxies[0].Health.IsUp {
+		t.Errorf("Expected the disabled proxy listed with its health, got %+v, %v", proxies, err)
+	}
+
+	if err := client.RemoveProxy(added.Proxy.Name); err != nil {
+		t.Errorf("Failed to remove: %v", err)
+	}
+	if len(pool.List()) != 0 {
+		t.Errorf("Expected an empty pool, got %+v", pool.List())
+	}
+}
+
+func TestServer_Errors(t *testing.T) {
+	client, _, _ := newTestServer(t)
+	_, _ = client.AddProxy(config.Proxy{Name: "fra", Address: "10.0.0.1", Port: 80})
+
+	if _, err := client.AddProxy(config.Proxy{Name: "fra", Address: "10.0.0.2", Port: 80}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected a conflict, got %v", err)
+	}
+	if _, err := client.AddProxy(config.Proxy{Address: "proxy.internal", Port: 80}); err == nil || !strings.Contains(err.Error(), "public_ips is required") {
+		t.Errorf("Expected a validation error, got %v", err)
+	}
+	if err := client.SetEnabled("missing", true); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected not found, got %v", err)
+	}
+
+	client.Token = "wrong"
+	if _, err := client.Proxies(); err == nil || !strings.Contains(err.Error(), "invalid token") {
+		t.Errorf("Expected an unauthorized error, got %v", err)
+	}
+
+	resp, err := http.Get(client.BaseURL + "/proxies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", resp.StatusCode)
+	}
+}
+
+type syncFunc func() error
+
+func (f syncFunc) ForceSync() error { return f() }
+
+func TestServer_ForceSync(t *testing.T) {
+	storage := db.NewMemoryStorage()
+	pool, _ := servers.NewPool(storage)
+	server := NewServer(config.Admin{Token: "secret"}, pool, storage)
+	srv := httptest.NewServer(server.Handler())
+	defer srv.Close()
+	client := NewClient(config.Admin{Listen: strings.TrimPrefix(srv.URL, "http://"), Token: "secret"})
+
+	if err := client.ForceSync(); err == nil || !strings.Contains(err.Error(), "no domains sync running") {
+		t.Errorf("Expected an error without a sync, got %v", err)
+	}
+
+	forced := 0
+	server.UseSync(syncFunc(func() error {
+		forced++
+		if forced > 1 {
+			return at.ErrNoHeldSync
+		}
+		return nil
+	}))
+	if err := client.ForceSync(); err != nil || forced != 1 {
+		t.Errorf("Expected the held sync forced, got %v", err)
+	}
+	if err := client.ForceSync(); err == nil || !strings.Contains(err.Error(), at.ErrNoHeldSync.Error()) {
+		t.Errorf("Expected the sync error returned, got %v", err)
+	}
+}
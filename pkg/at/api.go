@@ -12,6 +12,7 @@ import (
 
 const (
 	apiV0              = "https://api.airtable.com/v0"
+	webURL             = "https://airtable.com"
 	fieldApiKey        = "API Key CF (from Domain)"
 	fieldDomainReqIDs  = "Domain"
 	fieldHostingReqIDs = "Hosting"
@@ -19,16 +20,24 @@ const (
 	fieldsDomainTblCFApiToken = "API Key CF"
 	fieldsDomainTblDomain     = "Domain"
 	fieldsDomainTblHostingIDs = "Hosting"
+
+	fieldsHostingTblIP     = "IP"
+	fieldsHostingTblWeight = "Weight"
+
+	// maxIDsPerFormula keeps RECORD_ID() filter formulas within URL length limits
+	maxIDsPerFormula = 50
 )
 
 type Client struct {
 	cfg        AtConfig
 	httpClient *http.Client
+	apiURL     string
 }
 
 func NewClient(cfg AtConfig) *Client {
 	return &Client{
-		cfg: cfg,
+		cfg:    cfg,
+		apiURL: apiV0,
 		httpClient: &http.Client{
 			Timeout: time.Second * 30,
 		},
@@ -36,7 +45,7 @@ func NewClient(cfg AtConfig) *Client {
 }
 
 func (c *Client) makeRequest(reqType, tbl, view string) (*http.Request, error) {
-	url := fmt.Sprintf("%s/%s/%s", apiV0, c.cfg.GetBase(), tbl)
+	url := fmt.Sprintf("%s/%s/%s", c.apiURL, c.cfg.GetBase(), tbl)
 
 	req, err := http.NewRequest(reqType, url, nil)
 
@@ -235,17 +244,64 @@ func (c *Client) GetDomain(reqID string) (string, string, error) {
 		return "", "", err
 	}
 
-	if record, ok := domains[reqID]; ok {
-		return record.Domain, record.HostingID, nil
+	record, ok := domains[reqID]
+	if !ok {
+		return "", "", fmt.Errorf("no data")
+	}
+
+	hostingID := ""
+	if len(record.HostingIDs) > 0 {
+		hostingID = record.HostingIDs[0]
 	}
 
-	return "", "", fmt.Errorf("no data")
+	return record.Domain, hostingID, nil
 }
 
 type domainRecord struct {
+	ID         string
 	Domain     string
-	HostingID  string
+	HostingIDs []string // linked hosting records in Airtable order
 	CfApiToken string
+	ModifiedAt time.Time
+}
+
+// domainFields returns the fields requested from the domains table
+func (c *Client) domainFields() []string {
+	fields := []string{fieldsDomainTblDomain, fieldsDomainTblHostingIDs, fieldsDomainTblCFApiToken}
+	if f := c.cfg.GetDomainsModifiedField(); f != "" {
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// getModifiedAt reads the last modified time field, zero when it is not configured or missing
+func (c *Client) getModifiedAt(record Record) time.Time {
+	if f := c.cfg.GetDomainsModifiedField(); f != "" {
+		if v, ok := record.Fields[f].(string); ok {
+			if t, err := time.Parse(time.RFC3339, v); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+type hostingRecord struct {
+	IP     string
+	Weight int
+}
+
+// getHostingIDs returns all linked hosting record IDs of a domain record
+func getHostingIDs(record Record) []string {
+	ids := []string{}
+	if hostings, ok := record.Fields[fieldsDomainTblHostingIDs].([]interface{}); ok {
+		for _, h := range hostings {
+			if hostingID, ok := h.(string); ok {
+				ids = append(ids, hostingID)
+			}
+		}
+	}
+	return ids
 }
 
 func (c *Client) multiDomainRequest(reqIDs []string) (map[string]domainRecord, error) {
@@ -263,7 +319,7 @@ func (c *Client) multiDomainRequest(reqIDs []string) (map[string]domainRecord, e
 	// Fetch all pages
 	params := map[string][]string{
 		"filterByFormula": {formula},
-		"fields[]":        {fieldsDomainTblDomain, fieldsDomainTblHostingIDs},
+		"fields[]":        c.domainFields(),
 	}
 
 	for {
@@ -278,7 +334,10 @@ func (c *Client) multiDomainRequest(reqIDs []string) (map[string]domainRecord, e
 
 		// Process records from this page
 		for _, record := range page.Records {
-			var dr domainRecord
+			dr := domainRecord{
+				ID:         record.ID,
+				ModifiedAt: c.getModifiedAt(record),
+			}
 
 			// Get domain name
 			if domain, ok := record.Fields[fieldsDomainTblDomain].(string); ok {
@@ -286,12 +345,10 @@ func (c *Client) multiDomainRequest(reqIDs []string) (map[string]domainRecord, e
 			}
 
 			// Get hosting IDs
-			if hostings, ok := record.Fields[fieldsDomainTblHostingIDs].([]interface{}); ok {
-				for _, h := range hostings {
-					if hostingID, ok := h.(string); ok {
-						dr.HostingID = hostingID
-					}
-				}
+			dr.HostingIDs = getHostingIDs(record)
+
+			if cfApiToken, ok := record.Fields[fieldsDomainTblCFApiToken].(string); ok {
+				dr.CfApiToken = cfApiToken
 			}
 
 			result[record.ID] = dr
@@ -310,15 +367,21 @@ func (c *Client) multiDomainRequest(reqIDs []string) (map[string]domainRecord, e
 
 // GetDomains returns maps of reqIDs to domains and their hosting IDs
 func (c *Client) GetDomains(reqIDs []string) (map[string]domainRecord, error) {
-	domains, err := c.multiDomainRequest(reqIDs)
-	if err != nil {
-		return nil, err
+	domains := make(map[string]domainRecord)
+	for _, chunk := range chunkIDs(reqIDs) {
+		records, err := c.multiDomainRequest(chunk)
+		if err != nil {
+			return nil, err
+		}
+		for id, record := range records {
+			domains[id] = record
+		}
 	}
 	return domains, nil
 }
 
-func (c *Client) multiHostingRequest(reqIDs []string) (map[string]string, error) {
-	result := make(map[string]string)
+func (c *Client) multiHostingRequest(reqIDs []string) (map[string]hostingRecord, error) {
+	result := make(map[string]hostingRecord)
 
 	// Prepare the filter formula
 	var parts []string
@@ -332,7 +395,7 @@ func (c *Client) multiHostingRequest(reqIDs []string) (map[string]string, error)
 	// Fetch all pages
 	params := map[string][]string{
 		"filterByFormula": {formula},
-		"fields[]":        {"IP"},
+		"fields[]":        {fieldsHostingTblIP, fieldsHostingTblWeight},
 	}
 
 	for {
@@ -347,8 +410,12 @@ func (c *Client) multiHostingRequest(reqIDs []string) (map[string]string, error)
 
 		// Process records from this page
 		for _, record := range page.Records {
-			if ip, ok := record.Fields["IP"].(string); ok {
-				result[record.ID] = ip
+			if ip, ok := record.Fields[fieldsHostingTblIP].(string); ok {
+				hr := hostingRecord{IP: ip}
+				if weight, ok := record.Fields[fieldsHostingTblWeight].(float64); ok {
+					hr.Weight = int(weight)
+				}
+				result[record.ID] = hr
 			}
 		}
 
@@ -363,15 +430,41 @@ func (c *Client) multiHostingRequest(reqIDs []string) (map[string]string, error)
 	return result, nil
 }
 
-// GetHostingByIds returns a map of reqIDs to hosting IPs
-func (c *Client) GetHostingByIds(reqIDs []string) (map[string]string, error) {
-	hostings, err := c.multiHostingRequest(reqIDs)
-	if err != nil {
-		return nil, err
+// GetHostingByIds returns a map of reqIDs to hosting records
+func (c *Client) GetHostingByIds(reqIDs []string) (map[string]hostingRecord, error) {
+	hostings := make(map[string]hostingRecord)
+	for _, chunk := range chunkIDs(reqIDs) {
+		records, err := c.multiHostingRequest(chunk)
+		if err != nil {
+			return nil, err
+		}
+		for id, record := range records {
+			hostings[id] = record
+		}
 	}
 	return hostings, nil
 }
 
+// chunkIDs splits unique non empty record IDs into chunks of maxIDsPerFormula
+func chunkIDs(reqIDs []string) [][]string {
+	seen := make(map[string]bool, len(reqIDs))
+	unique := []string{}
+	for _, id := range reqIDs {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		unique = append(unique, id)
+	}
+
+	chunks := [][]string{}
+	for i := 0; i < len(unique); i += maxIDsPerFormula {
+		end := min(i+maxIDsPerFormula, len(unique))
+		chunks = append(chunks, unique[i:end])
+	}
+	return chunks
+}
+
 // FetchAllDomains retrieves all domain records from the domains table
 func (c *Client) FetchAllDomains() ([]domainRecord, error) {
 	var records []domainRecord
@@ -382,7 +475,7 @@ func (c *Client) FetchAllDomains() ([]domainRecord, error) {
 			Table:  c.cfg.GetDomainsTable(),
 			Offset: offset,
 			Params: map[string][]string{
-				"fields[]": {fieldsDomainTblDomain, fieldsDomainTblHostingIDs, fieldsDomainTblCFApiToken},
+				"fields[]": c.domainFields(),
 			},
 		})
 		if err != nil {
@@ -391,19 +484,18 @@ func (c *Client) FetchAllDomains() ([]domainRecord, error) {
 
 		// Process records from this page
 		for _, record := range page.Records {
-			var dr domainRecord
+			dr := domainRecord{
+				ID:         record.ID,
+				ModifiedAt: c.getModifiedAt(record),
+			}
 
 			// Get domain name
 			if domain, ok := record.Fields[fieldsDomainTblDomain].(string); ok {
 				dr.Domain = domain
 			}
 
-			// Get hosting IDs (take the first one if exists)
-			if hostings, ok := record.Fields[fieldsDomainTblHostingIDs].([]interface{}); ok && len(hostings) > 0 {
-				if hostingID, ok := hostings[0].(string); ok {
-					dr.HostingID = hostingID
-				}
-			}
+			// Get all hosting IDs, the first one is the primary
+			dr.HostingIDs = getHostingIDs(record)
 
 			if cfApiToken, ok := record.Fields[fieldsDomainTblCFApiToken].(string); ok && len(cfApiToken) > 0 {
 				// Just to avoid unused variable warning
@@ -7,4 +7,5 @@ type AtConfig interface {
 	GetAccountTable() string
 	GetAccountView() string
 	GetApiToken() string
+	GetDomainsModifiedField() string
 }
@@ -0,0 +1,100 @@
+package at
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go-cf-zone-switch/pkg/db"
+)
+
+const (
+	defaultMaxRemovedPercent = 10
+	defaultMaxTokenLosses    = 10
+	defaultMaxHostingChanges = 20
+)
+
+var (
+	ErrSyncHeld        = errors.New("domains sync held by guard")
+	ErrNoHeldSync      = errors.New("no held domains sync to force")
+	ErrHeldSyncChanged = errors.New("domains sync changed since it was held, review the new changes")
+)
+
+// SyncGuard limits how much a single sync may change the stored domains.
+// Zero values fall back to defaults, negative values disable a limit.
+type SyncGuard struct {
+	MaxRemovedPercent float64
+	MaxTokenLosses    int
+	MaxHostingChanges int
+}
+
+func NewSyncGuard(maxRemovedPercent float64, maxTokenLosses, maxHostingChanges int) SyncGuard {
+	g := SyncGuard{
+		MaxRemovedPercent: maxRemovedPercent,
+		MaxTokenLosses:    maxTokenLosses,
+		MaxHostingChanges: maxHostingChanges,
+	}
+
+	if g.MaxRemovedPercent == 0 {
+		g.MaxRemovedPercent = defaultMaxRemovedPercent
+	}
+	if g.MaxTokenLosses == 0 {
+		g.MaxTokenLosses = defaultMaxTokenLosses
+	}
+	if g.MaxHostingChanges == 0 {
+		g.MaxHostingChanges = defaultMaxHostingChanges
+	}
+
+	return g
+}
+
+// SyncHeldError is returned when the guard rejects a sync
+type SyncHeldError struct {
+	Diff       db.DomainsDiff
+	Violations []string
+}
+
+func (e *SyncHeldError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrSyncHeld, strings.Join(e.Violations, "; "))
+}
+
+func (e *SyncHeldError) Is(target error) bool {
+	return target == ErrSyncHeld
+}
+
+// Check returns a *SyncHeldError if the diff exceeds any of the limits
+func (g SyncGuard) Check(diff db.DomainsDiff) error {
+	violations := []string{}
+
+	if g.MaxRemovedPercent >= 0 && diff.Previous > 0 {
+		removedPercent := float64(len(diff.Removed)) * 100 / float64(diff.Previous)
+		if removedPercent > g.MaxRemovedPercent {
+			violations = append(violations, fmt.Sprintf("%d of %d domains removed (%.1f%%, max %.1f%%)",
+				len(diff.Removed), diff.Previous, removedPercent, g.MaxRemovedPercent))
+		}
+	}
+
+	tokenLosses, hostingChanges := 0, 0
+	for _, c := range diff.Changed {
+		if c.Old.CfApiToken != "" && c.New.CfApiToken == "" {
+			tokenLosses++
+		}
+		if c.HostingChanged() {
+			hostingChanges++
+		}
+	}
+
+	if g.MaxTokenLosses >= 0 && tokenLosses > g.MaxTokenLosses {
+		violations = append(violations, fmt.Sprintf("%d domains lose their token (max %d)", tokenLosses, g.MaxTokenLosses))
+	}
+
+	if g.MaxHostingChanges >= 0 && hostingChanges > g.MaxHostingChanges {
+		violations = append(violations, fmt.Sprintf("%d hosting IP changes (max %d)", hostingChanges, g.MaxHostingChanges))
+	}
+
+	if len(violations) > 0 {
+		return &SyncHeldError{Diff: diff, Violations: violations}
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package at
+
+import (
+	"errors"
+	"testing"
+
+	"go-cf-zone-switch/pkg/db"
+)
+
+func TestSyncGuard_EmptySourceHeld(t *testing.T) {
+	current := []db.DomainRow{{Domain: "a.com"}, {Domain: "b.com"}}
+	diff := db.DiffDomains(current, nil)
+
+	err := NewSyncGuard(0, 0, 0).Check(diff)
+	if !errors.Is(err, ErrSyncHeld) {
+		t.Fatalf("Expected sync to be held, got %v", err)
+	}
+
+	var held *SyncHeldError
+	if !errors.As(err, &held) || len(held.Diff.Removed) != 2 {
+		t.Errorf("Expected held error to carry the diff, got %+v", err)
+	}
+}
+
+func TestSyncGuard_Limits(t *testing.T) {
+	current := []db.DomainRow{
+		{Domain: "a.com", HostingIP: "1.1.1.1", CfApiToken: "t"},
+		{Domain: "b.com", HostingIP: "1.1.1.1", CfApiToken: "t"},
+	}
+	incoming := []db.DomainRow{
+		{Domain: "a.com", HostingIP: "2.2.2.2", CfApiToken: "t"},
+		{Domain: "b.com", HostingIP: "1.1.1.1"},
+	}
+	diff := db.DiffDomains(current, incoming)
+
+	if err := NewSyncGuard(0, 1, 1).Check(diff); err != nil {
+		t.Errorf("Expected diff within limits, got %v", err)
+	}
+
+	var held *SyncHeldError
+	if err := NewSyncGuard(0, -1, 0).Check(diff); err != nil {
+		t.Errorf("Expected disabled token guard to pass, got %v", err)
+	}
+	if err := (SyncGuard{MaxRemovedPercent: -1, MaxTokenLosses: 0, MaxHostingChanges: 0}).Check(diff); !errors.As(err, &held) || len(held.Violations) != 2 {
+		t.Errorf("Expected token and hosting violations, got %v", err)
+	}
+}
+
+func TestSyncGuard_Disabled(t *testing.T) {
+	diff := db.DiffDomains([]db.DomainRow{{Domain: "a.com"}}, nil)
+
+	if err := NewSyncGuard(-1, -1, -1).Check(diff); err != nil {
+		t.Errorf("Expected disabled guard to pass, got %v", err)
+	}
+}
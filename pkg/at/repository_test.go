@@ -0,0 +1,252 @@
+package at
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go-cf-zone-switch/pkg/db"
+)
+
+const testPageSize = 2
+
+var recordIDRe = regexp.MustCompile(`RECORD_ID\(\)='([^']+)'`)
+
+type testAtConfig struct{}
+
+func (testAtConfig) GetBase() string                 { return "appTest" }
+func (testAtConfig) GetDomainsTable() string         { return "tblDomains" }
+func (testAtConfig) GetHostingTable() string         { return "tblHosting" }
+func (testAtConfig) GetAccountTable() string         { return "tblAccounts" }
+func (testAtConfig) GetAccountView() string          { return "viwAccounts" }
+func (testAtConfig) GetApiToken() string             { return "patTest" }
+func (testAtConfig) GetDomainsModifiedField() string { return "Last Modified" }
+
+// fakeAirtable is a local stand-in for the Airtable records API.
+// It supports views, RECORD_ID() filter formulas and offset pagination.
+type fakeAirtable struct {
+	tables map[string][]Record
+	views  map[string][]string // view ID -> record IDs in view order
+}
+
+func (f *fakeAirtable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer patTest" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":{"type":"AUTHENTICATION_REQUIRED","message":"bad token"}}`))
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] != "appTest" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	records, ok := f.tables[parts[1]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+
+	if view := query.Get("view"); view != "" {
+		byID := map[string]Record{}
+		for _, rec := range records {
+			byID[rec.ID] = rec
+		}
+		records = []Record{}
+		for _, id := range f.views[view] {
+			records = append(records, byID[id])
+		}
+	}
+
+	if formula := query.Get("filterByFormula"); formula != "" {
+		ids := map[string]bool{}
+		for _, m := range recordIDRe.FindAllStringSubmatch(formula, -1) {
+			ids[m[1]] = true
+		}
+		filtered := []Record{}
+		for _, rec := range records {
+			if ids[rec.ID] {
+				filtered = append(filtered, rec)
+			}
+		}
+		records = filtered
+	}
+
+	start, _ := strconv.Atoi(query.Get("offset"))
+	end := min(start+testPageSize, len(records))
+
+	resp := AirtableResponse{Records: records[start:end]}
+	if end < len(records) {
+		resp.Offset = strconv.Itoa(end)
+	}
+
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func newTestRepository(t *testing.T) *RemoteRepository {
+	fake := &fakeAirtable{
+		tables: map[string][]Record{
+			"tblDomains": {
+				{ID: "recD1", Fields: map[string]interface{}{"Domain": "one.com", "Hosting": []interface{}{"recH1", "recH2"}, "API Key CF": "domain-token-1"}},
+				{ID: "recD2", Fields: map[string]interface{}{"Domain": "two.com ", "Hosting": []interface{}{"recH2"}}},
+				{ID: "recD3", Fields: map[string]interface{}{"Domain": "three.com", "Hosting": []interface{}{"recH1"}, "API Key CF": "domain-token-3"}},
+				{ID: "recD4", Fields: map[string]interface{}{"Domain": "orphan.com", "API Key CF": "domain-token-4"}},
+			},
+			"tblHosting": {
+				{ID: "recH1", Fields: map[string]interface{}{"IP": "10.0.0.1"}},
+				{ID: "recH2", Fields: map[string]interface{}{"IP": "10.0.0.2", "Weight": float64(3)}},
+			},
+			"tblAccounts": {
+				{ID: "recA1", Fields: map[string]interface{}{"API Key CF (from Domain)": []interface{}{"account-token-1"}, "Domain": []interface{}{"recD1", "recD2"}}},
+				{ID: "recA2", Fields: map[string]interface{}{"Domain": []interface{}{"recD3"}}},
+				{ID: "recA3", Fields: map[string]interface{}{"API Key CF (from Domain)": []interface{}{"account-token-3"}, "Domain": []interface{}{"recD2"}}},
+				{ID: "recA4", Fields: map[string]interface{}{"API Key CF (from Domain)": []interface{}{"hidden-token"}, "Domain": []interface{}{"recD4"}}},
+			},
+		},
+		views: map[string][]string{
+			"viwAccounts": {"recA1", "recA2", "recA3"},
+		},
+	}
+
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	repo := NewRemoteRepository(testAtConfig{})
+	repo.client.apiURL = srv.URL
+
+	return repo
+}
+
+func domainsByName(domains []AtDomain) map[string]AtDomain {
+	m := map[string]AtDomain{}
+	for _, d := range domains {
+		m[d.Domain] = d
+	}
+	return m
+}
+
+func TestRemoteRepository_GetAllDomains(t *testing.T) {
+	repo := newTestRepository(t)
+
+	domains, err := repo.GetAllDomains()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	byName := domainsByName(domains)
+	if len(byName) != 4 {
+		t.Fatalf("Expected 4 domains, got %+v", domains)
+	}
+
+	one := byName["one.com"]
+	if one.CfApiToken != "domain-token-1" || one.HostingIP != "10.0.0.1" {
+		t.Errorf("Unexpected one.com %+v", one)
+	}
+	if len(one.Hostings) != 2 || one.Hostings[1].IP != "10.0.0.2" || one.Hostings[1].Weight != 3 {
+		t.Errorf("Expected both backends for one.com in link order, got %+v", one.Hostings)
+	}
+
+	if two, ok := byName["two.com"]; !ok || two.CfApiToken != "" {
+		t.Errorf("Expected cleaned two.com without token, got %+v", two)
+	}
+}
+
+func TestRemoteRepository_GetAllDomainsForIpChange(t *testing.T) {
+	repo := newTestRepository(t)
+
+	domains, err := repo.GetAllDomainsForIpChange()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	names := []string{}
+	for _, d := range domains {
+		names = append(names, d.Domain)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "one.com,three.com,two.com" {
+		t.Fatalf("Expected only domains of accounts in view, got %v", names)
+	}
+
+	byName := domainsByName(domains)
+
+	// account token wins over the domain record token
+	if got := byName["one.com"].CfApiToken; got != "account-token-1" {
+		t.Errorf("Expected account token for one.com, got %s", got)
+	}
+	// domain record token is used when the account has none
+	if got := byName["three.com"].CfApiToken; got != "domain-token-3" {
+		t.Errorf("Expected domain token fallback for three.com, got %s", got)
+	}
+	// the first account in view order wins
+	if got := byName["two.com"].CfApiToken; got != "account-token-1" {
+		t.Errorf("Expected first account token for two.com, got %s", got)
+	}
+	if got := byName["two.com"].HostingIP; got != "10.0.0.2" {
+		t.Errorf("Expected hosting for two.com, got %s", got)
+	}
+}
+
+func TestDbDomainsUpdater_FetchDomainsMode(t *testing.T) {
+	repo := newTestRepository(t)
+
+	cases := map[string]int{SyncModeDomains: 4, SyncModeAccounts: 3, "": 4}
+	for mode, expected := range cases {
+		updater := NewDbDomainsSync(nil, repo, 0, nil)
+		updater.Mode = mode
+
+		domains, err := updater.fetchDomains()
+		if err != nil {
+			t.Fatalf("Mode %q: unexpected error: %v", mode, err)
+		}
+		if len(domains) != expected {
+			t.Errorf("Mode %q: expected %d domains, got %d", mode, expected, len(domains))
+		}
+	}
+
+	updater := NewDbDomainsSync(nil, repo, 0, nil)
+	updater.Mode = "unknown"
+	if _, err := updater.fetchDomains(); err == nil {
+		t.Error("Expected error for unknown sync mode")
+	}
+}
+
+func TestDbDomainsUpdater_ForceOnlyHeldSync(t *testing.T) {
+	storage := db.NewMemoryStorage()
+	_ = storage.SaveDomains([]db.DomainRow{{Domain: "stale-1.com", HostingIP: "10.0.0.9"}, {Domain: "stale-2.com", HostingIP: "10.0.0.9"}})
+
+	updater := NewDbDomainsSync(storage, newTestRepository(t), 0, nil)
+	if err := updater.ForceSync(); !errors.Is(err, ErrNoHeldSync) {
+		t.Fatalf("Expected nothing to force before a held sync, got %v", err)
+	}
+	if err := updater.Sync(); !errors.Is(err, ErrSyncHeld) {
+		t.Fatalf("Expected the removals to be held, got %v", err)
+	}
+
+	_ = storage.SaveDomains([]db.DomainRow{{Domain: "stale-3.com", HostingIP: "10.0.0.9"}})
+	if err := updater.ForceSync(); !errors.Is(err, ErrHeldSyncChanged) {
+		t.Fatalf("Expected force to refuse changes other than the held ones, got %v", err)
+	}
+	if all, _ := storage.GetAllDomains(); len(all) != 3 {
+		t.Errorf("Expected nothing applied, got %d domains", len(all))
+	}
+
+	if err := updater.ForceSync(); err != nil {
+		t.Fatalf("Expected the reheld sync to be forced, got %v", err)
+	}
+	if all, _ := storage.GetAllDomains(); len(all) != 4 {
+		t.Errorf("Expected the Airtable domains only, got %+v", all)
+	}
+	if held, _ := storage.GetHeldSync(); held != "" {
+		t.Errorf("Expected the held sync cleared, got %q", held)
+	}
+}
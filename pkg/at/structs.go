@@ -1,18 +1,71 @@
 package at
 
 import (
+	"fmt"
+	"log"
 	"os"
 	"regexp"
+	"time"
+
+	"go-cf-zone-switch/pkg/db"
+)
+
+const (
+	SyncModeDomains  = "domains"  // tokens are read from the domains table
+	SyncModeAccounts = "accounts" // tokens are resolved through the accounts table and view
 )
 
+var domainCleanRe = regexp.MustCompile(`[^a-zA-Z0-9.-]`)
+
+func cleanDomain(domain string) string {
+	return domainCleanRe.ReplaceAllString(domain, "")
+}
+
 type Repository interface {
 	GetAllDomains() ([]AtDomain, error)
 }
 
 type AtDomain struct {
 	Domain     string
-	CfApiToken string
-	HostingIP  string
+	CfApiToken db.Token
+	HostingIP  string // primary hosting, the first of Hostings
+
+	Hostings []AtHosting
+
+	RecordID        string    // domains table record
+	AccountRecordID string    // accounts table record, only in accounts sync mode
+	ModifiedAt      time.Time // last modification of the domain record
+}
+
+type AtHosting struct {
+	IP       string
+	Weight   int
+	RecordID string // hosting table record
+}
+
+// resolveHostings maps linked hosting record IDs to hostings, keeping the link order
+func resolveHostings(hostingIDs []string, hostingRecords map[string]hostingRecord) []AtHosting {
+	hostings := []AtHosting{}
+	for _, id := range hostingIDs {
+		if hr, ok := hostingRecords[id]; ok {
+			hostings = append(hostings, AtHosting{IP: hr.IP, Weight: hr.Weight, RecordID: id})
+		}
+	}
+	return hostings
+}
+
+func newAtDomain(record domainRecord, cfApiToken string, hostings []AtHosting) AtDomain {
+	atDomain := AtDomain{
+		Domain:     cleanDomain(record.Domain),
+		CfApiToken: db.Token(cfApiToken),
+		Hostings:   hostings,
+		RecordID:   record.ID,
+		ModifiedAt: record.ModifiedAt,
+	}
+	if len(hostings) > 0 {
+		atDomain.HostingIP = hostings[0].IP
+	}
+	return atDomain
 }
 
 type LocalRepository struct {
@@ -23,7 +76,7 @@ func (l *LocalRepository) GetAllDomains() ([]AtDomain, error) {
 	return []AtDomain{
 		{
 			Domain:     os.Getenv("LOCAL_DOMAIN"),
-			CfApiToken: os.Getenv("LOCAL_CF_API_TOKEN"),
+			CfApiToken: db.Token(os.Getenv("LOCAL_CF_API_TOKEN")),
 			HostingIP:  os.Getenv("LOCAL_HOSTING"),
 		},
 	}, nil
@@ -38,6 +91,26 @@ type RemoteRepository struct {
 	Repository
 }
 
+// RecordURL returns a link to the record in the Airtable UI
+func RecordURL(base, table, recordID string) string {
+	if base == "" || table == "" || recordID == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s/%s/%s", webURL, base, table, recordID)
+}
+
+func (r *RemoteRepository) DomainURL(recordID string) string {
+	return RecordURL(r.client.cfg.GetBase(), r.client.cfg.GetDomainsTable(), recordID)
+}
+
+func (r *RemoteRepository) HostingURL(recordID string) string {
+	return RecordURL(r.client.cfg.GetBase(), r.client.cfg.GetHostingTable(), recordID)
+}
+
+func (r *RemoteRepository) AccountURL(recordID string) string {
+	return RecordURL(r.client.cfg.GetBase(), r.client.cfg.GetAccountTable(), recordID)
+}
+
 func NewRemoteRepository(cfg AtConfig) *RemoteRepository {
 	client := NewClient(cfg)
 	return &RemoteRepository{
@@ -45,19 +118,20 @@ func NewRemoteRepository(cfg AtConfig) *RemoteRepository {
 	}
 }
 
+// GetAllDomainsForIpChange loads domains through the accounts table and view.
+// The account token takes precedence over the token set on the domain record,
+// which is only used when the account has none. A domain linked from several
+// accounts keeps the first account in view order. Conflicts are logged.
 func (r *RemoteRepository) GetAllDomainsForIpChange() ([]AtDomain, error) {
 	accountsRecords, err := r.client.FetchAllAccountRecords()
 	if err != nil {
 		return nil, err
 	}
 
-	// Collect all domain request IDs and map record ID to API key
+	// Collect all domain request IDs
 	var allDomainReqIDs []string
-	recordIDToAPIToken := make(map[string]string)
 	for _, rec := range accountsRecords {
-		ids := rec.getDomainsReqIDs()
-		allDomainReqIDs = append(allDomainReqIDs, ids...)
-		recordIDToAPIToken[rec.ID] = rec.CfApiToken
+		allDomainReqIDs = append(allDomainReqIDs, rec.DomainsRecordsIDs...)
 	}
 
 	// Request all domains with their hosting information
@@ -69,30 +143,46 @@ func (r *RemoteRepository) GetAllDomainsForIpChange() ([]AtDomain, error) {
 	hostingIDs := []string{}
 
 	for _, domain := range domainsData {
-		hostingIDs = append(hostingIDs, domain.HostingID)
+		hostingIDs = append(hostingIDs, domain.HostingIDs...)
 	}
 
-	hostingRecIdsToIPs, err := r.client.GetHostingByIds(hostingIDs)
+	hostingRecords, err := r.client.GetHostingByIds(hostingIDs)
 	if err != nil {
 		return nil, err
 	}
 
 	atDomains := []AtDomain{}
+	domainAccounts := make(map[string]Record)
 
 	for _, accountRecord := range accountsRecords {
 		for _, domainID := range accountRecord.DomainsRecordsIDs {
-			if domainData, ok := domainsData[domainID]; ok {
-				atDomain := AtDomain{
-					CfApiToken: accountRecord.CfApiToken,
-					Domain:     domainData.Domain,
-				}
+			domainData, ok := domainsData[domainID]
+			if !ok {
+				continue
+			}
 
-				if hostingIP, ok := hostingRecIdsToIPs[domainData.HostingID]; ok {
-					atDomain.HostingIP = hostingIP
+			if first, ok := domainAccounts[domainID]; ok {
+				if first.CfApiToken != accountRecord.CfApiToken {
+					log.Printf("at_repo: warning: domain %s is linked to accounts %s and %s with different tokens, using %s\n",
+						domainData.Domain, first.ID, accountRecord.ID, first.ID)
 				}
-
-				atDomains = append(atDomains, atDomain)
+				continue
+			}
+			domainAccounts[domainID] = accountRecord
+
+			token := accountRecord.CfApiToken
+			switch {
+			case token == "":
+				token = domainData.CfApiToken
+			case domainData.CfApiToken != "" && domainData.CfApiToken != token:
+				log.Printf("at_repo: warning: domain %s token differs between account %s and domain record %s, using the account token\n",
+					domainData.Domain, accountRecord.ID, domainID)
 			}
+
+			hostings := resolveHostings(domainData.HostingIDs, hostingRecords)
+			atDomain := newAtDomain(domainData, token, hostings)
+			atDomain.AccountRecordID = accountRecord.ID
+			atDomains = append(atDomains, atDomain)
 		}
 	}
 
@@ -108,7 +198,9 @@ func (r *RemoteRepository) GetAllDomains() ([]AtDomain, error) {
 	hostingIDsMap := map[string]bool{}
 
 	for _, domain := range domainsData {
-		hostingIDsMap[domain.HostingID] = true
+		for _, id := range domain.HostingIDs {
+			hostingIDsMap[id] = true
+		}
 	}
 
 	hostingIDs := []string{}
@@ -116,26 +208,16 @@ func (r *RemoteRepository) GetAllDomains() ([]AtDomain, error) {
 		hostingIDs = append(hostingIDs, ID)
 	}
 
-	hostingRecIdsToIPs, err := r.client.GetHostingByIds(hostingIDs)
+	hostingRecords, err := r.client.GetHostingByIds(hostingIDs)
 	if err != nil {
 		return nil, err
 	}
 
 	atDomains := []AtDomain{}
 	for _, domain := range domainsData {
-		hostingIP := ""
-
-		if ip, ok := hostingRecIdsToIPs[domain.HostingID]; ok {
-			hostingIP = ip
-		}
+		hostings := resolveHostings(domain.HostingIDs, hostingRecords)
 
-		re := regexp.MustCompile(`[^a-zA-Z0-9.-]`)
-		cleanDomain := re.ReplaceAllString(domain.Domain, "")
-		atDomains = append(atDomains, AtDomain{
-			Domain:     cleanDomain,
-			HostingIP:  hostingIP,
-			CfApiToken: domain.CfApiToken,
-		})
+		atDomains = append(atDomains, newAtDomain(domain, domain.CfApiToken, hostings))
 	}
 
 	return atDomains, nil
@@ -2,7 +2,11 @@ package at
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"go-cf-zone-switch/pkg/db"
@@ -13,6 +17,11 @@ type DbDomainsUpdater struct {
 	Repo     *RemoteRepository
 	Interval time.Duration
 	Notifier Notifier
+	Guard    SyncGuard
+	Mode     string // SyncModeDomains or SyncModeAccounts
+
+	mu       sync.Mutex // one sync at a time, the admin API forces syncs next to the interval
+	lastHeld string     // last held sync alert, to avoid repeating it every interval
 }
 
 func NewDbDomainsSync(db db.Storage, at *RemoteRepository, interval time.Duration, notifier Notifier) *DbDomainsUpdater {
@@ -21,6 +30,8 @@ func NewDbDomainsSync(db db.Storage, at *RemoteRepository, interval time.Duratio
 		Repo:     at,
 		Interval: interval,
 		Notifier: notifier,
+		Guard:    NewSyncGuard(0, 0, 0),
+		Mode:     SyncModeDomains,
 	}
 }
 
@@ -38,7 +49,10 @@ func (d *DbDomainsUpdater) Start(ctx context.Context) {
 			case <-ticker.C:
 				if err := d.Sync(); err != nil {
 					log.Println("updater: Domains update error", err)
-					_ = d.Notifier.Notify("Error updating domains: " + err.Error())
+					if !errors.Is(err, ErrSyncHeld) {
+						_ = d.Notifier.Notify("Error updating domains: " + err.Error())
+					}
+					continue
 				}
 				log.Println("updater: Domains updated")
 
@@ -50,32 +64,201 @@ func (d *DbDomainsUpdater) Start(ctx context.Context) {
 	}()
 }
 
+// Sync mirrors Airtable domains into the storage, unless the guard holds the sync
 func (d *DbDomainsUpdater) Sync() error {
-	log.Println("updater: loading all domains")
-	domains, err := d.Repo.GetAllDomains()
+	return d.sync(false)
+}
+
+// ForceSync applies the held sync even though the guard holds it. Airtable is read again,
+// so when the changes differ from the held ones they are checked by the guard like a new sync.
+func (d *DbDomainsUpdater) ForceSync() error {
+	return d.sync(true)
+}
+
+func (d *DbDomainsUpdater) sync(force bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	check := d.Guard.Check
+	if force {
+		fingerprint, err := d.Db.GetHeldSync()
+		if err != nil {
+			return err
+		}
+		if fingerprint == "" {
+			return ErrNoHeldSync
+		}
+		check = func(diff db.DomainsDiff) error {
+			if diff.Fingerprint() == fingerprint {
+				return nil
+			}
+			if err := d.Guard.Check(diff); err != nil {
+				return fmt.Errorf("%w: %w", ErrHeldSyncChanged, err)
+			}
+			return nil
+		}
+	}
+
+	domains, err := d.fetchDomains()
+	if err != nil {
+		return err
+	}
+
+	dbRows := ToDomainRows(domains)
+	log.Println("updater: domains loaded, syncing")
+
+	diff, err := d.Db.SyncDomains(dbRows, check)
+
+	var held *SyncHeldError
+	if errors.As(err, &held) {
+		log.Println("updater: domains sync held", held)
+		if err := d.Db.SaveHeldSync(held.Diff.Fingerprint()); err != nil {
+			log.Println("updater: failed to record held sync", err)
+		}
+		d.alertHeld(held)
+		return err
+	}
 	if err != nil {
+		log.Println("updater: domains sync error")
 		return err
 	}
+	d.lastHeld = ""
+	if err := d.Db.SaveHeldSync(""); err != nil {
+		log.Println("updater: failed to clear held sync", err)
+	}
+
+	log.Printf("updater: domains synced, %d added, %d removed, %d changed, %d renamed\n",
+		len(diff.Added), len(diff.Removed), len(diff.Changed), len(diff.Renamed))
+
+	if !diff.IsEmpty() && d.Notifier != nil {
+		if err := d.Notifier.Notify(d.formatDiff(diff)); err != nil {
+			log.Println("updater: failed to send sync summary", err)
+		}
+	}
+
+	return nil
+}
+
+func (d *DbDomainsUpdater) fetchDomains() ([]AtDomain, error) {
+	switch d.Mode {
+	case SyncModeDomains, "":
+		log.Println("updater: loading all domains from domains table")
+		return d.Repo.GetAllDomains()
+	case SyncModeAccounts:
+		log.Println("updater: loading all domains from accounts table")
+		return d.Repo.GetAllDomainsForIpChange()
+	default:
+		return nil, fmt.Errorf("updater: unknown sync mode %q", d.Mode)
+	}
+}
 
+// ToDomainRows converts Airtable domains into storage rows, skipping empty domains
+func ToDomainRows(domains []AtDomain) []db.DomainRow {
 	dbRows := []db.DomainRow{}
 	for _, d := range domains {
 		if d.Domain == "" {
 			continue
 		}
+
+		hostings := make([]db.HostingBackend, 0, len(d.Hostings))
+		for _, h := range d.Hostings {
+			hostings = append(hostings, db.HostingBackend{IP: h.IP, Weight: h.Weight, RecordID: h.RecordID})
+		}
+
 		dbRows = append(dbRows, db.DomainRow{
-			Domain:     d.Domain,
-			HostingIP:  d.HostingIP,
-			CfApiToken: d.CfApiToken,
+			Domain:           d.Domain,
+			HostingIP:        d.HostingIP,
+			CfApiToken:       d.CfApiToken,
+			Hostings:         hostings,
+			DomainRecordID:   d.RecordID,
+			AccountRecordID:  d.AccountRecordID,
+			SourceModifiedAt: d.ModifiedAt,
 		})
 	}
-	log.Println("updater: domains loaded, saving")
-	err = d.Db.SaveDomains(dbRows)
+	return dbRows
+}
+
+func (d *DbDomainsUpdater) alertHeld(held *SyncHeldError) {
+	message := fmt.Sprintf("Domains sync held: %s\nRun `ctl sync -force` to apply it\n%s",
+		strings.Join(held.Violations, "; "), d.formatDiff(held.Diff))
+
+	if message == d.lastHeld || d.Notifier == nil {
+		return
+	}
+	d.lastHeld = message
+
+	if err := d.Notifier.Notify(message); err != nil {
+		log.Println("updater: failed to send held sync alert", err)
+	}
+}
+
+// maxDiffLines limits the number of domains listed per section of the summary
+const maxDiffLines = 20
+
+// domainLink returns the Airtable link of a row prefixed with a space, or nothing
+func (d *DbDomainsUpdater) domainLink(row db.DomainRow) string {
+	if d.Repo == nil || row.DomainRecordID == "" {
+		return ""
+	}
+	return " " + d.Repo.DomainURL(row.DomainRecordID)
+}
+
+func (d *DbDomainsUpdater) formatDiff(diff db.DomainsDiff) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "Domains sync: %d added, %d removed, %d changed, %d renamed",
+		len(diff.Added), len(diff.Removed), len(diff.Changed), len(diff.Renamed))
+
+	for i, row := range diff.Added {
+		if i == maxDiffLines {
+			fmt.Fprintf(&sb, "\n+ ... and %d more", len(diff.Added)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "\n+ %s (%s)%s", row.Domain, formatBackends(row.Backends()), d.domainLink(row))
+	}
+
+	for i, row := range diff.Removed {
+		if i == maxDiffLines {
+			fmt.Fprintf(&sb, "\n- ... and %d more", len(diff.Removed)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "\n- %s (%s)%s", row.Domain, formatBackends(row.Backends()), d.domainLink(row))
+	}
+
+	for i, c := range diff.Changed {
+		if i == maxDiffLines {
+			fmt.Fprintf(&sb, "\n~ ... and %d more", len(diff.Changed)-i)
+			break
+		}
+		changes := []string{}
+		if c.HostingChanged() {
+			changes = append(changes, fmt.Sprintf("hosting %s -> %s", formatBackends(c.Old.Backends()), formatBackends(c.New.Backends())))
+		}
+		if c.TokenChanged() {
+			changes = append(changes, "token changed")
+		}
+		fmt.Fprintf(&sb, "\n~ %s: %s%s", c.New.Domain, strings.Join(changes, ", "), d.domainLink(c.New))
+	}
 
-	if err == nil {
-		log.Println("updater: domains saved")
-	} else {
-		log.Println("updater: domains save error")
+	for i, c := range diff.Renamed {
+		if i == maxDiffLines {
+			fmt.Fprintf(&sb, "\n> ... and %d more", len(diff.Renamed)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "\n> %s renamed to %s%s", c.Old.Domain, c.New.Domain, d.domainLink(c.New))
 	}
 
-	return err
+	return sb.String()
+}
+
+func formatBackends(backends []db.HostingBackend) string {
+	parts := make([]string, 0, len(backends))
+	for _, b := range backends {
+		if b.Weight > 0 {
+			parts = append(parts, fmt.Sprintf("%s*%d", b.IP, b.Weight))
+		} else {
+			parts = append(parts, b.IP)
+		}
+	}
+	return strings.Join(parts, ", ")
 }
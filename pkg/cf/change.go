@@ -13,6 +13,7 @@ const (
 	CloudflareAPI = "https://api.cloudflare.com/client/v4"
 )
 
+// Client is shared by every domain of a token, implementations must be safe for concurrent use
 type Client interface {
 	GetZoneID(domain string) (string, error)
 	GetDNSRecords(zoneID, recordType, name string) ([]DNSRecord, error)
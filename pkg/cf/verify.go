@@ -0,0 +1,46 @@
+package cf
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// TokenStatus is the result of verifying an API token
+type TokenStatus struct {
+	ID     string `json:"id"`
+	Status string `json:"status"` // "active", "disabled" or "expired"
+}
+
+// VerifyToken asks Cloudflare whether the token is valid.
+// A rejected token is not an error, it is reported as not valid.
+func VerifyToken(token string) (status TokenStatus, valid bool, err error) {
+	c := &ApiClient{Token: token}
+	req, err := c.newRequest("GET", "/user/tokens/verify", nil)
+	if err != nil {
+		return status, false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return status, false, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return status, false, nil
+	}
+
+	var cfResp CloudflareResponse
+	if err := json.NewDecoder(resp.Body).Decode(&cfResp); err != nil {
+		return status, false, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if !cfResp.Success {
+		return status, false, fmt.Errorf("API returned error: %+v", cfResp.Errors)
+	}
+	if err := json.Unmarshal(cfResp.Result, &status); err != nil {
+		return status, false, fmt.Errorf("failed to unmarshal token status: %w", err)
+	}
+
+	return status, status.Status == "active", nil
+}
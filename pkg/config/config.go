@@ -1,14 +1,39 @@
 package config
 
+import "fmt"
+
 type At struct {
-	Base             string `toml:"base"`
-	DomainsTable     string `toml:"domains_table"`
-	AccountsTable    string `toml:"accounts_table"`
-	AccountsView     string `toml:"accounts_view"`
-	HostingTable     string `toml:"hosting_table"`
+	Base          string `toml:"base"`
+	DomainsTable  string `toml:"domains_table"`
+	AccountsTable string `toml:"accounts_table"`
+	AccountsView  string `toml:"accounts_view"`
+	HostingTable  string `toml:"hosting_table"`
+
+	// DomainsModifiedField is a "Last modified time" field of the domains table,
+	// when empty the modification time of the rows is not known
+	DomainsModifiedField string `toml:"domains_modified_field"`
+
 	DomainsUpdateMin int    `toml:"domains_update_min"`
+	SyncMode         string `toml:"sync_mode"` // "domains" (default) or "accounts"
 
 	Token string `toml:"token"`
+
+	Guard SyncGuard `toml:"Guard"`
+}
+
+func (a At) Validate() error {
+	switch a.SyncMode {
+	case "", "domains", "accounts":
+		return nil
+	}
+	return fmt.Errorf("config: AT.sync_mode must be domains or accounts, got %q", a.SyncMode)
+}
+
+// SyncGuard limits a single domains sync, 0 uses the default and a negative value disables the limit
+type SyncGuard struct {
+	MaxRemovedPercent float64 `toml:"max_removed_percent"`
+	MaxTokenLosses    int     `toml:"max_token_losses"`
+	MaxHostingChanges int     `toml:"max_hosting_changes"`
 }
 
 func (a At) GetBase() string {
@@ -35,18 +60,200 @@ func (a At) GetHostingTable() string {
 	return a.HostingTable
 }
 
+func (a At) GetDomainsModifiedField() string {
+	return a.DomainsModifiedField
+}
+
 type Servers struct {
-	Proxy            []string `toml:"proxy"`
-	CheckIntervalSec int      `toml:"check_interval_sec"`
-	TimeoutSec       int      `toml:"timeout_sec"`
+	Proxy            []Proxy `toml:"proxy"`
+	CheckIntervalSec int     `toml:"check_interval_sec"`
+	TimeoutSec       int     `toml:"timeout_sec"`       // of a check or certificate handshake, default 5
+	CheckConcurrency int     `toml:"check_concurrency"` // servers checked at once, 0 uses the default
 
 	ProxyConfEndpoint          string `toml:"proxy_conf_endpoint"`
 	ProxyConfUpdateINtervalMin int    `toml:"proxy_conf_update_interval_min"`
 	DomainUpdateEndpoint       string `toml:"domain_update_endpoint"`
 	DomainUpdateIntervalMin    int    `toml:"domain_update_interval_min"`
+
+	DnsRefreshIntervalMin int `toml:"dns_refresh_interval_min"` // 0 uses the default, negative disables
+
+	Check Check `toml:"Check"`
+	Certs Certs `toml:"Certs"`
+}
+
+// Check configures proxy health checks, the zero value only opens a TCP connection
+type Check struct {
+	Type           string `toml:"type"`            // "tcp" (default), "http" or "canary", https proxies are checked over TLS
+	Path           string `toml:"path"`            // default "/"
+	Method         string `toml:"method"`          // default GET
+	ExpectedStatus string `toml:"expected_status"` // "200" or a range "200-299", default "200-399"
+	BodyContains   string `toml:"body_contains"`
+	BodyRegex      string `toml:"body_regex"`
+	HostHeader     string `toml:"host_header"`
+
+	TLSSkipVerify bool   `toml:"tls_skip_verify"`
+	TLSServerName string `toml:"tls_server_name"`
+	TLSCAFile     string `toml:"tls_ca_file"` // PEM bundle trusted instead of the system roots
+
+	// type = "canary" requests a domain served by the proxy and matches its hosting IP
+	CanaryDomain     string   `toml:"canary_domain"`      // requested instead of the domains of the proxy
+	CanaryHostingIPs []string `toml:"canary_hosting_ips"` // expected hosting of canary_domain, not matched without
+	CanaryHeader     string   `toml:"canary_header"`      // response header naming the hosting IP, the body is searched without
+}
+
+// Certs checks the TLS certificates the proxies serve for their domains, 0 uses the default
+type Certs struct {
+	Enabled      bool  `toml:"enabled"`
+	Port         int   `toml:"port"`          // TLS port of the proxies, default 443
+	SampleSize   int   `toml:"sample_size"`   // domains checked per proxy and round, default 5
+	IntervalMin  int   `toml:"interval_min"`  // default 60
+	AlertDays    []int `toml:"alert_days"`    // notify when a certificate expires within, default [30, 7, 1]
+	NoFailover   bool  `toml:"no_failover"`   // do not switch to a proxy with an invalid or expiring certificate
+	FailoverDays int   `toml:"failover_days"` // expiring within this many days blocks failover, default 1
+}
+
+// Switcher tunes when domains are switched away from a proxy and where to, 0 uses the default
+type Switcher struct {
+	FailureCount  int `toml:"failure_count"`  // consecutive failed checks before switching away, default 5
+	DownAfterSec  int `toml:"down_after_sec"` // or failing for this long, 0 only counts checks
+	RecoveryCount int `toml:"recovery_count"` // consecutive up checks before a failing proxy is healthy again, default 3
+
+	FlapHalfLifeMin int     `toml:"flap_half_life_min"` // the flap score halves in this time, default 10
+	FlapSuppress    float64 `toml:"flap_suppress"`      // flap score marking a proxy unstable, default 3, negative disables
+	FlapReuse       float64 `toml:"flap_reuse"`         // an unstable proxy is a failover target again below this score, default 1
+
+	ScoreWindowMin int `toml:"score_window_min"` // recent checks scored for latency and errors, default 15
+	LoadPenaltyMs  int `toml:"load_penalty_ms"`  // added to the latency score per served domain, default 10, negative disables
+}
+
+// Admin is the HTTP API for runtime changes, disabled without a listen address
+type Admin struct {
+	Listen string `toml:"listen"` // e.g. "127.0.0.1:8081"
+	Token  string `toml:"token"`  // bearer token required by every request
+}
+
+func (a Admin) Validate() error {
+	if a.Listen != "" && a.Token == "" {
+		return fmt.Errorf("config: Admin.token is required when listen is set")
+	}
+	return nil
+}
+
+// Quorum takes the checks of probe agents into account, a proxy is down only
+// when enough vantage points agree. Disabled without a listen address.
+type Quorum struct {
+	Listen        string            `toml:"listen"`         // address the agents report to, e.g. "0.0.0.0:8082"
+	Agents        map[string]string `toml:"agents"`         // name of each accepted agent and the secret signing its reports
+	Required      int               `toml:"required"`       // vantage points that must see a proxy down, 0 is a majority
+	LocalFallback bool              `toml:"local_fallback"` // the local check decides while fewer than required vantage points report
+	StaleSec      int               `toml:"stale_sec"`      // agent checks signed longer ago are not counted, 0 is 3 check intervals
+}
+
+func (q Quorum) Validate() error {
+	if q.Listen == "" {
+		return nil
+	}
+	if len(q.Agents) == 0 {
+		return fmt.Errorf("config: Quorum.agents is required when listen is set")
+	}
+	for name, secret := range q.Agents {
+		if secret == "" {
+			return fmt.Errorf("config: Quorum.agents.%s has no secret", name)
+		}
+	}
+	if q.Required < 0 || q.Required > len(q.Agents)+1 {
+		return fmt.Errorf("config: Quorum.required must be between 0 and %d", len(q.Agents)+1)
+	}
+	return nil
+}
+
+// Probe is the agent mode of the app, it only checks the proxies and reports to the main instance
+type Probe struct {
+	Name      string `toml:"name"`       // one of Quorum.agents of the main instance
+	ReportURL string `toml:"report_url"` // e.g. "http://main.internal:8082"
+	Secret    string `toml:"secret"`     // secret of the agent in Quorum.agents of the main instance
+}
+
+func (p Probe) Validate() error {
+	if p.Name == "" || p.ReportURL == "" || p.Secret == "" {
+		return fmt.Errorf("config: Probe.name, report_url and secret are required in probe mode")
+	}
+	return nil
+}
+
+// Heartbeat receives the heartbeats of the agents on the proxies. Disabled without a listen address.
+type Heartbeat struct {
+	Listen     string            `toml:"listen"`      // address the proxy agents report to, e.g. "0.0.0.0:8083"
+	Agents     map[string]string `toml:"agents"`      // address of each proxy with an agent and the secret signing its heartbeats
+	TimeoutSec int               `toml:"timeout_sec"` // a proxy that sent heartbeats fails its checks after this long without one, 0 is 90
+}
+
+func (h Heartbeat) Validate() error {
+	if h.Listen != "" && len(h.Agents) == 0 {
+		return fmt.Errorf("config: Heartbeat.agents is required when listen is set")
+	}
+	for address, secret := range h.Agents {
+		if secret == "" {
+			return fmt.Errorf("config: Heartbeat.agents.%s has no secret", address)
+		}
+	}
+	if h.TimeoutSec < 0 {
+		return fmt.Errorf("config: Heartbeat.timeout_sec must not be negative")
+	}
+	return nil
+}
+
+// Agent is the config of cmd/agent running on a proxy
+type Agent struct {
+	Proxy             string `toml:"proxy"`               // address of the proxy in the pool of the main instance
+	ReportURL         string `toml:"report_url"`          // e.g. "http://main.internal:8083"
+	Secret            string `toml:"secret"`              // the secret of the proxy in Heartbeat.agents of the main instance
+	IntervalSec       int    `toml:"interval_sec"`        // 0 is 30
+	Service           string `toml:"service"`             // "nginx" or "haproxy", empty reports no service state
+	StatusURL         string `toml:"status_url"`          // nginx stub_status or haproxy stats in CSV
+	ConfigVersionFile string `toml:"config_version_file"` // written by the proxy when it applies a domain config
+	DiskPath          string `toml:"disk_path"`           // empty is "/"
+}
+
+func (a Agent) Validate() error {
+	if a.Proxy == "" || a.ReportURL == "" || a.Secret == "" {
+		return fmt.Errorf("config: Agent.proxy, report_url and secret are required")
+	}
+	switch a.Service {
+	case "":
+	case "nginx", "haproxy":
+		if a.StatusURL == "" {
+			return fmt.Errorf("config: Agent.status_url is required for service %q", a.Service)
+		}
+	default:
+		return fmt.Errorf("config: Agent.service must be nginx or haproxy, got %q", a.Service)
+	}
+	return nil
+}
+
+type Db struct {
+	Path           string `toml:"path"`
+	OpenTimeoutSec int    `toml:"open_timeout_sec"`
+	NoSync         bool   `toml:"no_sync"`
+	NoGrowSync     bool   `toml:"no_grow_sync"`
+
+	TokenKeysFile string `toml:"token_keys_file"`
+	TokenKeysEnv  string `toml:"token_keys_env"`
+
+	// Health check history, 0 uses the default and a negative value disables
+	ChecksRetentionDays         int `toml:"checks_retention_days"`
+	ChecksDownsampleAfterHours  int `toml:"checks_downsample_after_hours"`
+	ChecksDownsampleIntervalMin int `toml:"checks_downsample_interval_min"`
 }
 
 type Config struct {
-	At      At      `toml:"AT"`
-	Servers Servers `toml:"Servers"`
+	At        At        `toml:"AT"`
+	Servers   Servers   `toml:"Servers"`
+	Switcher  Switcher  `toml:"Switcher"`
+	Db        Db        `toml:"Db"`
+	Admin     Admin     `toml:"Admin"`
+	Quorum    Quorum    `toml:"Quorum"`
+	Probe     Probe     `toml:"Probe"`
+	Heartbeat Heartbeat `toml:"Heartbeat"`
+	Agent     Agent     `toml:"Agent"`
 }
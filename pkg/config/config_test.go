@@ -0,0 +1,121 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadString(t *testing.T, content string) (*Config, error) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestLoad_LegacyProxyStrings(t *testing.T) {
+	cfg, err := loadString(t, `
+[Servers]
+proxy = ["http://10.0.0.1:5214", "https://10.0.0.2:443"]
+`)
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+
+	proxies := cfg.Servers.Proxy
+	if len(proxies) != 2 {
+		t.Fatalf("Expected 2 proxies, got %+v", proxies)
+	}
+	if p := proxies[0]; p.Address != "10.0.0.1" || p.Port != 5214 || p.Scheme != "http" || p.Name != "10.0.0.1:5214" || p.IP() != "10.0.0.1" || p.Weight != 1 {
+		t.Errorf("Unexpected legacy proxy %+v", p)
+	}
+	if p := proxies[1]; p.Scheme != "https" || p.Port != 443 {
+		t.Errorf("Unexpected legacy proxy %+v", p)
+	}
+}
+
+func TestLoad_ProxyTables(t *testing.T) {
+	cfg, err := loadString(t, `
+[[Servers.Proxy]]
+name = "fra-1"
+address = "fra-1.internal"
+port = 5214
+public_ips = ["203.0.113.10", "203.0.113.11"]
+check_type = "HTTP"
+priority = 1
+weight = 3
+region = "eu"
+tags = ["primary"]
+max_domains = 500
+
+[[Servers.Proxy]]
+address = "10.0.0.2"
+port = 5214
+no_failover = true
+`)
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+
+	p := cfg.Servers.ProxyByAddress("fra-1.internal")
+	if p.Name != "fra-1" || p.IP() != "203.0.113.10" || !p.HasIP("203.0.113.11") || p.HasIP("fra-1.internal") ||
+		p.CheckType != "http" || p.Weight != 3 || p.MaxDomains != 500 || p.Region != "eu" || len(p.Tags) != 1 {
+		t.Errorf("Unexpected proxy %+v", p)
+	}
+	if p := cfg.Servers.ProxyByAddress("10.0.0.2"); !p.NoFailover || p.HostPort() != "10.0.0.2:5214" {
+		t.Errorf("Unexpected proxy %+v", p)
+	}
+	if p := cfg.Servers.ProxyByAddress("10.9.9.9"); p.IP() != "10.9.9.9" || p.NoFailover {
+		t.Errorf("Expected an unknown address to stand for itself, got %+v", p)
+	}
+}
+
+func TestLoad_InvalidProxies(t *testing.T) {
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{`proxy = ["http://10.0.0.1"]`, "port is missing"},
+		{"[[Servers.Proxy]]\nport = 80", "address is required"},
+		{"[[Servers.Proxy]]\naddress = \"10.0.0.1\"\nport = 70000", "port 70000 is out of range"},
+		{"[[Servers.Proxy]]\naddress = \"10.0.0.1\"\nport = 80\nscheme = \"ftp\"", `scheme "ftp"`},
+		{"[[Servers.Proxy]]\naddress = \"10.0.0.1\"\nport = 80\ncheck_type = \"icmp\"", `check_type "icmp"`},
+		{"[[Servers.Proxy]]\naddress = \"proxy.internal\"\nport = 80", "public_ips is required"},
+		{"[[Servers.Proxy]]\naddress = \"10.0.0.1\"\nport = 80\npublic_ips = [\"example.com\"]", `public IP "example.com"`},
+		{"[[Servers.Proxy]]\naddress = \"10.0.0.1\"\nport = 80\nmax_domains = -1", "max_domains -1"},
+		{"[[Servers.Proxy]]\naddress = \"10.0.0.1\"\nport = 80\n[[Servers.Proxy]]\naddress = \"10.0.0.1\"\nport = 81", `duplicate address "10.0.0.1"`},
+		{"[[Servers.Proxy]]\nname = \"a\"\naddress = \"10.0.0.1\"\nport = 80\n[[Servers.Proxy]]\nname = \"a\"\naddress = \"10.0.0.2\"\nport = 80", `duplicate name "a"`},
+	}
+
+	for _, tt := range tests {
+		config := tt.config
+		if !strings.HasPrefix(config, "[[") {
+			config = "[Servers]\n" + config
+		}
+		_, err := loadString(t, config)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Expected error containing %q for\n%s\ngot %v", tt.err, config, err)
+		}
+	}
+}
+
+func TestLoad_InvalidSyncMode(t *testing.T) {
+	if _, err := loadString(t, "[AT]\nsync_mode = \"accounts\""); err != nil {
+		t.Errorf("Expected accounts mode to load, got %v", err)
+	}
+	if _, err := loadString(t, "[AT]\nsync_mode = \"account\""); err == nil || !strings.Contains(err.Error(), `sync_mode must be domains or accounts, got "account"`) {
+		t.Errorf("Expected an unknown sync mode to fail the load, got %v", err)
+	}
+}
+
+func TestLoad_CheckTimeout(t *testing.T) {
+	cfg, err := loadString(t, "[Servers]\ncheck_interval_sec = 30")
+	if err != nil || cfg.Servers.TimeoutSec != DefaultTimeoutSec {
+		t.Errorf("Expected the default timeout without timeout_sec, got %+v, %v", cfg, err)
+	}
+	if _, err := loadString(t, "[Servers]\ntimeout_sec = -1"); err == nil || !strings.Contains(err.Error(), "timeout_sec must not be negative") {
+		t.Errorf("Expected a negative timeout to fail the load, got %v", err)
+	}
+}
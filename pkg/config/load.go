@@ -26,5 +26,21 @@ func Load(filePath string) (*Config, error) {
 		return nil, err
 	}
 
+	if err := config.At.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Servers.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Admin.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Quorum.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Heartbeat.Validate(); err != nil {
+		return nil, err
+	}
+
 	return &config, nil
 }
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Proxy is one proxy server of [[Servers.Proxy]]. The legacy "http://ip:port"
+// string form is still accepted and read as a proxy with only scheme, address and port.
+type Proxy struct {
+	Name      string   `toml:"name" json:"name"`             // default address:port
+	PublicIPs []string `toml:"public_ips" json:"public_ips"` // A record targets, default the address
+	Address   string   `toml:"address" json:"address"`       // checked and sent domain updates
+	Port      int      `toml:"port" json:"port"`
+	Scheme    string   `toml:"scheme" json:"scheme"`         // "http" (default) or "https"
+	CheckType string   `toml:"check_type" json:"check_type"` // overrides [Servers.Check] type
+
+	Weight     int      `toml:"weight" json:"weight"`     // default 1, divides the latency score of the switcher
+	Priority   int      `toml:"priority" json:"priority"` // lower is preferred as failover target
+	Region     string   `toml:"region" json:"region,omitempty"`
+	Tags       []string `toml:"tags" json:"tags,omitempty"`
+	MaxDomains int      `toml:"max_domains" json:"max_domains,omitempty"` // 0 is unlimited
+	NoFailover bool     `toml:"no_failover" json:"no_failover,omitempty"` // never switch domains to this proxy
+}
+
+// UnmarshalText reads the legacy "http://ip:port" form
+func (p *Proxy) UnmarshalText(text []byte) error {
+	raw := string(text)
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("proxy %q: %w", text, err)
+	}
+	if u.Port() == "" {
+		return fmt.Errorf("proxy %q: port is missing", text)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return fmt.Errorf("proxy %q: %w", text, err)
+	}
+
+	*p = Proxy{Scheme: u.Scheme, Address: u.Hostname(), Port: port}
+	return nil
+}
+
+// UnmarshalJSON decodes the object form, encoding/json would use UnmarshalText otherwise
+func (p *Proxy) UnmarshalJSON(b []byte) error {
+	type plain Proxy
+	return json.Unmarshal(b, (*plain)(p))
+}
+
+// Equal compares all fields, nil and empty lists are equal
+func (p Proxy) Equal(o Proxy) bool {
+	a, b := p, o
+	a.PublicIPs, b.PublicIPs = nil, nil
+	a.Tags, b.Tags = nil, nil
+	return reflect.DeepEqual(a, b) && slices.Equal(p.PublicIPs, o.PublicIPs) && slices.Equal(p.Tags, o.Tags)
+}
+
+// HostPort is the address the proxy is checked and updated on
+func (p Proxy) HostPort() string {
+	return net.JoinHostPort(p.Address, strconv.Itoa(p.Port))
+}
+
+// IP is the address domains are pointed to when switched to the proxy
+func (p Proxy) IP() string {
+	if len(p.PublicIPs) == 0 {
+		return p.Address
+	}
+	return p.PublicIPs[0]
+}
+
+// HasIP reports whether a domain resolving to ip is served by the proxy
+func (p Proxy) HasIP(ip string) bool {
+	if len(p.PublicIPs) == 0 {
+		return ip == p.Address
+	}
+	return slices.Contains(p.PublicIPs, ip)
+}
+
+// Validate fills in defaults and checks the proxy
+func (p *Proxy) Validate() error {
+	fail := func(format string, args ...any) error {
+		name := p.Name
+		if name == "" {
+			name = p.Address
+		}
+		return fmt.Errorf("proxy %s: %s", name, fmt.Sprintf(format, args...))
+	}
+
+	if p.Address == "" {
+		return fail("address is required")
+	}
+	if p.Port < 1 || p.Port > 65535 {
+		return fail("port %d is out of range", p.Port)
+	}
+
+	p.Scheme = strings.ToLower(p.Scheme)
+	switch p.Scheme {
+	case "":
+		p.Scheme = "http"
+	case "http", "https":
+	default:
+		return fail("scheme %q is not http or https", p.Scheme)
+	}
+
+	p.CheckType = strings.ToLower(p.CheckType)
+	switch p.CheckType {
+	case "", "tcp", "http", "canary":
+	default:
+		return fail("check_type %q is not tcp, http or canary", p.CheckType)
+	}
+
+	if len(p.PublicIPs) == 0 && net.ParseIP(p.Address) == nil {
+		return fail("public_ips is required when address %q is not an IP", p.Address)
+	}
+	for _, ip := range p.PublicIPs {
+		if net.ParseIP(ip) == nil {
+			return fail("public IP %q is not an IP", ip)
+		}
+	}
+
+	if p.Weight < 0 {
+		return fail("weight %d is negative", p.Weight)
+	}
+	if p.Weight == 0 {
+		p.Weight = 1
+	}
+	if p.MaxDomains < 0 {
+		return fail("max_domains %d is negative", p.MaxDomains)
+	}
+
+	if p.Name == "" {
+		p.Name = p.HostPort()
+	}
+	return nil
+}
+
+// DefaultTimeoutSec bounds a health check or certificate handshake when Servers.timeout_sec is not set
+const DefaultTimeoutSec = 5
+
+// Validate checks the proxies and fills in their defaults and the check timeout
+func (s *Servers) Validate() error {
+	switch {
+	case s.TimeoutSec < 0:
+		return fmt.Errorf("config: Servers.timeout_sec must not be negative")
+	case s.TimeoutSec == 0:
+		s.TimeoutSec = DefaultTimeoutSec
+	}
+
+	names := map[string]bool{}
+	addresses := map[string]bool{}
+	for i := range s.Proxy {
+		p := &s.Proxy[i]
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("config: Servers.Proxy %d: %w", i+1, err)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("config: Servers.Proxy %d: duplicate name %q", i+1, p.Name)
+		}
+		// servers are stored by address, two proxies on one address would share their health
+		if addresses[p.Address] {
+			return fmt.Errorf("config: Servers.Proxy %d (%s): duplicate address %q", i+1, p.Name, p.Address)
+		}
+		names[p.Name] = true
+		addresses[p.Address] = true
+	}
+	return nil
+}
+
+// ProxyByAddress returns the configured proxy checked on the address.
+// Unknown addresses, like servers stored before the proxy was removed from
+// the config, get a proxy whose public IP is the address.
+func (s Servers) ProxyByAddress(address string) Proxy {
+	if p, ok := s.LookupProxy(address); ok {
+		return p
+	}
+	return Proxy{Name: address, Address: address, Scheme: "http", Weight: 1}
+}
+
+// LookupProxy returns the configured proxy checked on the address, ok is false for unknown addresses
+func (s Servers) LookupProxy(address string) (Proxy, bool) {
+	for _, p := range s.Proxy {
+		if p.Address == address {
+			return p, true
+		}
+	}
+	return Proxy{}, false
+}
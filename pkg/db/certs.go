@@ -0,0 +1,104 @@
+package db
+
+import (
+	"encoding/json"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// certChecksBucket holds the last certificate check per proxy host and domain
+var certChecksBucket = []byte("cert_checks")
+
+// CertCheck is the last TLS handshake with a proxy for a domain.
+// A failed handshake has an Error and no certificate.
+type CertCheck struct {
+	Host        string    `json:"host"`
+	Domain      string    `json:"domain"`
+	CheckedAt   time.Time `json:"checked_at"`
+	NotAfter    time.Time `json:"not_after,omitempty"`
+	Issuer      string    `json:"issuer,omitempty"`
+	Valid       bool      `json:"valid"` // the chain verifies for the domain
+	Error       string    `json:"error,omitempty"`
+	AlertedDays int       `json:"alerted_days,omitempty"` // lowest expiry threshold alerted for NotAfter
+}
+
+// HasCert reports whether the handshake got a certificate
+func (c CertCheck) HasCert() bool {
+	return !c.NotAfter.IsZero()
+}
+
+// DaysLeft returns the whole days until the certificate expires, negative once expired
+func (c CertCheck) DaysLeft(now time.Time) int {
+	return int(math.Floor(c.NotAfter.Sub(now).Hours() / 24))
+}
+
+func certKey(host, domain string) []byte {
+	return []byte(host + "\x00" + domain)
+}
+
+func (s *DbStorage) SaveCertChecks(checks []CertCheck) error {
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket(certChecksBucket)
+		for _, c := range checks {
+			val, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(certKey(c.Host, c.Domain), val); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetCertChecks returns the last checks sorted by host and domain
+func (s *DbStorage) GetCertChecks() ([]CertCheck, error) {
+	checks := []CertCheck{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(certChecksBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var c CertCheck
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			checks = append(checks, c)
+			return nil
+		})
+	})
+	return checks, err
+}
+
+// PruneCertChecks removes checks of the host for domains no longer on it
+func (s *DbStorage) PruneCertChecks(host string, domains []string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return deleteCertChecks(tx, host, domains)
+	})
+}
+
+// deleteCertChecks removes checks of the host except for the kept domains
+func deleteCertChecks(tx *bolt.Tx, host string, keep []string) error {
+	prefix := []byte(host + "\x00")
+	stale := [][]byte{}
+
+	c := tx.Bucket(certChecksBucket).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, _ = c.Next() {
+		if !slices.Contains(keep, strings.TrimPrefix(string(k), string(prefix))) {
+			stale = append(stale, append([]byte{}, k...))
+		}
+	}
+
+	for _, k := range stale {
+		if err := tx.Bucket(certChecksBucket).Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	defaultChecksRetention          = 90 * 24 * time.Hour
+	defaultChecksDownsampleAfter    = 7 * 24 * time.Hour
+	defaultChecksDownsampleInterval = time.Hour
+)
+
+// serverChecksBucket holds a nested bucket per host with checks keyed by time
+var serverChecksBucket = []byte("server_checks")
+
+// ServerCheck is a health check result. Downsampled rows merge several checks,
+// Count is the number of merged checks and UpCount how many of them were up.
+type ServerCheck struct {
+	Host    string        `json:"host"`
+	At      time.Time     `json:"at"`
+	IsUp    bool          `json:"is_up"`
+	Latency time.Duration `json:"latency"`
+	Connect time.Duration `json:"connect,omitempty"`
+	TTFB    time.Duration `json:"ttfb,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Count   int           `json:"count"`
+	UpCount int           `json:"up_count"`
+}
+
+// RetentionPolicy controls how long checks are kept and when they are downsampled
+type RetentionPolicy struct {
+	Retention          time.Duration // checks older than this are deleted, 0 keeps them forever
+	DownsampleAfter    time.Duration // checks older than this are merged, 0 disables downsampling
+	DownsampleInterval time.Duration // merged rows cover this interval
+}
+
+// DefaultRetention is the checks retention policy of unset config values
+func DefaultRetention() RetentionPolicy {
+	return RetentionPolicy{
+		Retention:          defaultChecksRetention,
+		DownsampleAfter:    defaultChecksDownsampleAfter,
+		DownsampleInterval: defaultChecksDownsampleInterval,
+	}
+}
+
+// normalize fills Count and UpCount of a single raw check
+func (c ServerCheck) normalize() ServerCheck {
+	if c.Count == 0 {
+		c.Count = 1
+		if c.IsUp {
+			c.UpCount = 1
+		}
+	}
+	return c
+}
+
+// AppendServerChecks adds check results to the time series of each host
+func (s *DbStorage) AppendServerChecks(checks []ServerCheck) error {
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		root := tx.Bucket(serverChecksBucket)
+		for _, c := range checks {
+			b, err := root.CreateBucketIfNotExists([]byte(c.Host))
+			if err != nil {
+				return err
+			}
+			val, err := json.Marshal(c.normalize())
+			if err != nil {
+				return err
+			}
+			if err := b.Put(timeKey(c.At), val); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetServerChecks returns checks of the host within [from, to], oldest first
+func (s *DbStorage) GetServerChecks(host string, from, to time.Time) ([]ServerCheck, error) {
+	checks := []ServerCheck{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(serverChecksBucket)
+		if root == nil {
+			return nil
+		}
+		b := root.Bucket([]byte(host))
+		if b == nil {
+			return nil
+		}
+
+		end := timeKey(to)
+		c := b.Cursor()
+		for k, v := c.Seek(timeKey(from)); k != nil && bytes.Compare(k, end) <= 0; k, v = c.Next() {
+			var check ServerCheck
+			if err := json.Unmarshal(v, &check); err != nil {
+				return err
+			}
+			checks = append(checks, check)
+		}
+		return nil
+	})
+	return checks, err
+}
+
+// GetCheckedHosts returns hosts with a checks time series
+func (s *DbStorage) GetCheckedHosts() ([]string, error) {
+	hosts := []string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(serverChecksBucket)
+		if root == nil {
+			return nil
+		}
+		return root.ForEach(func(k, v []byte) error {
+			if v == nil {
+				hosts = append(hosts, string(k))
+			}
+			return nil
+		})
+	})
+	return hosts, err
+}
+
+// CompactServerChecks applies the retention policy and returns the number of removed rows
+func (s *DbStorage) CompactServerChecks(policy RetentionPolicy, now time.Time) (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(serverChecksBucket)
+
+		hosts := [][]byte{}
+		_ = root.ForEach(func(k, v []byte) error {
+			if v == nil {
+				hosts = append(hosts, append([]byte{}, k...))
+			}
+			return nil
+		})
+
+		for _, host := range hosts {
+			b := root.Bucket(host)
+
+			checks := []ServerCheck{}
+			err := b.ForEach(func(k, v []byte) error {
+				var check ServerCheck
+				if err := json.Unmarshal(v, &check); err != nil {
+					return err
+				}
+				checks = append(checks, check)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			kept := compactChecks(checks, policy, now)
+			if len(kept) == len(checks) {
+				continue
+			}
+			removed += len(checks) - len(kept)
+
+			if err := root.DeleteBucket(host); err != nil {
+				return err
+			}
+			b, err = root.CreateBucket(host)
+			if err != nil {
+				return err
+			}
+			for _, c := range kept {
+				val, err := json.Marshal(c)
+				if err != nil {
+					return err
+				}
+				if err := b.Put(timeKey(c.At), val); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// compactChecks drops expired checks and merges old ones into interval rows
+func compactChecks(checks []ServerCheck, policy RetentionPolicy, now time.Time) []ServerCheck {
+	kept := []ServerCheck{}
+	merged := map[time.Time]ServerCheck{}
+	latencySum := map[time.Time]time.Duration{}
+	connectSum := map[time.Time]time.Duration{}
+	ttfbSum := map[time.Time]time.Duration{}
+
+	for _, c := range checks {
+		c = c.normalize()
+
+		if policy.Retention > 0 && c.At.Before(now.Add(-policy.Retention)) {
+			continue
+		}
+
+		if policy.DownsampleAfter <= 0 || policy.DownsampleInterval <= 0 || !c.At.Before(now.Add(-policy.DownsampleAfter)) {
+			kept = append(kept, c)
+			continue
+		}
+
+		slot := c.At.Truncate(policy.DownsampleInterval)
+		m, ok := merged[slot]
+		if !ok {
+			m = ServerCheck{Host: c.Host, At: slot}
+		}
+		m.Count += c.Count
+		m.UpCount += c.UpCount
+		latencySum[slot] += c.Latency * time.Duration(c.Count)
+		connectSum[slot] += c.Connect * time.Duration(c.Count)
+		ttfbSum[slot] += c.TTFB * time.Duration(c.Count)
+		if c.Error != "" {
+			m.Error = c.Error
+		}
+		merged[slot] = m
+	}
+
+	for slot, m := range merged {
+		m.Latency = latencySum[slot] / time.Duration(m.Count)
+		m.Connect = connectSum[slot] / time.Duration(m.Count)
+		m.TTFB = ttfbSum[slot] / time.Duration(m.Count)
+		m.IsUp = m.UpCount*2 >= m.Count
+		kept = append(kept, m)
+	}
+
+	sort.Slice(kept, func(i, j int) bool { return kept[i].At.Before(kept[j].At) })
+	return kept
+}
+
+// Outage is a period a server was down, End is zero while it is ongoing
+type Outage struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+func (o Outage) Duration(now time.Time) time.Duration {
+	if o.End.IsZero() {
+		return now.Sub(o.Start)
+	}
+	return o.End.Sub(o.Start)
+}
+
+// UptimeStats summarizes checks of a server over a window
+type UptimeStats struct {
+	Host          string        `json:"host"`
+	Checks        int           `json:"checks"`
+	UptimePercent float64       `json:"uptime_percent"`
+	MTTR          time.Duration `json:"mttr"` // mean duration of outages that ended
+	Outages       []Outage      `json:"outages"`
+}
+
+// ComputeUptime derives uptime, outages and MTTR from checks sorted by time
+func ComputeUptime(host string, checks []ServerCheck) UptimeStats {
+	stats := UptimeStats{Host: host, Outages: []Outage{}}
+
+	total, up := 0, 0
+	var current *Outage
+	for _, c := range checks {
+		c = c.normalize()
+		total += c.Count
+		up += c.UpCount
+
+		if !c.IsUp && current == nil {
+			current = &Outage{Start: c.At, Reason: c.Error}
+		}
+		if c.IsUp && current != nil {
+			current.End = c.At
+			stats.Outages = append(stats.Outages, *current)
+			current = nil
+		}
+	}
+	if current != nil {
+		stats.Outages = append(stats.Outages, *current)
+	}
+
+	stats.Checks = total
+	if total > 0 {
+		stats.UptimePercent = float64(up) * 100 / float64(total)
+	}
+
+	var repaired time.Duration
+	closed := 0
+	for _, o := range stats.Outages {
+		if !o.End.IsZero() {
+			repaired += o.End.Sub(o.Start)
+			closed++
+		}
+	}
+	if closed > 0 {
+		stats.MTTR = repaired / time.Duration(closed)
+	}
+
+	return stats
+}
+
+// GetServerUptime computes uptime statistics of the host within [from, to]
+func (s *DbStorage) GetServerUptime(host string, from, to time.Time) (UptimeStats, error) {
+	checks, err := s.GetServerChecks(host, from, to)
+	if err != nil {
+		return UptimeStats{}, err
+	}
+	return ComputeUptime(host, checks), nil
+}
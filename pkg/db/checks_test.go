@@ -0,0 +1,102 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeUptime(t *testing.T) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	checks := []ServerCheck{
+		{At: base, IsUp: true},
+		{At: base.Add(time.Minute), IsUp: false, Error: "timeout"},
+		{At: base.Add(2 * time.Minute), IsUp: false},
+		{At: base.Add(3 * time.Minute), IsUp: true},
+		{At: base.Add(4 * time.Minute), IsUp: false, Error: "refused"},
+		{At: base.Add(5 * time.Minute), IsUp: false},
+		{At: base.Add(6 * time.Minute), IsUp: false},
+		{At: base.Add(7 * time.Minute), IsUp: true},
+		{At: base.Add(8 * time.Minute), IsUp: false, Error: "timeout"},
+		{At: base.Add(9 * time.Minute), IsUp: true, Count: 3, UpCount: 3},
+	}
+
+	stats := ComputeUptime("proxy", checks)
+	if stats.Checks != 12 {
+		t.Errorf("Expected 12 checks counting merged rows, got %d", stats.Checks)
+	}
+	if stats.UptimePercent != 50 {
+		t.Errorf("Expected 50%% uptime, got %v", stats.UptimePercent)
+	}
+	if len(stats.Outages) != 3 {
+		t.Fatalf("Expected 3 outages, got %+v", stats.Outages)
+	}
+	if stats.Outages[1].Reason != "refused" || stats.Outages[1].Duration(base) != 3*time.Minute {
+		t.Errorf("Unexpected second outage %+v", stats.Outages[1])
+	}
+	if stats.MTTR != 2*time.Minute {
+		t.Errorf("Expected MTTR of 2m, got %v", stats.MTTR)
+	}
+
+	ongoing := ComputeUptime("proxy", checks[:3])
+	if len(ongoing.Outages) != 1 || !ongoing.Outages[0].End.IsZero() || ongoing.MTTR != 0 {
+		t.Errorf("Expected one ongoing outage without MTTR, got %+v", ongoing)
+	}
+}
+
+func TestDbStorage_ServerChecks(t *testing.T) {
+	storage, _ := newTestStorage(t)
+
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	checks := []ServerCheck{
+		{Host: "a", At: now.Add(-40 * 24 * time.Hour), IsUp: true},
+		{Host: "a", At: now.Add(-2*24*time.Hour + time.Minute), IsUp: true, Latency: 10 * time.Millisecond},
+		{Host: "a", At: now.Add(-2*24*time.Hour + 2*time.Minute), IsUp: false, Error: "timeout"},
+		{Host: "a", At: now.Add(-2*24*time.Hour + 3*time.Minute), IsUp: true, Latency: 20 * time.Millisecond},
+		{Host: "a", At: now.Add(-time.Minute), IsUp: true},
+		{Host: "b", At: now.Add(-time.Minute), IsUp: false},
+	}
+	if err := storage.AppendServerChecks(checks); err != nil {
+		t.Fatalf("Failed to append checks: %v", err)
+	}
+
+	hosts, err := storage.GetCheckedHosts()
+	if err != nil || len(hosts) != 2 {
+		t.Fatalf("Expected 2 hosts, got %v, %v", hosts, err)
+	}
+
+	recent, _ := storage.GetServerChecks("a", now.Add(-time.Hour), now)
+	if len(recent) != 1 || recent[0].Count != 1 || recent[0].UpCount != 1 {
+		t.Errorf("Expected one normalized recent check, got %+v", recent)
+	}
+
+	removed, err := storage.CompactServerChecks(RetentionPolicy{
+		Retention:          30 * 24 * time.Hour,
+		DownsampleAfter:    24 * time.Hour,
+		DownsampleInterval: time.Hour,
+	}, now)
+	if err != nil {
+		t.Fatalf("Failed to compact checks: %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("Expected 3 rows removed, got %d", removed)
+	}
+
+	all, _ := storage.GetServerChecks("a", now.Add(-60*24*time.Hour), now)
+	if len(all) != 2 {
+		t.Fatalf("Expected merged row and recent check, got %+v", all)
+	}
+	merged := all[0]
+	if merged.Count != 3 || merged.UpCount != 2 || !merged.IsUp || merged.Error != "timeout" || merged.Latency != 10*time.Millisecond {
+		t.Errorf("Unexpected merged row %+v", merged)
+	}
+
+	// compacting again keeps the merged row as it is
+	if removed, _ := storage.CompactServerChecks(RetentionPolicy{DownsampleAfter: 24 * time.Hour, DownsampleInterval: time.Hour}, now); removed != 0 {
+		t.Errorf("Expected nothing to compact, removed %d", removed)
+	}
+
+	stats, err := storage.GetServerUptime("b", now.Add(-time.Hour), now)
+	if err != nil || stats.UptimePercent != 0 || len(stats.Outages) != 1 {
+		t.Errorf("Unexpected uptime of b %+v, %v", stats, err)
+	}
+}
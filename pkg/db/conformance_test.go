@@ -0,0 +1,480 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// storageFactories are the Storage implementations checked by the conformance suite
+var storageFactories = map[string]func(t *testing.T) Storage{
+	"bolt": func(t *testing.T) Storage {
+		storage, _ := newTestStorage(t)
+		return storage
+	},
+	"memory": func(t *testing.T) Storage {
+		return NewMemoryStorage()
+	},
+}
+
+func TestStorageConformance(t *testing.T) {
+	suite := map[string]func(t *testing.T, s Storage){
+		"ProxyServers":  testConformanceProxyServers,
+		"Domains":       testConformanceDomains,
+		"SyncDomains":   testConformanceSyncDomains,
+		"SwitchHistory": testConformanceSwitchHistory,
+		"ServerChecks":  testConformanceServerChecks,
+		"DnsStates":     testConformanceDnsStates,
+		"Indexes":       testConformanceIndexes,
+		"Tokens":        testConformanceTokens,
+		"ProxyPool":     testConformanceProxyPool,
+		"CertChecks":    testConformanceCertChecks,
+		"Heartbeats":    testConformanceHeartbeats,
+		"HeldSync":      testConformanceHeldSync,
+	}
+
+	for name, factory := range storageFactories {
+		for test, run := range suite {
+			t.Run(name+"/"+test, func(t *testing.T) {
+				run(t, factory(t))
+			})
+		}
+	}
+}
+
+func testConformanceProxyServers(t *testing.T, s Storage) {
+	now := time.Now()
+	err := s.SaveProxyServers([]ProxyServerRow{
+		{Host: "b", IsUp: false, LastCheck: now},
+		{Host: "a", IsUp: true, LastCheck: now},
+	})
+	if err != nil {
+		t.Fatalf("Failed to save servers: %v", err)
+	}
+	_ = s.SaveProxyServers([]ProxyServerRow{{Host: "b", IsUp: true, CheckPort: "80"}})
+
+	all, err := s.GetProxyServers(false)
+	if err != nil || len(all) != 2 || all[0].Host != "a" || all[1].CheckPort != "80" {
+		t.Errorf("Expected servers sorted by host with the last save, got %+v, %v", all, err)
+	}
+
+	_ = s.SaveProxyServers([]ProxyServerRow{{Host: "a", IsUp: false}})
+	healthy, _ := s.GetProxyServers(true)
+	if len(healthy) != 1 || healthy[0].Host != "b" {
+		t.Errorf("Expected only b healthy, got %+v", healthy)
+	}
+}
+
+func testConformanceDomains(t *testing.T, s Storage) {
+	rows := []DomainRow{
+		{Domain: "b.com", HostingIP: "10.0.0.1", Hostings: []HostingBackend{{IP: "10.0.0.1"}, {IP: "10.0.0.2", Weight: 2}}},
+		{Domain: "a.com", HostingIP: "10.0.0.3", CfApiToken: "token"},
+	}
+	if err := s.SaveDomains(rows); err != nil {
+		t.Fatalf("Failed to save domains: %v", err)
+	}
+	rows[0].Hostings[1].IP = "changed"
+
+	all, err := s.GetAllDomains()
+	if err != nil || len(all) != 2 || all[0].Domain != "a.com" {
+		t.Fatalf("Expected domains sorted by name, got %+v, %v", all, err)
+	}
+	if all[1].Hostings[1].IP != "10.0.0.2" {
+		t.Errorf("Expected stored backends not to change with the caller slice, got %+v", all[1].Hostings)
+	}
+
+	withTokens, _ := s.GetDomainWithCfTokens()
+	if len(withTokens) != 1 || withTokens[0].CfApiToken.Reveal() != "token" {
+		t.Errorf("Expected only a.com with its token, got %+v", withTokens)
+	}
+
+	withoutTokens, err := s.GetDomainsWithoutTokens()
+	if err != nil || len(withoutTokens) != 2 || withoutTokens[0].Domain != "a.com" || withoutTokens[0].CfApiToken != "" ||
+		len(withoutTokens[1].Hostings) != 2 {
+		t.Errorf("Expected both domains with their backends and no token, got %+v, %v", withoutTokens, err)
+	}
+}
+
+func testConformanceSyncDomains(t *testing.T, s Storage) {
+	_ = s.SaveDomains([]DomainRow{
+		{Domain: "keep.com", HostingIP: "1"},
+		{Domain: "gone.com", HostingIP: "1"},
+		{Domain: "old.com", HostingIP: "1", DomainRecordID: "rec1"},
+	})
+
+	held := errors.New("held")
+	incoming := []DomainRow{
+		{Domain: "keep.com", HostingIP: "2"},
+		{Domain: "new.com", HostingIP: "1", DomainRecordID: "rec1"},
+	}
+
+	diff, err := s.SyncDomains(incoming, func(DomainsDiff) error { return held })
+	if !errors.Is(err, held) || len(diff.Removed) != 1 {
+		t.Fatalf("Expected held sync with its diff, got %+v, %v", diff, err)
+	}
+	if all, _ := s.GetAllDomains(); len(all) != 3 {
+		t.Errorf("Expected nothing written by a held sync, got %+v", all)
+	}
+
+	diff, err = s.SyncDomains(incoming, nil)
+	if err != nil {
+		t.Fatalf("Failed to sync domains: %v", err)
+	}
+	if len(diff.Changed) != 1 || len(diff.Removed) != 1 || len(diff.Renamed) != 1 || diff.Previous != 3 {
+		t.Errorf("Unexpected diff %+v", diff)
+	}
+
+	all, _ := s.GetAllDomains()
+	if len(all) != 2 || all[0].Domain != "keep.com" || all[0].HostingIP != "2" || all[1].Domain != "new.com" {
+		t.Errorf("Expected keep.com updated and new.com renamed, got %+v", all)
+	}
+}
+
+func testConformanceSwitchHistory(t *testing.T, s Storage) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	records := []SwitchRecord{
+		{Trigger: TriggerManual, StartedAt: base.Add(time.Hour), Domains: []DomainSwitchOutcome{{Domain: "a.com"}}},
+		{Trigger: TriggerAutomatic, StartedAt: base, Domains: []DomainSwitchOutcome{{Domain: "b.com"}}},
+		{Trigger: TriggerManual, StartedAt: base, Domains: []DomainSwitchOutcome{{Domain: "a.com"}, {Domain: "b.com"}}},
+	}
+	for i := range records {
+		if err := s.SaveSwitchRecord(&records[i]); err != nil || records[i].ID == "" {
+			t.Fatalf("Expected record saved with an ID, got %+v, %v", records[i], err)
+		}
+	}
+
+	all, err := s.GetSwitchHistory(base, base.Add(time.Hour))
+	if err != nil || len(all) != 3 {
+		t.Fatalf("Expected 3 records, got %+v, %v", all, err)
+	}
+	if all[0].Trigger != TriggerAutomatic || all[1].Trigger != TriggerManual || !all[2].StartedAt.Equal(base.Add(time.Hour)) {
+		t.Errorf("Expected records by start time then save order, got %+v", all)
+	}
+
+	if first, _ := s.GetSwitchHistory(base, base.Add(time.Minute)); len(first) != 2 {
+		t.Errorf("Expected 2 records in the first minute, got %d", len(first))
+	}
+
+	domain, _ := s.GetDomainSwitchHistory("a.com", base, base.Add(time.Hour))
+	if len(domain) != 2 || len(domain[0].Domains) != 1 || domain[0].Domains[0].Domain != "a.com" {
+		t.Errorf("Expected 2 records with only a.com outcomes, got %+v", domain)
+	}
+}
+
+func testConformanceServerChecks(t *testing.T, s Storage) {
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	err := s.AppendServerChecks([]ServerCheck{
+		{Host: "b", At: now.Add(-time.Minute), IsUp: true},
+		{Host: "a", At: now.Add(-40 * 24 * time.Hour), IsUp: true},
+		{Host: "a", At: now.Add(-2 * time.Minute), IsUp: false, Error: "timeout"},
+		{Host: "a", At: now.Add(-time.Minute), IsUp: true},
+	})
+	if err != nil {
+		t.Fatalf("Failed to append checks: %v", err)
+	}
+
+	hosts, _ := s.GetCheckedHosts()
+	if len(hosts) != 2 || hosts[0] != "a" {
+		t.Errorf("Expected hosts a and b, got %v", hosts)
+	}
+
+	recent, _ := s.GetServerChecks("a", now.Add(-time.Hour), now)
+	if len(recent) != 2 || recent[0].IsUp || recent[0].Count != 1 || recent[1].UpCount != 1 {
+		t.Errorf("Expected 2 normalized checks oldest first, got %+v", recent)
+	}
+
+	removed, err := s.CompactServerChecks(RetentionPolicy{Retention: 30 * 24 * time.Hour}, now)
+	if err != nil || removed != 1 {
+		t.Errorf("Expected 1 expired check removed, got %d, %v", removed, err)
+	}
+	if none, _ := s.GetServerChecks("missing", now.Add(-time.Hour), now); len(none) != 0 {
+		t.Errorf("Expected no checks of unknown host, got %+v", none)
+	}
+}
+
+func testConformanceDnsStates(t *testing.T, s Storage) {
+	now := time.Now()
+	_ = s.SaveDomains([]DomainRow{{Domain: "a.com"}, {Domain: "b.com"}, {Domain: "c.com"}})
+
+	err := s.SaveDnsStates([]DnsState{
+		{Domain: "b.com", IP: "10.0.0.2", ProxyHost: "10.0.0.2", ObservedAt: now},
+		{Domain: "a.com", IP: "10.0.0.1", ProxyHost: "10.0.0.1", ObservedAt: now},
+		{Domain: "c.com", IP: "192.168.0.1", ObservedAt: now},
+	})
+	if err != nil {
+		t.Fatalf("Failed to save dns states: %v", err)
+	}
+
+	// an observation started before the stored one is ignored
+	_ = s.SaveDnsStates([]DnsState{{Domain: "a.com", IP: "stale", ObservedAt: now.Add(-time.Second)}})
+
+	states, err := s.GetDnsStates()
+	if err != nil || len(states) != 3 || states[0].Domain != "a.com" || states[0].IP != "10.0.0.1" {
+		t.Fatalf("Expected states sorted by domain without the stale one, got %+v, %v", states, err)
+	}
+
+	distribution := ProxyDistribution(states)
+	if len(distribution["10.0.0.1"]) != 1 || len(distribution[""]) != 1 {
+		t.Errorf("Unexpected distribution %+v", distribution)
+	}
+
+	if _, err := s.SyncDomains([]DomainRow{{Domain: "a.com"}, {Domain: "c.com"}}, nil); err != nil {
+		t.Fatalf("Failed to sync domains: %v", err)
+	}
+	if states, _ := s.GetDnsStates(); len(states) != 2 {
+		t.Errorf("Expected dns state of removed domain deleted, got %+v", states)
+	}
+}
+
+func testConformanceIndexes(t *testing.T, s Storage) {
+	now := time.Now()
+	_ = s.SaveDomains([]DomainRow{
+		{Domain: "b.com", HostingIP: "1", Hostings: []HostingBackend{{IP: "1"}, {IP: "2"}}},
+		{Domain: "a.com", HostingIP: "1"},
+		{Domain: "c.com", HostingIP: "3"},
+	})
+	_ = s.SaveDnsStates([]DnsState{
+		{Domain: "a.com", IP: "p1", ProxyHost: "p1", ObservedAt: now},
+		{Domain: "b.com", IP: "p1", ProxyHost: "p1", ObservedAt: now},
+		{Domain: "c.com", IP: "p2", ProxyHost: "p2", ObservedAt: now},
+		{Domain: "unknown.com", IP: "p1", ProxyHost: "p1", ObservedAt: now},
+	})
+
+	names := func(rows []DomainRow) string {
+		out := []string{}
+		for _, d := range rows {
+			out = append(out, d.Domain)
+		}
+		return strings.Join(out, ",")
+	}
+
+	if rows, err := s.GetDomainsByHostingIP("1"); err != nil || names(rows) != "a.com,b.com" {
+		t.Errorf("Expected a.com,b.com on hosting 1, got %s, %v", names(rows), err)
+	}
+	if rows, _ := s.GetDomainsByProxy("p1"); names(rows) != "a.com,b.com" {
+		t.Errorf("Expected stored domains on p1, got %s", names(rows))
+	}
+
+	// moving a backend and a domain updates both indexes
+	_ = s.SaveDomains([]DomainRow{{Domain: "b.com", HostingIP: "2", Hostings: []HostingBackend{{IP: "2"}}}})
+	_ = s.SaveDnsStates([]DnsState{{Domain: "b.com", IP: "p2", ProxyHost: "p2", ObservedAt: now.Add(time.Second)}})
+	if rows, _ := s.GetDomainsByHostingIP("1"); names(rows) != "a.com" {
+		t.Errorf("Expected only a.com on hosting 1 after the change, got %s", names(rows))
+	}
+	if rows, _ := s.GetDomainsByProxy("p2"); names(rows) != "b.com,c.com" {
+		t.Errorf("Expected b.com,c.com on p2, got %s", names(rows))
+	}
+
+	_, _ = s.SyncDomains([]DomainRow{{Domain: "a.com", HostingIP: "1"}, {Domain: "b.com", HostingIP: "2", Hostings: []HostingBackend{{IP: "2"}}}}, nil)
+	if rows, _ := s.GetDomainsByHostingIP("3"); len(rows) != 0 {
+		t.Errorf("Expected removed c.com out of the hosting index, got %s", names(rows))
+	}
+	if rows, _ := s.GetDomainsByProxy("p2"); names(rows) != "b.com" {
+		t.Errorf("Expected removed c.com out of the proxy index, got %s", names(rows))
+	}
+
+	_ = s.SaveDomains([]DomainRow{{Domain: "d.com", HostingIP: "4"}})
+	if rows, err := s.GetDomainsNotObservedSince(now); err != nil || names(rows) != "d.com" {
+		t.Errorf("Expected only d.com without a state, got %s, %v", names(rows), err)
+	}
+	if rows, _ := s.GetDomainsNotObservedSince(now.Add(time.Millisecond)); names(rows) != "a.com,d.com" {
+		t.Errorf("Expected a.com observed before since and d.com, got %s", names(rows))
+	}
+
+	visited := []string{}
+	stop := errors.New("stop")
+	err := s.ForEachDomain(func(d DomainRow) error {
+		visited = append(visited, d.Domain)
+		return stop
+	})
+	if !errors.Is(err, stop) || len(visited) != 1 || visited[0] != "a.com" {
+		t.Errorf("Expected iteration in order stopped by the callback error, got %v, %v", visited, err)
+	}
+}
+
+func testConformanceTokens(t *testing.T, s Storage) {
+	_ = s.SaveDomains([]DomainRow{
+		{Domain: "a.com", CfApiToken: "shared-token-0001", AccountRecordID: "recA"},
+		{Domain: "b.com", CfApiToken: "shared-token-0001"},
+		{Domain: "c.com", CfApiToken: "other-token-00002"},
+		{Domain: "d.com"},
+	})
+
+	tokens, err := s.GetTokens()
+	if err != nil || len(tokens) != 2 {
+		t.Fatalf("Expected 2 distinct tokens, got %+v, %v", tokens, err)
+	}
+
+	rows, _ := s.GetAllDomains()
+	if rows[0].TokenRef == "" || rows[0].TokenRef != rows[1].TokenRef || rows[3].TokenRef != "" {
+		t.Errorf("Expected a.com and b.com to share a token reference, got %+v", rows)
+	}
+	shared := rows[0].TokenRef
+
+	if err := s.SetTokenLabel(shared, "main account"); err != nil {
+		t.Errorf("Failed to set label: %v", err)
+	}
+	verifiedAt := time.Now()
+	if err := s.SetTokenStatus(shared, TokenActive, verifiedAt); err != nil {
+		t.Errorf("Failed to set status: %v", err)
+	}
+	if err := s.SetTokenLabel("tk_missing", "x"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Expected not found error, got %v", err)
+	}
+
+	if err := s.RotateToken(shared, "other-token-00002"); err == nil {
+		t.Error("Expected rotation to a value of another token to fail")
+	}
+	if err := s.RotateToken(shared, "rotated-token-003"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+
+	rows, _ = s.GetAllDomains()
+	if rows[0].CfApiToken.Reveal() != "rotated-token-003" || rows[1].CfApiToken.Reveal() != "rotated-token-003" {
+		t.Errorf("Expected rotated value for every domain of the token, got %+v", rows)
+	}
+
+	tokens, _ = s.GetTokens()
+	for _, token := range tokens {
+		if token.ID != shared {
+			continue
+		}
+		if token.Label != "main account" || token.Account != "recA" || token.Status != TokenUnverified || token.RotatedAt.IsZero() {
+			t.Errorf("Unexpected token after rotation %+v", token)
+		}
+		if len(token.Aliases) != 1 || token.Aliases[0] != TokenFingerprint("shared-token-0001") {
+			t.Errorf("Expected the old value as an alias, got %+v", token.Aliases)
+		}
+	}
+
+	// a source still sending the old value keeps the rotated one
+	diff, err := s.SyncDomains([]DomainRow{
+		{Domain: "a.com", CfApiToken: "shared-token-0001", AccountRecordID: "recA"},
+		{Domain: "b.com", CfApiToken: "shared-token-0001"},
+		{Domain: "c.com", CfApiToken: "other-token-00002"},
+		{Domain: "d.com"},
+	}, nil)
+	if err != nil || !diff.IsEmpty() {
+		t.Errorf("Expected no changes syncing the old value, got %+v, %v", diff, err)
+	}
+	if rows, _ := s.GetAllDomains(); rows[1].CfApiToken.Reveal() != "rotated-token-003" {
+		t.Errorf("Expected rotated value kept after sync, got %+v", rows[1])
+	}
+
+	// the token of a removed domain is deleted with its fingerprint
+	_, _ = s.SyncDomains([]DomainRow{
+		{Domain: "a.com", CfApiToken: "rotated-token-003"},
+		{Domain: "b.com", CfApiToken: "rotated-token-003"},
+	}, nil)
+	if tokens, _ := s.GetTokens(); len(tokens) != 1 || tokens[0].ID != shared {
+		t.Errorf("Expected only the used token kept, got %+v", tokens)
+	}
+	_ = s.SaveDomains([]DomainRow{{Domain: "c.com", CfApiToken: "other-token-00002"}})
+	if tokens, _ := s.GetTokens(); len(tokens) != 2 || tokens[1].Status != TokenUnverified || tokens[1].ID == "" {
+		t.Errorf("Expected the value of the deleted token stored again as a new token, got %+v", tokens)
+	}
+}
+
+func testConformanceProxyPool(t *testing.T, s Storage) {
+	now := time.Now()
+	_ = s.SavePoolProxy(PoolProxy{Proxy: ProxyRow{Name: "fra", Address: "10.0.0.1", Port: 80}, Enabled: true, Source: PoolSourceConfig})
+	_ = s.SavePoolProxy(PoolProxy{Proxy: ProxyRow{Name: "ams", Address: "10.0.0.2", Port: 80, Tags: []string{"eu"}}, Source: PoolSourceApi})
+	_ = s.SaveProxyServers([]ProxyServerRow{{Host: "10.0.0.1", IsUp: true}, {Host: "10.0.0.2", IsUp: true}})
+	_ = s.AppendServerChecks([]ServerCheck{{Host: "10.0.0.1", At: now, IsUp: true}, {Host: "10.0.0.2", At: now, IsUp: true}})
+
+	pool, err := s.GetProxyPool()
+	if err != nil || len(pool) != 2 || pool[0].Proxy.Name != "ams" || pool[0].Enabled || pool[0].Proxy.Tags[0] != "eu" || !pool[1].Enabled {
+		t.Fatalf("Expected pool sorted by name, got %+v, %v", pool, err)
+	}
+
+	if err := s.DeleteProxyServer("10.0.0.2"); err != nil {
+		t.Errorf("Failed to delete server: %v", err)
+	}
+	if servers, _ := s.GetProxyServers(false); len(servers) != 1 || servers[0].Host != "10.0.0.1" {
+		t.Errorf("Expected only the fra server left, got %+v", servers)
+	}
+	if checks, _ := s.GetServerChecks("10.0.0.2", now.Add(-time.Minute), now.Add(time.Minute)); len(checks) != 1 {
+		t.Errorf("Expected checks kept when only the server row is deleted, got %+v", checks)
+	}
+
+	if err := s.DeletePoolProxy("fra"); err != nil {
+		t.Errorf("Failed to delete proxy: %v", err)
+	}
+	if err := s.DeletePoolProxy("missing"); err != nil {
+		t.Errorf("Expected deleting a missing proxy to be a no-op, got %v", err)
+	}
+
+	pool, _ = s.GetProxyPool()
+	servers, _ := s.GetProxyServers(false)
+	hosts, _ := s.GetCheckedHosts()
+	if len(pool) != 1 || len(servers) != 0 || len(hosts) != 1 || hosts[0] != "10.0.0.2" {
+		t.Errorf("Expected the removed proxy cleaned out, got pool %+v, servers %+v, checked %v", pool, servers, hosts)
+	}
+}
+
+func testConformanceCertChecks(t *testing.T, s Storage) {
+	now := time.Now().UTC().Truncate(time.Second)
+	_ = s.SavePoolProxy(PoolProxy{Proxy: ProxyRow{Name: "fra", Address: "10.0.0.1", Port: 80}, Enabled: true})
+	_ = s.SaveCertChecks([]CertCheck{
+		{Host: "10.0.0.2", Domain: "a.com", CheckedAt: now, NotAfter: now.Add(48 * time.Hour), Valid: true, AlertedDays: 7},
+		{Host: "10.0.0.1", Domain: "b.com", CheckedAt: now, Error: "connection refused"},
+		{Host: "10.0.0.1", Domain: "a.com", CheckedAt: now, NotAfter: now.Add(time.Hour)},
+		{Host: "10.0.0.2", Domain: "b.com", CheckedAt: now, NotAfter: now.Add(time.Hour), Valid: true},
+	})
+
+	checks, err := s.GetCertChecks()
+	if err != nil || len(checks) != 4 || checks[0].Domain != "a.com" || checks[0].Host != "10.0.0.1" || checks[2].AlertedDays != 7 {
+		t.Fatalf("Expected checks sorted by host and domain, got %+v, %v", checks, err)
+	}
+	if !checks[2].NotAfter.Equal(now.Add(48*time.Hour)) || checks[2].DaysLeft(now) != 2 || checks[1].HasCert() {
+		t.Errorf("Unexpected check fields %+v", checks)
+	}
+
+	if err := s.PruneCertChecks("10.0.0.2", []string{"b.com"}); err != nil {
+		t.Errorf("Failed to prune: %v", err)
+	}
+	if err := s.DeletePoolProxy("fra"); err != nil {
+		t.Errorf("Failed to delete proxy: %v", err)
+	}
+	if checks, _ := s.GetCertChecks(); len(checks) != 1 || checks[0].Host != "10.0.0.2" || checks[0].Domain != "b.com" {
+		t.Errorf("Expected only b.com on 10.0.0.2 left, got %+v", checks)
+	}
+}
+
+func testConformanceHeartbeats(t *testing.T, s Storage) {
+	now := time.Now().UTC().Truncate(time.Second)
+	_ = s.SavePoolProxy(PoolProxy{Proxy: ProxyRow{Name: "fra", Address: "10.0.0.1", Port: 80}, Enabled: true})
+	_ = s.SaveHeartbeat(Heartbeat{Host: "10.0.0.2", ReceivedAt: now, Service: "nginx", ServiceUp: true, Load: []float64{0.5, 0.4, 0.3}})
+	_ = s.SaveHeartbeat(Heartbeat{Host: "10.0.0.1", ReceivedAt: now, ConfigVersion: "v1"})
+	_ = s.SaveHeartbeat(Heartbeat{Host: "10.0.0.1", ReceivedAt: now.Add(time.Minute), ConfigVersion: "v2"})
+
+	heartbeats, err := s.GetHeartbeats()
+	if err != nil || len(heartbeats) != 2 || heartbeats[0].Host != "10.0.0.1" || heartbeats[0].ConfigVersion != "v2" {
+		t.Fatalf("Expected the last heartbeat per host sorted by host, got %+v, %v", heartbeats, err)
+	}
+	if !heartbeats[1].ReceivedAt.Equal(now) || !heartbeats[1].ServiceUp || len(heartbeats[1].Load) != 3 {
+		t.Errorf("Unexpected heartbeat fields %+v", heartbeats[1])
+	}
+
+	if err := s.DeletePoolProxy("fra"); err != nil {
+		t.Errorf("Failed to delete proxy: %v", err)
+	}
+	if heartbeats, _ := s.GetHeartbeats(); len(heartbeats) != 1 || heartbeats[0].Host != "10.0.0.2" {
+		t.Errorf("Expected only 10.0.0.2 left, got %+v", heartbeats)
+	}
+}
+
+func testConformanceHeldSync(t *testing.T, s Storage) {
+	if held, err := s.GetHeldSync(); err != nil || held != "" {
+		t.Fatalf("Expected no held sync, got %q, %v", held, err)
+	}
+	_ = s.SaveHeldSync("abc")
+	if held, _ := s.GetHeldSync(); held != "abc" {
+		t.Errorf("Expected the saved fingerprint, got %q", held)
+	}
+	_ = s.SaveHeldSync("")
+	if held, _ := s.GetHeldSync(); held != "" {
+		t.Errorf("Expected the held sync cleared, got %q", held)
+	}
+}
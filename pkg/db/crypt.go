@@ -0,0 +1,348 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	encryptedPrefix = "enc:v2:" // bound to the record with additional data
+	legacyPrefix    = "enc:v1:" // no additional data, still decrypted
+	tokenKeySize    = 32
+)
+
+var ErrNoTokenKey = errors.New("db: no token key configured")
+
+// Token is a Cloudflare API token, it is redacted when formatted or logged
+type Token string
+
+func (t Token) String() string {
+	return RedactToken(string(t))
+}
+
+func (t Token) GoString() string {
+	return fmt.Sprintf("%q", t.String())
+}
+
+// Reveal returns the plain token for API calls
+func (t Token) Reveal() string {
+	return string(t)
+}
+
+// RedactToken keeps only the last 4 characters of long tokens
+func RedactToken(token string) string {
+	if token == "" {
+		return ""
+	}
+	if len(token) < 16 {
+		return "****"
+	}
+	return "****" + token[len(token)-4:]
+}
+
+// Keyring holds key encryption keys by ID, the active key encrypts new values
+// and all keys can decrypt, which allows rotation with a re-encrypt run.
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+// ParseKeyring reads "id:base64key" entries separated by new lines or semicolons,
+// the first entry is the active key
+func ParseKeyring(data string) (*Keyring, error) {
+	k := &Keyring{keys: map[string][]byte{}}
+
+	entries := strings.FieldsFunc(data, func(r rune) bool { return r == '\n' || r == ';' })
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("db: token key entry must be id:base64key")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("db: token key %s: %w", id, err)
+		}
+		if len(key) != tokenKeySize {
+			return nil, fmt.Errorf("db: token key %s must be %d bytes, got %d", id, tokenKeySize, len(key))
+		}
+		if _, ok := k.keys[id]; ok {
+			return nil, fmt.Errorf("db: duplicate token key %s", id)
+		}
+
+		if k.active == "" {
+			k.active = id
+		}
+		k.keys[id] = key
+	}
+
+	if k.active == "" {
+		return nil, nil
+	}
+	return k, nil
+}
+
+// LoadKeyring reads keys from the file, or from the env variable when the file is not set.
+// It returns nil without error when neither is configured. A key file readable by group
+// or others is refused.
+func LoadKeyring(file, env string) (*Keyring, error) {
+	if file != "" {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		if perm := info.Mode().Perm(); perm&0o077 != 0 {
+			return nil, fmt.Errorf("db: token key file %s must only be readable by its owner, mode is %04o", file, perm)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return ParseKeyring(string(data))
+	}
+
+	if env != "" {
+		return ParseKeyring(os.Getenv(env))
+	}
+
+	return nil, nil
+}
+
+// GenerateTokenKey returns a new keyring entry
+func GenerateTokenKey(id string) (string, error) {
+	key := make([]byte, tokenKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(key), nil
+}
+
+func (k *Keyring) ActiveKeyID() string {
+	return k.active
+}
+
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key, sealed, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("db: sealed value too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+// Encrypt seals the value with a fresh data key which is wrapped with the active key:
+// enc:v2:<key id>:<wrapped data key>:<ciphertext>.
+// Both are bound to aad, so a value only decrypts for the record it was sealed for.
+func (k *Keyring) Encrypt(plain, aad string) (string, error) {
+	dataKey := make([]byte, tokenKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+
+	ciphertext, err := seal(dataKey, []byte(plain), []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := seal(k.keys[k.active], dataKey, []byte(aad))
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedPrefix + k.active + ":" +
+		base64.StdEncoding.EncodeToString(wrapped) + ":" +
+		base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt opens a value produced by Encrypt with the same aad,
+// values sealed before aad was used ignore it
+func (k *Keyring) Decrypt(value, aad string) (string, error) {
+	var additional []byte
+	if strings.HasPrefix(value, encryptedPrefix) {
+		additional = []byte(aad)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(strings.TrimPrefix(value, encryptedPrefix), legacyPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("db: malformed encrypted value")
+	}
+
+	key, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("db: unknown token key %s", parts[0])
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+
+	dataKey, err := open(key, wrapped, additional)
+	if err != nil {
+		return "", fmt.Errorf("db: unwrap data key: %w", err)
+	}
+	plain, err := open(dataKey, ciphertext, additional)
+	if err != nil {
+		return "", fmt.Errorf("db: decrypt value: %w", err)
+	}
+	return string(plain), nil
+}
+
+func isEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix) || strings.HasPrefix(value, legacyPrefix)
+}
+
+// isCurrent reports a value sealed with additional data and the active key
+func (k *Keyring) isCurrent(value string) bool {
+	id, _, _ := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	return strings.HasPrefix(value, encryptedPrefix) && id == k.active
+}
+
+// sealToken encrypts the token for the record with the aad ID when a keyring is set
+func (s *DbStorage) sealToken(t Token, aad string) (Token, error) {
+	if s.keyring == nil || t == "" {
+		return t, nil
+	}
+	encrypted, err := s.keyring.Encrypt(t.Reveal(), aad)
+	return Token(encrypted), err
+}
+
+// openToken decrypts a stored token of the record with the aad ID, plain tokens
+// saved before encryption was enabled are returned as they are
+func (s *DbStorage) openToken(t Token, aad string) (Token, error) {
+	if !isEncrypted(t.Reveal()) {
+		return t, nil
+	}
+	if s.keyring == nil {
+		return t, ErrNoTokenKey
+	}
+	plain, err := s.keyring.Decrypt(t.Reveal(), aad)
+	return Token(plain), err
+}
+
+// encodeDomain serializes the row, its token is moved to the token store and referenced
+func (s *DbStorage) encodeDomain(tx *bolt.Tx, d DomainRow) ([]byte, error) {
+	ref, err := s.tokenRef(tx, d.CfApiToken, d.AccountRecordID)
+	if err != nil {
+		return nil, err
+	}
+	d.TokenRef = ref
+	d.CfApiToken = ""
+	return json.Marshal(d)
+}
+
+// decodeDomain parses the row and resolves its token. Rows saved before the
+// token store keep the token inline, encrypted or plain.
+func (s *DbStorage) decodeDomain(tx *bolt.Tx, v []byte) (DomainRow, error) {
+	var d DomainRow
+	if err := json.Unmarshal(v, &d); err != nil {
+		return d, err
+	}
+
+	if d.TokenRef != "" {
+		record, err := s.getToken(tx, d.TokenRef)
+		if err != nil {
+			return d, fmt.Errorf("token of %s: %w", d.Domain, err)
+		}
+		d.CfApiToken = record.Value
+		return d, nil
+	}
+
+	plain, err := s.openToken(d.CfApiToken, d.Domain)
+	if err != nil {
+		return d, fmt.Errorf("token of %s: %w", d.Domain, err)
+	}
+	d.CfApiToken = plain
+	return d, nil
+}
+
+// ReencryptTokens seals every stored token with the active key, including
+// plain tokens, tokens sealed with older keys and tokens sealed without additional data. It returns the number of rewritten tokens.
+func (s *DbStorage) ReencryptTokens() (int, error) {
+	if s.keyring == nil {
+		return 0, ErrNoTokenKey
+	}
+
+	count := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tokensBucket)
+
+		updates := map[string][]byte{}
+		err := b.ForEach(func(k, v []byte) error {
+			var record TokenRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			stored := record.Value.Reveal()
+			if stored == "" || s.keyring.isCurrent(stored) {
+				return nil
+			}
+
+			var err error
+			if record.Value, err = s.openToken(record.Value, record.ID); err != nil {
+				return fmt.Errorf("token %s: %w", record.ID, err)
+			}
+			if record.Value, err = s.sealToken(record.Value, record.ID); err != nil {
+				return err
+			}
+			val, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			updates[string(k)] = val
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for k, v := range updates {
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		count = len(updates)
+		return nil
+	})
+
+	return count, err
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+const testToken = "cf-token-0123456789abcdef"
+
+func TestToken_Redacted(t *testing.T) {
+	row := DomainRow{Domain: "a.com", CfApiToken: testToken}
+
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		out := fmt.Sprintf(format, row)
+		if strings.Contains(out, testToken) {
+			t.Errorf("Expected token redacted with %s, got %s", format, out)
+		}
+	}
+
+	if row.CfApiToken.Reveal() != testToken {
+		t.Error("Expected Reveal to return the plain token")
+	}
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	oldKey, _ := GenerateTokenKey("old")
+	newKey, _ := GenerateTokenKey("new")
+
+	oldRing, err := ParseKeyring(oldKey)
+	if err != nil {
+		t.Fatalf("Failed to parse keyring: %v", err)
+	}
+	sealed, err := oldRing.Encrypt(testToken, "tk_a")
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+
+	rotated, err := ParseKeyring(newKey + ";" + oldKey)
+	if err != nil {
+		t.Fatalf("Failed to parse keyring: %v", err)
+	}
+	if rotated.ActiveKeyID() != "new" {
+		t.Errorf("Expected first key to be active, got %s", rotated.ActiveKeyID())
+	}
+
+	plain, err := rotated.Decrypt(sealed, "tk_a")
+	if err != nil || plain != testToken {
+		t.Errorf("Expected old value to decrypt after rotation, got %q, %v", plain, err)
+	}
+
+	newRing, _ := ParseKeyring(newKey)
+	if _, err := newRing.Decrypt(sealed, "tk_a"); err == nil {
+		t.Error("Expected decrypt to fail without the old key")
+	}
+}
+
+func TestKeyring_BindsValueToRecord(t *testing.T) {
+	key, _ := GenerateTokenKey("k")
+	ring, _ := ParseKeyring(key)
+
+	sealed, _ := ring.Encrypt(testToken, "tk_a")
+	if _, err := ring.Decrypt(sealed, "tk_b"); err == nil {
+		t.Error("Expected a value moved to another record not to decrypt")
+	}
+
+	// values sealed before additional data was used
+	raw, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(key, "k:"))
+	dataKey := make([]byte, tokenKeySize)
+	wrapped, _ := seal(raw, dataKey, nil)
+	ciphertext, _ := seal(dataKey, []byte(testToken), nil)
+	legacy := legacyPrefix + "k:" + base64.StdEncoding.EncodeToString(wrapped) + ":" + base64.StdEncoding.EncodeToString(ciphertext)
+	if plain, err := ring.Decrypt(legacy, "tk_a"); err != nil || plain != testToken {
+		t.Errorf("Expected a legacy value to decrypt, got %q, %v", plain, err)
+	}
+	if ring.isCurrent(legacy) || !ring.isCurrent(sealed) {
+		t.Error("Expected only the value with additional data to be current")
+	}
+}
+
+func TestLoadKeyring_RefusesReadableFile(t *testing.T) {
+	key, _ := GenerateTokenKey("k")
+	path := filepath.Join(t.TempDir(), "keys")
+	_ = os.WriteFile(path, []byte(key), 0o644)
+
+	if _, err := LoadKeyring(path, ""); err == nil || !strings.Contains(err.Error(), "only be readable by its owner") {
+		t.Errorf("Expected a world readable key file to be refused, got %v", err)
+	}
+
+	_ = os.Chmod(path, 0o600)
+	if ring, err := LoadKeyring(path, ""); err != nil || ring.ActiveKeyID() != "k" {
+		t.Errorf("Expected the key file to load, got %v", err)
+	}
+}
+
+func TestDbStorage_EncryptsTokens(t *testing.T) {
+	oldKey, _ := GenerateTokenKey("old")
+	newKey, _ := GenerateTokenKey("new")
+	path := filepath.Join(t.TempDir(), "enc.boltdb")
+
+	// a plain token saved before encryption was enabled
+	plainStorage, err := NewStorage(Options{Path: path})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	_ = plainStorage.SaveDomains([]DomainRow{{Domain: "plain.com", CfApiToken: testToken}})
+	plainStorage.Close()
+
+	t.Setenv("TEST_TOKEN_KEYS", oldKey)
+	storage, err := NewStorage(Options{Path: path, TokenKeysEnv: "TEST_TOKEN_KEYS"})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	_ = storage.SaveDomains([]DomainRow{{Domain: "sealed.com", CfApiToken: testToken}})
+
+	rows, err := storage.GetDomainWithCfTokens()
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("Expected 2 domains with tokens, got %+v, %v", rows, err)
+	}
+	for _, row := range rows {
+		if row.CfApiToken.Reveal() != testToken {
+			t.Errorf("Expected decrypted token for %s", row.Domain)
+		}
+	}
+	storage.Close()
+
+	t.Setenv("TEST_TOKEN_KEYS", newKey+";"+oldKey)
+	storage, err = NewStorage(Options{Path: path, TokenKeysEnv: "TEST_TOKEN_KEYS"})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	// both domains share one record in the token store
+	count, err := storage.ReencryptTokens()
+	if err != nil || count != 1 {
+		t.Errorf("Expected 1 reencrypted token, got %d, %v", count, err)
+	}
+	storage.Close()
+
+	raw, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		t.Fatalf("Failed to open raw bolt: %v", err)
+	}
+	defer raw.Close()
+	_ = raw.View(func(tx *bolt.Tx) error {
+		_ = tx.Bucket(domainsBucket).ForEach(func(k, v []byte) error {
+			if strings.Contains(string(v), testToken) || strings.Contains(string(v), encryptedPrefix) {
+				t.Errorf("Expected %s to only reference its token, got %s", k, v)
+			}
+			return nil
+		})
+		return tx.Bucket(tokensBucket).ForEach(func(k, v []byte) error {
+			if strings.Contains(string(v), testToken) || !strings.Contains(string(v), encryptedPrefix+"new:") {
+				t.Errorf("Expected token %s sealed with the new key, got %s", k, v)
+			}
+			return nil
+		})
+	})
+}
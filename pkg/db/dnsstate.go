@@ -0,0 +1,70 @@
+package db
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var dnsStateBucket = []byte("dns_state")
+
+// DnsState is the last observed A record of a domain
+type DnsState struct {
+	Domain     string    `json:"domain"`
+	IP         string    `json:"ip"`
+	ProxyHost  string    `json:"proxy_host,omitempty"` // proxy server the IP belongs to, empty if none
+	ObservedAt time.Time `json:"observed_at"`
+}
+
+// newer reports whether the state was observed after the stored one,
+// a slow refresh must not overwrite the result of a switch done meanwhile
+func (d DnsState) newer(stored DnsState) bool {
+	return !d.ObservedAt.Before(stored.ObservedAt)
+}
+
+// SaveDnsStates stores observed states, older observations than the stored ones are ignored
+func (s *DbStorage) SaveDnsStates(states []DnsState) error {
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		for _, state := range states {
+			if err := putDnsState(tx, state); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetDnsStates returns observed states sorted by domain
+func (s *DbStorage) GetDnsStates() ([]DnsState, error) {
+	states := []DnsState{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(dnsStateBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var state DnsState
+			if err := json.Unmarshal(v, &state); err != nil {
+				return err
+			}
+			states = append(states, state)
+			return nil
+		})
+	})
+	return states, err
+}
+
+// ProxyDistribution groups domains by the proxy they point to,
+// domains pointing elsewhere are under the empty key
+func ProxyDistribution(states []DnsState) map[string][]string {
+	distribution := map[string][]string{}
+	for _, state := range states {
+		distribution[state.ProxyHost] = append(distribution[state.ProxyHost], state.Domain)
+	}
+	for _, domains := range distribution {
+		sort.Strings(domains)
+	}
+	return distribution
+}
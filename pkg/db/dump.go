@@ -0,0 +1,246 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var ErrNotEmpty = errors.New("db: not empty")
+
+// dumpFormat is the version of the Dump layout, bump it on incompatible changes
+const dumpFormat = 1
+
+// Dump is a portable copy of all stored data. Tokens are plain unless Redacted,
+// they are encrypted again with the keys of the storage the dump is imported to.
+type Dump struct {
+	Format        int       `json:"format"`
+	SchemaVersion int       `json:"schema_version"`
+	ExportedAt    time.Time `json:"exported_at"`
+	Redacted      bool      `json:"redacted"` // tokens were removed
+
+	Domains       []DomainRow      `json:"domains"`
+	Tokens        []TokenRecord    `json:"tokens"` // left out when Redacted
+	Servers       []ProxyServerRow `json:"servers"`
+	SwitchHistory []SwitchRecord   `json:"switch_history"`
+	ServerChecks  []ServerCheck    `json:"server_checks"`
+	DnsStates     []DnsState       `json:"dns_states"`
+	ProxyPool     []PoolProxy      `json:"proxy_pool"`
+	CertChecks    []CertCheck      `json:"cert_checks"`
+	Heartbeats    []Heartbeat      `json:"heartbeats"`
+}
+
+// allTimeFrom and allTimeTo cover every stored record in range queries
+var allTimeFrom, allTimeTo = time.Unix(0, 0), time.Unix(0, math.MaxInt64)
+
+// Export reads everything from the storage, tokens are dropped when redact is set
+func Export(s Storage, redact bool) (Dump, error) {
+	dump := Dump{
+		Format:        dumpFormat,
+		SchemaVersion: SchemaVersion(),
+		ExportedAt:    time.Now(),
+		Redacted:      redact,
+	}
+
+	var err error
+	if dump.Domains, err = s.GetAllDomains(); err != nil {
+		return dump, fmt.Errorf("db: export domains: %w", err)
+	}
+	if redact {
+		for i := range dump.Domains {
+			dump.Domains[i].CfApiToken = ""
+		}
+	} else if dump.Tokens, err = s.GetTokens(); err != nil {
+		return dump, fmt.Errorf("db: export tokens: %w", err)
+	}
+
+	if dump.Servers, err = s.GetProxyServers(false); err != nil {
+		return dump, fmt.Errorf("db: export servers: %w", err)
+	}
+
+	if dump.SwitchHistory, err = s.GetSwitchHistory(allTimeFrom, allTimeTo); err != nil {
+		return dump, fmt.Errorf("db: export switch history: %w", err)
+	}
+
+	hosts, err := s.GetCheckedHosts()
+	if err != nil {
+		return dump, fmt.Errorf("db: export server checks: %w", err)
+	}
+	for _, host := range hosts {
+		checks, err := s.GetServerChecks(host, allTimeFrom, allTimeTo)
+		if err != nil {
+			return dump, fmt.Errorf("db: export server checks of %s: %w", host, err)
+		}
+		dump.ServerChecks = append(dump.ServerChecks, checks...)
+	}
+
+	if dump.DnsStates, err = s.GetDnsStates(); err != nil {
+		return dump, fmt.Errorf("db: export dns states: %w", err)
+	}
+
+	if dump.ProxyPool, err = s.GetProxyPool(); err != nil {
+		return dump, fmt.Errorf("db: export proxy pool: %w", err)
+	}
+
+	if dump.CertChecks, err = s.GetCertChecks(); err != nil {
+		return dump, fmt.Errorf("db: export cert checks: %w", err)
+	}
+
+	if dump.Heartbeats, err = s.GetHeartbeats(); err != nil {
+		return dump, fmt.Errorf("db: export heartbeats: %w", err)
+	}
+
+	return dump, nil
+}
+
+// Rows returns the number of rows in the dump
+func (d Dump) Rows() int {
+	return len(d.Domains) + len(d.Tokens) + len(d.Servers) + len(d.SwitchHistory) + len(d.ServerChecks) +
+		len(d.DnsStates) + len(d.ProxyPool) + len(d.CertChecks) + len(d.Heartbeats)
+}
+
+// Import saves the dump into the storage, existing rows with the same keys are overwritten.
+// Switch records keep their IDs, so importing a dump twice does not duplicate the history.
+// Domains of a redacted dump keep the tokens they already have in the storage.
+func Import(s Storage, dump Dump) error {
+	if dump.Format > dumpFormat {
+		return fmt.Errorf("db: dump format %d is newer than supported %d", dump.Format, dumpFormat)
+	}
+	if dump.SchemaVersion > SchemaVersion() {
+		return fmt.Errorf("db: dump schema version %d is newer than supported %d", dump.SchemaVersion, SchemaVersion())
+	}
+
+	domains := dump.Domains
+	if dump.Redacted {
+		var err error
+		if domains, err = keepStoredTokens(s, domains); err != nil {
+			return fmt.Errorf("db: import domains: %w", err)
+		}
+	}
+
+	// tokens first, so domains reference them by fingerprint with their labels and aliases
+	if err := s.SaveTokens(dump.Tokens); err != nil {
+		return fmt.Errorf("db: import tokens: %w", err)
+	}
+	if err := s.SaveDomains(domains); err != nil {
+		return fmt.Errorf("db: import domains: %w", err)
+	}
+	if err := s.SaveProxyServers(dump.Servers); err != nil {
+		return fmt.Errorf("db: import servers: %w", err)
+	}
+	for i := range dump.SwitchHistory {
+		if err := s.SaveSwitchRecord(&dump.SwitchHistory[i]); err != nil {
+			return fmt.Errorf("db: import switch history: %w", err)
+		}
+	}
+	if err := s.AppendServerChecks(dump.ServerChecks); err != nil {
+		return fmt.Errorf("db: import server checks: %w", err)
+	}
+	if err := s.SaveDnsStates(dump.DnsStates); err != nil {
+		return fmt.Errorf("db: import dns states: %w", err)
+	}
+	for _, p := range dump.ProxyPool {
+		if err := s.SavePoolProxy(p); err != nil {
+			return fmt.Errorf("db: import proxy pool: %w", err)
+		}
+	}
+	if err := s.SaveCertChecks(dump.CertChecks); err != nil {
+		return fmt.Errorf("db: import cert checks: %w", err)
+	}
+	for _, hb := range dump.Heartbeats {
+		if err := s.SaveHeartbeat(hb); err != nil {
+			return fmt.Errorf("db: import heartbeats: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// keepStoredTokens fills the missing tokens of rows with the ones stored for the same domains
+func keepStoredTokens(s Storage, rows []DomainRow) ([]DomainRow, error) {
+	stored, err := s.GetDomainWithCfTokens()
+	if err != nil {
+		return nil, err
+	}
+	tokens := make(map[string]Token, len(stored))
+	for _, d := range stored {
+		tokens[d.Domain] = d.CfApiToken
+	}
+
+	kept := make([]DomainRow, len(rows))
+	for i, d := range rows {
+		kept[i] = d
+		if d.CfApiToken == "" {
+			kept[i].CfApiToken = tokens[d.Domain]
+		}
+	}
+	return kept, nil
+}
+
+// ImportFile imports the dump into a copy of the bolt file and replaces the file with the
+// copy once everything is saved, a failed import leaves the db as it was. Unless merge is
+// set, the db must be empty. The file must not be open elsewhere.
+func ImportFile(opts Options, dump Dump, merge bool) error {
+	s, err := NewStorage(opts)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if !merge {
+		existing, err := Export(s, true)
+		if err != nil {
+			return err
+		}
+		if rows := existing.Rows(); rows > 0 {
+			return fmt.Errorf("%w: %s has %d rows", ErrNotEmpty, s.opts.Path, rows)
+		}
+	}
+
+	tmpOpts := s.opts
+	tmpOpts.Path = s.opts.Path + ".import"
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(tmpOpts.Path, 0o600)
+	})
+	if err != nil {
+		return fmt.Errorf("db: copy for import: %w", err)
+	}
+	defer os.Remove(tmpOpts.Path)
+
+	tmp, err := NewStorage(tmpOpts)
+	if err != nil {
+		return err
+	}
+	if err := Import(tmp, dump); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.db.Close(); err != nil {
+		return fmt.Errorf("db: close imported copy: %w", err)
+	}
+
+	return os.Rename(tmpOpts.Path, s.opts.Path)
+}
+
+func WriteDump(w io.Writer, dump Dump) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dump)
+}
+
+func ReadDump(r io.Reader) (Dump, error) {
+	var dump Dump
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return dump, fmt.Errorf("db: read dump: %w", err)
+	}
+	if dump.Format == 0 {
+		return dump, fmt.Errorf("db: not a dump, format is missing")
+	}
+	return dump, nil
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func seedDump(t *testing.T, s Storage) {
+	t.Helper()
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	_ = s.SaveDomains([]DomainRow{{Domain: "a.com", HostingIP: "10.0.0.1", CfApiToken: testToken, Hostings: []HostingBackend{{IP: "10.0.0.1"}}}})
+	_ = s.SaveProxyServers([]ProxyServerRow{{Host: "proxy", IsUp: true, LastCheck: now}})
+	_ = s.SaveSwitchRecord(&SwitchRecord{Trigger: TriggerManual, StartedAt: now, Domains: []DomainSwitchOutcome{{Domain: "a.com"}}})
+	_ = s.AppendServerChecks([]ServerCheck{{Host: "proxy", At: now, IsUp: true}, {Host: "proxy", At: now.Add(time.Minute)}})
+	_ = s.SavePoolProxy(PoolProxy{Proxy: ProxyRow{Name: "proxy", Address: "proxy", Port: 80, PublicIPs: []string{"203.0.113.1"}}, Enabled: true, Source: PoolSourceApi})
+}
+
+func TestExportImport(t *testing.T) {
+	key, _ := GenerateTokenKey("k1")
+	t.Setenv("TEST_TOKEN_KEYS", key)
+	source, err := NewStorage(Options{Path: filepath.Join(t.TempDir(), "source.boltdb"), TokenKeysEnv: "TEST_TOKEN_KEYS"})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	defer source.Close()
+	seedDump(t, source)
+
+	dump, err := Export(source, false)
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if len(dump.Domains) != 1 || len(dump.Servers) != 1 || len(dump.SwitchHistory) != 1 || len(dump.ServerChecks) != 2 {
+		t.Fatalf("Expected every bucket exported, got %+v", dump)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteDump(buf, dump); err != nil {
+		t.Fatalf("Failed to write dump: %v", err)
+	}
+	if !strings.Contains(buf.String(), testToken) {
+		t.Error("Expected plain token in an unredacted dump")
+	}
+
+	read, err := ReadDump(buf)
+	if err != nil {
+		t.Fatalf("Failed to read dump: %v", err)
+	}
+
+	target := NewMemoryStorage()
+	if err := Import(target, read); err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	again, _ := Export(target, false)
+	if len(again.Domains) != 1 || again.Domains[0].CfApiToken.Reveal() != testToken || len(again.ServerChecks) != 2 || len(again.SwitchHistory) != 1 ||
+		len(again.ProxyPool) != 1 || again.ProxyPool[0].Proxy.PublicIPs[0] != "203.0.113.1" || !again.ProxyPool[0].Enabled {
+		t.Errorf("Expected imported copy to match, got %+v", again)
+	}
+
+	redacted, _ := Export(source, true)
+	buf.Reset()
+	_ = WriteDump(buf, redacted)
+	if strings.Contains(buf.String(), testToken) || !redacted.Redacted {
+		t.Error("Expected no token in a redacted dump")
+	}
+	if stored, _ := source.GetAllDomains(); stored[0].CfApiToken.Reveal() != testToken {
+		t.Error("Expected export to leave stored tokens untouched")
+	}
+}
+
+func TestImport_NewerDump(t *testing.T) {
+	if err := Import(NewMemoryStorage(), Dump{Format: dumpFormat + 1}); err == nil {
+		t.Error("Expected error for a newer dump format")
+	}
+	if err := Import(NewMemoryStorage(), Dump{Format: dumpFormat, SchemaVersion: SchemaVersion() + 1}); err == nil {
+		t.Error("Expected error for a newer schema version")
+	}
+	if _, err := ReadDump(strings.NewReader(`{"domains":[]}`)); err == nil {
+		t.Error("Expected error for JSON without a format")
+	}
+}
+
+func TestImportFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "target.boltdb")
+	source := NewMemoryStorage()
+	seedDump(t, source)
+	dump, _ := Export(source, false)
+
+	if err := ImportFile(Options{Path: path}, dump, false); err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if err := ImportFile(Options{Path: path}, dump, false); !errors.Is(err, ErrNotEmpty) {
+		t.Errorf("Expected a db with data to be refused without merge, got %v", err)
+	}
+
+	// a redacted merge keeps the stored token and the history is not duplicated
+	redacted, _ := Export(source, true)
+	if err := ImportFile(Options{Path: path}, redacted, true); err != nil {
+		t.Fatalf("Failed to merge: %v", err)
+	}
+
+	// a failing import leaves the db untouched
+	broken := redacted
+	broken.SwitchHistory = append(slices.Clone(broken.SwitchHistory), SwitchRecord{ID: "bad"})
+	broken.Domains = []DomainRow{{Domain: "new.com"}}
+	if err := ImportFile(Options{Path: path}, broken, true); err == nil {
+		t.Error("Expected an invalid switch record ID to fail the import")
+	}
+
+	target, err := NewStorage(Options{Path: path})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	defer target.Close()
+	got, _ := Export(target, false)
+	if len(got.SwitchHistory) != 1 || got.SwitchHistory[0].ID != dump.SwitchHistory[0].ID {
+		t.Errorf("Expected the switch record imported once with its ID, got %+v", got.SwitchHistory)
+	}
+	if len(got.Domains) != 1 || got.Domains[0].CfApiToken.Reveal() != testToken {
+		t.Errorf("Expected only a.com with its token, got %+v", got.Domains)
+	}
+	if _, err := os.Stat(path + ".import"); !os.IsNotExist(err) {
+		t.Errorf("Expected the import copy removed, got %v", err)
+	}
+}
+
+func TestExportImport_Tokens(t *testing.T) {
+	source := NewMemoryStorage()
+	_ = source.SaveDomains([]DomainRow{{Domain: "a.com", CfApiToken: "old-token-00001"}})
+	rows, _ := source.GetAllDomains()
+	id := rows[0].TokenRef
+	_ = source.SetTokenLabel(id, "main")
+	_ = source.RotateToken(id, "new-token-00002")
+	verifiedAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	_ = source.SetTokenStatus(id, TokenActive, verifiedAt)
+
+	dump, _ := Export(source, false)
+	if len(dump.Tokens) != 1 {
+		t.Fatalf("Expected the token exported, got %+v", dump.Tokens)
+	}
+	if redacted, _ := Export(source, true); len(redacted.Tokens) != 0 {
+		t.Errorf("Expected no tokens in a redacted dump, got %+v", redacted.Tokens)
+	}
+
+	boltTarget, err := NewStorage(Options{Path: filepath.Join(t.TempDir(), "target.boltdb")})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	defer boltTarget.Close()
+
+	for name, target := range map[string]Storage{"memory": NewMemoryStorage(), "bolt": boltTarget} {
+		if err := Import(target, dump); err != nil {
+			t.Fatalf("%s: failed to import: %v", name, err)
+		}
+		tokens, _ := target.GetTokens()
+		if len(tokens) != 1 || tokens[0].ID != id || tokens[0].Label != "main" || tokens[0].Status != TokenActive ||
+			!tokens[0].LastVerifiedAt.Equal(verifiedAt) || len(tokens[0].Aliases) != 1 {
+			t.Errorf("%s: expected the token with its label, status and alias, got %+v", name, tokens)
+		}
+
+		// the rotated value still resolves to the current one
+		_, _ = target.SyncDomains([]DomainRow{{Domain: "a.com", CfApiToken: "old-token-00001"}}, nil)
+		if rows, _ := target.GetAllDomains(); rows[0].CfApiToken.Reveal() != "new-token-00002" || rows[0].TokenRef != id {
+			t.Errorf("%s: expected the alias to resolve to the rotated token, got %+v", name, rows)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// heartbeatsBucket holds the last heartbeat per proxy host
+var heartbeatsBucket = []byte("heartbeats")
+
+// Heartbeat is the last state pushed by the agent on a proxy
+type Heartbeat struct {
+	Host          string    `json:"host"` // address of the proxy in the pool
+	SentAt        time.Time `json:"sent_at"`
+	ReceivedAt    time.Time `json:"received_at"`
+	Service       string    `json:"service,omitempty"` // nginx or haproxy, empty when not watched
+	ServiceUp     bool      `json:"service_up"`
+	ServiceError  string    `json:"service_error,omitempty"`
+	Connections   int       `json:"connections"`
+	ConfigVersion string    `json:"config_version,omitempty"` // domain config version the proxy applied
+	DiskFree      uint64    `json:"disk_free"`                // bytes
+	DiskTotal     uint64    `json:"disk_total"`
+	Load          []float64 `json:"load,omitempty"`   // 1, 5 and 15 minute load averages
+	Errors        []string  `json:"errors,omitempty"` // metrics the agent failed to collect
+}
+
+func (s *DbStorage) SaveHeartbeat(hb Heartbeat) error {
+	val, err := json.Marshal(hb)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(heartbeatsBucket).Put([]byte(hb.Host), val)
+	})
+}
+
+// GetHeartbeats returns the last heartbeat of every proxy sorted by host
+func (s *DbStorage) GetHeartbeats() ([]Heartbeat, error) {
+	heartbeats := []Heartbeat{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(heartbeatsBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var hb Heartbeat
+			if err := json.Unmarshal(v, &hb); err != nil {
+				return err
+			}
+			heartbeats = append(heartbeats, hb)
+			return nil
+		})
+	})
+	return heartbeats, err
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var switchHistoryBucket = []byte("switch_history")
+
+const (
+	TriggerAutomatic = "automatic" // failover after failed health checks
+	TriggerManual    = "manual"    // started by an operator
+
+	OutcomeSwitched = "switched"
+	OutcomeSkipped  = "skipped" // domain did not point to the failed server
+	OutcomeFailed   = "failed"
+)
+
+// DomainSwitchOutcome is the result of switching a single domain
+type DomainSwitchOutcome struct {
+	Domain   string        `json:"domain"`
+	OldIP    string        `json:"old_ip"`
+	NewIP    string        `json:"new_ip"`
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// SwitchRecord is a switch operation kept in the history ledger
+type SwitchRecord struct {
+	ID           string                `json:"id"`
+	Trigger      string                `json:"trigger"`
+	FailedServer string                `json:"failed_server"`
+	TargetServer string                `json:"target_server"`
+	Reason       string                `json:"reason,omitempty"` // why the target was chosen
+	StartedAt    time.Time             `json:"started_at"`
+	FinishedAt   time.Time             `json:"finished_at"`
+	Domains      []DomainSwitchOutcome `json:"domains"`
+	Error        string                `json:"error,omitempty"`
+}
+
+// Count returns the number of domains with the given status
+func (r SwitchRecord) Count(status string) int {
+	count := 0
+	for _, d := range r.Domains {
+		if d.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// historyKey orders records by start time, the sequence keeps keys unique
+func historyKey(startedAt time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(startedAt.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+func parseHistoryID(id string) ([]byte, error) {
+	key, err := hex.DecodeString(id)
+	if err != nil || len(key) != 16 {
+		return nil, fmt.Errorf("db: invalid switch record ID %q", id)
+	}
+	return key, nil
+}
+
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+// SaveSwitchRecord appends the record to the ledger and sets its ID. A record that
+// already has an ID, like one from a dump, is stored under it and replaces the old copy.
+func (s *DbStorage) SaveSwitchRecord(record *SwitchRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(switchHistoryBucket)
+
+		var key []byte
+		if record.ID != "" {
+			var err error
+			if key, err = parseHistoryID(record.ID); err != nil {
+				return err
+			}
+			// keep new keys after the imported ones
+			if seq := binary.BigEndian.Uint64(key[8:]); seq > b.Sequence() {
+				if err := b.SetSequence(seq); err != nil {
+					return err
+				}
+			}
+		} else {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			key = historyKey(record.StartedAt, seq)
+			record.ID = hex.EncodeToString(key)
+		}
+
+		val, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, val)
+	})
+}
+
+// GetSwitchHistory returns records started within [from, to], oldest first
+func (s *DbStorage) GetSwitchHistory(from, to time.Time) ([]SwitchRecord, error) {
+	records := []SwitchRecord{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(switchHistoryBucket)
+		if b == nil {
+			return nil
+		}
+
+		end := timeKey(to)
+		c := b.Cursor()
+		for k, v := c.Seek(timeKey(from)); k != nil && bytes.Compare(k[:8], end) <= 0; k, v = c.Next() {
+			var r SwitchRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			records = append(records, r)
+		}
+		return nil
+	})
+	return records, err
+}
+
+// GetDomainSwitchHistory returns records within [from, to] that touched the domain,
+// each record only keeps the outcome of that domain
+func (s *DbStorage) GetDomainSwitchHistory(domain string, from, to time.Time) ([]SwitchRecord, error) {
+	records, err := s.GetSwitchHistory(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return filterDomainHistory(records, domain), nil
+}
+
+func filterDomainHistory(records []SwitchRecord, domain string) []SwitchRecord {
+	filtered := []SwitchRecord{}
+	for _, r := range records {
+		for _, d := range r.Domains {
+			if d.Domain == domain {
+				r.Domains = []DomainSwitchOutcome{d}
+				filtered = append(filtered, r)
+				break
+			}
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDbStorage_SwitchHistory(t *testing.T) {
+	storage, _ := newTestStorage(t)
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	records := []SwitchRecord{
+		{Trigger: TriggerAutomatic, StartedAt: base, Domains: []DomainSwitchOutcome{{Domain: "a.com", Status: OutcomeSwitched}}},
+		{Trigger: TriggerManual, StartedAt: base, Domains: []DomainSwitchOutcome{{Domain: "b.com", Status: OutcomeFailed}}},
+		{Trigger: TriggerAutomatic, StartedAt: base.Add(time.Hour), Domains: []DomainSwitchOutcome{
+			{Domain: "a.com", Status: OutcomeSkipped},
+			{Domain: "b.com", Status: OutcomeSwitched},
+		}},
+	}
+	for i := range records {
+		if err := storage.SaveSwitchRecord(&records[i]); err != nil {
+			t.Fatalf("Failed to save record: %v", err)
+		}
+		if records[i].ID == "" {
+			t.Error("Expected record ID to be set")
+		}
+	}
+
+	all, err := storage.GetSwitchHistory(base, base.Add(time.Hour))
+	if err != nil || len(all) != 3 {
+		t.Fatalf("Expected 3 records, got %+v, %v", all, err)
+	}
+	if all[1].Trigger != TriggerManual {
+		t.Errorf("Expected records with the same start time kept in save order, got %+v", all)
+	}
+
+	first, _ := storage.GetSwitchHistory(base, base.Add(time.Minute))
+	if len(first) != 2 {
+		t.Errorf("Expected 2 records in the first minute, got %d", len(first))
+	}
+
+	domain, err := storage.GetDomainSwitchHistory("a.com", base, base.Add(time.Hour))
+	if err != nil || len(domain) != 2 {
+		t.Fatalf("Expected 2 records for a.com, got %+v, %v", domain, err)
+	}
+	if len(domain[1].Domains) != 1 || domain[1].Domains[0].Status != OutcomeSkipped {
+		t.Errorf("Expected only a.com outcome, got %+v", domain[1].Domains)
+	}
+}
@@ -0,0 +1,285 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Index buckets map "<value>\x00<domain>" keys to the domain name, so domains with
+// the same value are adjacent and found with a prefix scan
+var (
+	domainsByHostingBucket = []byte("idx_domains_by_hosting")
+	domainsByProxyBucket   = []byte("idx_domains_by_proxy")
+)
+
+func indexPrefix(value string) []byte {
+	return []byte(value + "\x00")
+}
+
+func indexKey(value, domain string) []byte {
+	return append(indexPrefix(value), domain...)
+}
+
+// hostingIPs returns the distinct backend IPs of the row
+func hostingIPs(d DomainRow) []string {
+	ips := []string{}
+	seen := map[string]bool{}
+	for _, b := range d.Backends() {
+		if b.IP == "" || seen[b.IP] {
+			continue
+		}
+		seen[b.IP] = true
+		ips = append(ips, b.IP)
+	}
+	return ips
+}
+
+// storedDomain parses a row as stored, the token stays encrypted
+func storedDomain(b *bolt.Bucket, key []byte) (*DomainRow, error) {
+	v := b.Get(key)
+	if v == nil {
+		return nil, nil
+	}
+	var d DomainRow
+	if err := json.Unmarshal(v, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func indexHostings(idx *bolt.Bucket, d DomainRow) error {
+	for _, ip := range hostingIPs(d) {
+		if err := idx.Put(indexKey(ip, d.Domain), []byte(d.Domain)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unindexHostings(idx *bolt.Bucket, d DomainRow) error {
+	for _, ip := range hostingIPs(d) {
+		if err := idx.Delete(indexKey(ip, d.Domain)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putDomain saves the row and keeps the hosting index in step
+func (s *DbStorage) putDomain(tx *bolt.Tx, d DomainRow) error {
+	b := tx.Bucket(domainsBucket)
+	idx := tx.Bucket(domainsByHostingBucket)
+
+	old, err := storedDomain(b, d.Key())
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		if err := unindexHostings(idx, *old); err != nil {
+			return err
+		}
+	}
+
+	val, err := s.encodeDomain(tx, d)
+	if err != nil {
+		return err
+	}
+	if err := b.Put(d.Key(), val); err != nil {
+		return err
+	}
+	return indexHostings(idx, d)
+}
+
+// deleteDomain removes the row with its DNS state and index entries
+func deleteDomain(tx *bolt.Tx, domain string) error {
+	b := tx.Bucket(domainsBucket)
+	key := []byte(domain)
+
+	old, err := storedDomain(b, key)
+	if err != nil || old == nil {
+		return err
+	}
+	if err := unindexHostings(tx.Bucket(domainsByHostingBucket), *old); err != nil {
+		return err
+	}
+	if err := b.Delete(key); err != nil {
+		return err
+	}
+
+	states := tx.Bucket(dnsStateBucket)
+	if v := states.Get(key); v != nil {
+		var state DnsState
+		if err := json.Unmarshal(v, &state); err != nil {
+			return err
+		}
+		if state.ProxyHost != "" {
+			if err := tx.Bucket(domainsByProxyBucket).Delete(indexKey(state.ProxyHost, domain)); err != nil {
+				return err
+			}
+		}
+	}
+	return states.Delete(key)
+}
+
+// putDnsState saves the state unless a newer one is stored and keeps the proxy index in step
+func putDnsState(tx *bolt.Tx, state DnsState) error {
+	b := tx.Bucket(dnsStateBucket)
+	idx := tx.Bucket(domainsByProxyBucket)
+
+	if v := b.Get([]byte(state.Domain)); v != nil {
+		var stored DnsState
+		if err := json.Unmarshal(v, &stored); err != nil {
+			return err
+		}
+		if !state.newer(stored) {
+			return nil
+		}
+		if stored.ProxyHost != "" {
+			if err := idx.Delete(indexKey(stored.ProxyHost, state.Domain)); err != nil {
+				return err
+			}
+		}
+	}
+
+	val, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := b.Put([]byte(state.Domain), val); err != nil {
+		return err
+	}
+	if state.ProxyHost == "" {
+		return nil
+	}
+	return idx.Put(indexKey(state.ProxyHost, state.Domain), []byte(state.Domain))
+}
+
+// domainsByIndex returns the domains stored under the value of the index
+func (s *DbStorage) domainsByIndex(index []byte, value string) ([]DomainRow, error) {
+	domains := []DomainRow{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		idx := tx.Bucket(index)
+		b := tx.Bucket(domainsBucket)
+		if idx == nil || b == nil {
+			return nil
+		}
+
+		prefix := indexPrefix(value)
+		c := idx.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			raw := b.Get(v)
+			if raw == nil {
+				continue // observed DNS state of a domain that is not stored
+			}
+			d, err := s.decodeDomain(tx, raw)
+			if err != nil {
+				return err
+			}
+			domains = append(domains, d)
+		}
+		return nil
+	})
+	return domains, err
+}
+
+// GetDomainsByHostingIP returns domains with a backend on the IP, sorted by domain
+func (s *DbStorage) GetDomainsByHostingIP(ip string) ([]DomainRow, error) {
+	return s.domainsByIndex(domainsByHostingBucket, ip)
+}
+
+// GetDomainsByProxy returns domains last observed on the proxy host, sorted by domain
+func (s *DbStorage) GetDomainsByProxy(host string) ([]DomainRow, error) {
+	return s.domainsByIndex(domainsByProxyBucket, host)
+}
+
+// GetDomainsNotObservedSince returns domains without a DNS state observed at or after since,
+// sorted by domain. Only the rows of those domains are decoded.
+func (s *DbStorage) GetDomainsNotObservedSince(since time.Time) ([]DomainRow, error) {
+	domains := []DomainRow{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(domainsBucket)
+		states := tx.Bucket(dnsStateBucket)
+		if b == nil || states == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			if raw := states.Get(k); raw != nil {
+				var state DnsState
+				if err := json.Unmarshal(raw, &state); err != nil {
+					return err
+				}
+				if !state.ObservedAt.Before(since) {
+					return nil
+				}
+			}
+			d, err := s.decodeDomain(tx, v)
+			if err != nil {
+				return err
+			}
+			domains = append(domains, d)
+			return nil
+		})
+	})
+	return domains, err
+}
+
+// ForEachDomain calls fn for every domain in order without loading the whole set.
+// It runs in a read transaction, fn must not write to the storage.
+func (s *DbStorage) ForEachDomain(fn func(DomainRow) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(domainsBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			d, err := s.decodeDomain(tx, v)
+			if err != nil {
+				return err
+			}
+			return fn(d)
+		})
+	})
+}
+
+// migrateDomainIndexes builds the index buckets for files saved before they existed
+func (s *DbStorage) migrateDomainIndexes(tx *bolt.Tx) error {
+	byHosting, err := tx.CreateBucketIfNotExists(domainsByHostingBucket)
+	if err != nil {
+		return err
+	}
+	byProxy, err := tx.CreateBucketIfNotExists(domainsByProxyBucket)
+	if err != nil {
+		return err
+	}
+
+	if b := tx.Bucket(domainsBucket); b != nil {
+		err := b.ForEach(func(k, v []byte) error {
+			var d DomainRow
+			if err := json.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			return indexHostings(byHosting, d)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if b := tx.Bucket(dnsStateBucket); b != nil {
+		return b.ForEach(func(k, v []byte) error {
+			var state DnsState
+			if err := json.Unmarshal(v, &state); err != nil {
+				return err
+			}
+			if state.ProxyHost == "" {
+				return nil
+			}
+			return byProxy.Put(indexKey(state.ProxyHost, state.Domain), []byte(state.Domain))
+		})
+	}
+	return nil
+}
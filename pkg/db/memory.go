@@ -0,0 +1,650 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps everything in memory, it behaves like DbStorage and is meant
+// for tests, simulations and dry runs. The zero value is ready to use.
+type MemoryStorage struct {
+	mu sync.RWMutex
+
+	servers map[string]ProxyServerRow
+	domains map[string]DomainRow
+	history []SwitchRecord
+	seq     uint64
+	checks  map[string]map[int64]ServerCheck
+	dns     map[string]DnsState
+	held    string // fingerprint of the held sync
+
+	tokens       map[string]TokenRecord
+	fingerprints map[string]string // fingerprint of current and rotated values -> token ID
+
+	pool       map[string]PoolProxy
+	certs      map[string]CertCheck // key: certKey
+	heartbeats map[string]Heartbeat // key: proxy host
+}
+
+var _ Storage = (*MemoryStorage)(nil)
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+// ensure creates the maps of a zero value, it must be called with the write lock held
+func (m *MemoryStorage) ensure() {
+	if m.servers == nil {
+		m.servers = map[string]ProxyServerRow{}
+	}
+	if m.domains == nil {
+		m.domains = map[string]DomainRow{}
+	}
+	if m.checks == nil {
+		m.checks = map[string]map[int64]ServerCheck{}
+	}
+	if m.dns == nil {
+		m.dns = map[string]DnsState{}
+	}
+	if m.tokens == nil {
+		m.tokens = map[string]TokenRecord{}
+	}
+	if m.fingerprints == nil {
+		m.fingerprints = map[string]string{}
+	}
+	if m.pool == nil {
+		m.pool = map[string]PoolProxy{}
+	}
+	if m.certs == nil {
+		m.certs = map[string]CertCheck{}
+	}
+	if m.heartbeats == nil {
+		m.heartbeats = map[string]Heartbeat{}
+	}
+}
+
+// storeDomain moves the token of the row to the token store, like DbStorage.encodeDomain
+func (m *MemoryStorage) storeDomain(d DomainRow) DomainRow {
+	d.TokenRef = ""
+	if d.CfApiToken != "" {
+		fingerprint := TokenFingerprint(d.CfApiToken)
+		id, ok := m.fingerprints[fingerprint]
+		if !ok {
+			id = tokenIDPrefix + fingerprint[:tokenIDLength]
+			for n := tokenIDLength + 4; m.tokens[id].ID != ""; n += 4 {
+				id = tokenIDPrefix + fingerprint[:min(n, len(fingerprint))]
+			}
+			m.tokens[id] = TokenRecord{
+				ID:          id,
+				Fingerprint: fingerprint,
+				Value:       d.CfApiToken,
+				Account:     d.AccountRecordID,
+				Status:      TokenUnverified,
+				CreatedAt:   time.Now(),
+			}
+			m.fingerprints[fingerprint] = id
+		}
+		d.TokenRef = id
+	}
+	d.CfApiToken = ""
+	return cloneDomain(d)
+}
+
+func (m *MemoryStorage) Close() {}
+
+func (m *MemoryStorage) SaveProxyServers(servers []ProxyServerRow) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ensure()
+
+	for _, s := range servers {
+		m.servers[s.Host] = s
+	}
+	return nil
+}
+
+func (m *MemoryStorage) GetProxyServers(isUp bool) ([]ProxyServerRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var servers []ProxyServerRow
+	for _, host := range sortedKeys(m.servers) {
+		if s := m.servers[host]; s.IsUp || !isUp {
+			servers = append(servers, s)
+		}
+	}
+	return servers, nil
+}
+
+func (m *MemoryStorage) SaveDomains(domains []DomainRow) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ensure()
+
+	for _, d := range domains {
+		m.domains[d.Domain] = m.storeDomain(d)
+	}
+	return nil
+}
+
+func (m *MemoryStorage) GetAllDomains() ([]DomainRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.allDomains(), nil
+}
+
+func (m *MemoryStorage) GetDomainsWithoutTokens() ([]DomainRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var domains []DomainRow
+	for _, name := range sortedKeys(m.domains) {
+		d := cloneDomain(m.domains[name])
+		d.CfApiToken = ""
+		domains = append(domains, d)
+	}
+	return domains, nil
+}
+
+func (m *MemoryStorage) allDomains() []DomainRow {
+	var domains []DomainRow
+	for _, name := range sortedKeys(m.domains) {
+		d := cloneDomain(m.domains[name])
+		if d.TokenRef != "" {
+			d.CfApiToken = m.tokens[d.TokenRef].Value
+		}
+		domains = append(domains, d)
+	}
+	return domains
+}
+
+func (m *MemoryStorage) GetDomainWithCfTokens() ([]DomainRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var domains []DomainRow
+	for _, d := range m.allDomains() {
+		if d.CfApiToken != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains, nil
+}
+
+// SyncDomains applies the diff like DbStorage.SyncDomains, all or nothing
+func (m *MemoryStorage) SyncDomains(domains []DomainRow, check SyncCheck) (DomainsDiff, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ensure()
+
+	incoming := make([]DomainRow, len(domains))
+	for i, d := range domains {
+		incoming[i] = d
+		if id, ok := m.fingerprints[TokenFingerprint(d.CfApiToken)]; ok && d.CfApiToken != "" {
+			incoming[i].CfApiToken = m.tokens[id].Value
+		}
+	}
+
+	diff := DiffDomains(m.allDomains(), incoming)
+	if check != nil {
+		if err := check(diff); err != nil {
+			return diff, err
+		}
+	}
+
+	deletes, upserts := diff.writes()
+	for _, d := range deletes {
+		delete(m.domains, d.Domain)
+		delete(m.dns, d.Domain)
+	}
+	for _, d := range upserts {
+		m.domains[d.Domain] = m.storeDomain(d)
+	}
+	m.deleteUnusedTokens()
+	return diff, nil
+}
+
+// deleteUnusedTokens is DbStorage's deleteUnusedTokens, it must be called with the write lock held
+func (m *MemoryStorage) deleteUnusedTokens() {
+	used := map[string]bool{}
+	for _, d := range m.domains {
+		used[d.TokenRef] = true
+	}
+	maps.DeleteFunc(m.tokens, func(id string, _ TokenRecord) bool { return !used[id] })
+	maps.DeleteFunc(m.fingerprints, func(_ string, id string) bool { return !used[id] })
+}
+
+func (m *MemoryStorage) SaveHeldSync(fingerprint string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.held = fingerprint
+	return nil
+}
+
+func (m *MemoryStorage) GetHeldSync() (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.held, nil
+}
+
+func (m *MemoryStorage) SaveSwitchRecord(record *SwitchRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if record.ID == "" {
+		m.seq++
+		record.ID = hex.EncodeToString(historyKey(record.StartedAt, m.seq))
+	} else {
+		key, err := parseHistoryID(record.ID)
+		if err != nil {
+			return err
+		}
+		m.seq = max(m.seq, binary.BigEndian.Uint64(key[8:]))
+	}
+
+	saved := *record
+	saved.Domains = slices.Clone(record.Domains)
+	m.history = slices.DeleteFunc(m.history, func(r SwitchRecord) bool { return r.ID == record.ID })
+	m.history = append(m.history, saved)
+	sort.SliceStable(m.history, func(i, j int) bool {
+		return m.history[i].StartedAt.UnixNano() < m.history[j].StartedAt.UnixNano()
+	})
+	return nil
+}
+
+func (m *MemoryStorage) GetSwitchHistory(from, to time.Time) ([]SwitchRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	records := []SwitchRecord{}
+	for _, r := range m.history {
+		started := r.StartedAt.UnixNano()
+		if started >= from.UnixNano() && started <= to.UnixNano() {
+			r.Domains = slices.Clone(r.Domains)
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+func (m *MemoryStorage) GetDomainSwitchHistory(domain string, from, to time.Time) ([]SwitchRecord, error) {
+	records, err := m.GetSwitchHistory(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return filterDomainHistory(records, domain), nil
+}
+
+func (m *MemoryStorage) AppendServerChecks(checks []ServerCheck) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ensure()
+
+	for _, c := range checks {
+		if m.checks[c.Host] == nil {
+			m.checks[c.Host] = map[int64]ServerCheck{}
+		}
+		m.checks[c.Host][c.At.UnixNano()] = c.normalize()
+	}
+	return nil
+}
+
+func (m *MemoryStorage) GetServerChecks(host string, from, to time.Time) ([]ServerCheck, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	checks := []ServerCheck{}
+	for _, at := range sortedKeys(m.checks[host]) {
+		if at >= from.UnixNano() && at <= to.UnixNano() {
+			checks = append(checks, m.checks[host][at])
+		}
+	}
+	return checks, nil
+}
+
+func (m *MemoryStorage) GetCheckedHosts() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return sortedKeys(m.checks), nil
+}
+
+func (m *MemoryStorage) CompactServerChecks(policy RetentionPolicy, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := 0
+	for host, series := range m.checks {
+		checks := make([]ServerCheck, 0, len(series))
+		for _, at := range sortedKeys(series) {
+			checks = append(checks, series[at])
+		}
+
+		kept := compactChecks(checks, policy, now)
+		if len(kept) == len(checks) {
+			continue
+		}
+		removed += len(checks) - len(kept)
+
+		series = make(map[int64]ServerCheck, len(kept))
+		for _, c := range kept {
+			series[c.At.UnixNano()] = c
+		}
+		m.checks[host] = series
+	}
+	return removed, nil
+}
+
+func (m *MemoryStorage) SaveDnsStates(states []DnsState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ensure()
+
+	for _, state := range states {
+		if stored, ok := m.dns[state.Domain]; ok && !state.newer(stored) {
+			continue
+		}
+		m.dns[state.Domain] = state
+	}
+	return nil
+}
+
+func (m *MemoryStorage) GetDnsStates() ([]DnsState, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	states := []DnsState{}
+	for _, domain := range sortedKeys(m.dns) {
+		states = append(states, m.dns[domain])
+	}
+	return states, nil
+}
+
+func (m *MemoryStorage) GetDomainsByHostingIP(ip string) ([]DomainRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	domains := []DomainRow{}
+	for _, d := range m.allDomains() {
+		if slices.Contains(hostingIPs(d), ip) {
+			domains = append(domains, d)
+		}
+	}
+	return domains, nil
+}
+
+func (m *MemoryStorage) GetDomainsByProxy(host string) ([]DomainRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	domains := []DomainRow{}
+	for _, d := range m.allDomains() {
+		if state, ok := m.dns[d.Domain]; ok && state.ProxyHost == host {
+			domains = append(domains, d)
+		}
+	}
+	return domains, nil
+}
+
+func (m *MemoryStorage) GetDomainsNotObservedSince(since time.Time) ([]DomainRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	domains := []DomainRow{}
+	for _, d := range m.allDomains() {
+		if state, ok := m.dns[d.Domain]; !ok || state.ObservedAt.Before(since) {
+			domains = append(domains, d)
+		}
+	}
+	return domains, nil
+}
+
+// ForEachDomain calls fn for every domain in order, fn runs on a snapshot
+func (m *MemoryStorage) ForEachDomain(fn func(DomainRow) error) error {
+	m.mu.RLock()
+	domains := m.allDomains()
+	m.mu.RUnlock()
+
+	for _, d := range domains {
+		if err := fn(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStorage) GetTokens() ([]TokenRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	aliases := map[string][]string{}
+	for _, fingerprint := range sortedKeys(m.fingerprints) {
+		id := m.fingerprints[fingerprint]
+		if fingerprint != m.tokens[id].Fingerprint {
+			aliases[id] = append(aliases[id], fingerprint)
+		}
+	}
+
+	tokens := []TokenRecord{}
+	for _, id := range sortedKeys(m.tokens) {
+		record := m.tokens[id]
+		record.Aliases = aliases[id]
+		tokens = append(tokens, record)
+	}
+	return tokens, nil
+}
+
+func (m *MemoryStorage) SaveTokens(tokens []TokenRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ensure()
+
+	for _, t := range tokens {
+		if err := validToken(t); err != nil {
+			return err
+		}
+		if other, ok := m.fingerprints[t.Fingerprint]; ok && other != t.ID {
+			continue
+		}
+		if stored, ok := m.tokens[t.ID]; ok && !t.sameToken(stored.Fingerprint) {
+			continue
+		}
+
+		for _, fingerprint := range append([]string{t.Fingerprint}, t.Aliases...) {
+			if other, ok := m.fingerprints[fingerprint]; !ok || other == t.ID {
+				m.fingerprints[fingerprint] = t.ID
+			}
+		}
+		t.Aliases = nil
+		m.tokens[t.ID] = t
+	}
+	return nil
+}
+
+func (m *MemoryStorage) updateToken(id string, fn func(record *TokenRecord) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.tokens[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTokenNotFound, id)
+	}
+	if err := fn(&record); err != nil {
+		return err
+	}
+	m.tokens[id] = record
+	return nil
+}
+
+func (m *MemoryStorage) SetTokenLabel(id, label string) error {
+	return m.updateToken(id, func(record *TokenRecord) error {
+		record.Label = label
+		return nil
+	})
+}
+
+func (m *MemoryStorage) SetTokenStatus(id, status string, verifiedAt time.Time) error {
+	return m.updateToken(id, func(record *TokenRecord) error {
+		record.Status = status
+		record.LastVerifiedAt = verifiedAt
+		return nil
+	})
+}
+
+func (m *MemoryStorage) RotateToken(id string, value Token) error {
+	if value == "" {
+		return errors.New("db: empty token value")
+	}
+
+	return m.updateToken(id, func(record *TokenRecord) error {
+		fingerprint := TokenFingerprint(value)
+		if other, ok := m.fingerprints[fingerprint]; ok && other != id {
+			return fmt.Errorf("db: value is already stored as token %s", other)
+		}
+
+		record.Value = value
+		record.Fingerprint = fingerprint
+		record.Status = TokenUnverified
+		record.LastVerifiedAt = time.Time{}
+		record.RotatedAt = time.Now()
+		m.fingerprints[fingerprint] = id
+		return nil
+	})
+}
+
+// GetServerUptime computes uptime statistics of the host within [from, to]
+func (m *MemoryStorage) GetServerUptime(host string, from, to time.Time) (UptimeStats, error) {
+	checks, err := m.GetServerChecks(host, from, to)
+	if err != nil {
+		return UptimeStats{}, err
+	}
+	return ComputeUptime(host, checks), nil
+}
+
+// cloneDomain copies the row so callers can't change stored backends
+func cloneDomain(d DomainRow) DomainRow {
+	d.Hostings = slices.Clone(d.Hostings)
+	return d
+}
+
+func sortedKeys[K string | int64, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (m *MemoryStorage) GetProxyPool() ([]PoolProxy, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	proxies := []PoolProxy{}
+	for _, name := range sortedKeys(m.pool) {
+		proxies = append(proxies, m.pool[name])
+	}
+	return proxies, nil
+}
+
+func (m *MemoryStorage) SavePoolProxy(p PoolProxy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ensure()
+
+	m.pool[p.Proxy.Name] = p
+	return nil
+}
+
+func (m *MemoryStorage) DeletePoolProxy(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.pool[name]
+	if !ok {
+		return nil
+	}
+	delete(m.servers, p.Proxy.Address)
+	delete(m.checks, p.Proxy.Address)
+	m.deleteCertChecks(p.Proxy.Address, nil)
+	delete(m.heartbeats, p.Proxy.Address)
+	delete(m.pool, name)
+	return nil
+}
+
+func (m *MemoryStorage) DeleteProxyServer(host string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.servers, host)
+	return nil
+}
+
+func (m *MemoryStorage) SaveCertChecks(checks []CertCheck) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ensure()
+
+	for _, c := range checks {
+		m.certs[string(certKey(c.Host, c.Domain))] = c
+	}
+	return nil
+}
+
+func (m *MemoryStorage) GetCertChecks() ([]CertCheck, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	checks := []CertCheck{}
+	for _, k := range sortedKeys(m.certs) {
+		checks = append(checks, m.certs[k])
+	}
+	return checks, nil
+}
+
+func (m *MemoryStorage) PruneCertChecks(host string, domains []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteCertChecks(host, domains)
+	return nil
+}
+
+// deleteCertChecks removes checks of the host except for the kept domains, it must be called with the write lock held
+func (m *MemoryStorage) deleteCertChecks(host string, keep []string) {
+	for k, c := range m.certs {
+		if c.Host == host && !slices.Contains(keep, c.Domain) {
+			delete(m.certs, k)
+		}
+	}
+}
+
+func (m *MemoryStorage) SaveHeartbeat(hb Heartbeat) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ensure()
+
+	m.heartbeats[hb.Host] = hb
+	return nil
+}
+
+func (m *MemoryStorage) GetHeartbeats() ([]Heartbeat, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	heartbeats := []Heartbeat{}
+	for _, k := range sortedKeys(m.heartbeats) {
+		heartbeats = append(heartbeats, m.heartbeats[k])
+	}
+	return heartbeats, nil
+}
@@ -0,0 +1,202 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	metaBucket       = []byte("meta")
+	schemaVersionKey = []byte("schema_version")
+)
+
+// Migration upgrades the bolt file to Version, it runs in its own transaction
+type Migration struct {
+	Version int
+	Name    string
+	Apply   func(s *DbStorage, tx *bolt.Tx) error
+}
+
+// migrations are applied in order, append new ones with the next version
+var migrations = []Migration{
+	{Version: 1, Name: "fill hostings of rows saved with a single hosting_ip", Apply: (*DbStorage).migrateLegacyHostings},
+	{Version: 2, Name: "index domains by hosting IP and proxy", Apply: (*DbStorage).migrateDomainIndexes},
+	{Version: 3, Name: "move domain tokens to the token store", Apply: (*DbStorage).migrateTokenStore},
+}
+
+// SchemaVersion is the version of the newest known migration
+func SchemaVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+func readSchemaVersion(tx *bolt.Tx) (int, error) {
+	b := tx.Bucket(metaBucket)
+	if b == nil {
+		return 0, nil
+	}
+	v := b.Get(schemaVersionKey)
+	if v == nil {
+		return 0, nil
+	}
+	return strconv.Atoi(string(v))
+}
+
+func writeSchemaVersion(tx *bolt.Tx, version int) error {
+	b, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	return b.Put(schemaVersionKey, []byte(strconv.Itoa(version)))
+}
+
+// isEmpty reports a file without any stored rows, it needs no migration
+func isEmpty(tx *bolt.Tx) bool {
+	empty := true
+	_ = tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		if string(name) == string(metaBucket) {
+			return nil
+		}
+		if k, _ := b.Cursor().First(); k != nil {
+			empty = false
+		}
+		return nil
+	})
+	return empty
+}
+
+// SchemaVersion returns the version the bolt file is at
+func (s *DbStorage) SchemaVersion() (int, error) {
+	var version int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		version, err = readSchemaVersion(tx)
+		return err
+	})
+	return version, err
+}
+
+// PendingMigrations returns migrations not yet applied to the bolt file
+func (s *DbStorage) PendingMigrations() ([]Migration, error) {
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if version > SchemaVersion() {
+		return nil, fmt.Errorf("db: schema version %d is newer than supported %d", version, SchemaVersion())
+	}
+
+	pending := []Migration{}
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// AppliedMigrations returns migrations applied when the storage was opened
+func (s *DbStorage) AppliedMigrations() []Migration {
+	return s.applied
+}
+
+// migrate applies pending migrations, taking a backup copy of the file first
+func (s *DbStorage) migrate() error {
+	pending, err := s.PendingMigrations()
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+
+	empty := false
+	err = s.db.View(func(tx *bolt.Tx) error {
+		empty = isEmpty(tx)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if empty {
+		return s.db.Update(func(tx *bolt.Tx) error {
+			return writeSchemaVersion(tx, SchemaVersion())
+		})
+	}
+
+	backup, err := s.backup()
+	if err != nil {
+		return fmt.Errorf("db: backup before migration failed: %w", err)
+	}
+	log.Printf("db: backup saved to %s before migrating\n", backup)
+
+	for _, m := range pending {
+		log.Printf("db: applying migration %d %s\n", m.Version, m.Name)
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			if err := m.Apply(s, tx); err != nil {
+				return err
+			}
+			return writeSchemaVersion(tx, m.Version)
+		})
+		if err != nil {
+			return fmt.Errorf("db: migration %d %s failed, backup in %s: %w", m.Version, m.Name, backup, err)
+		}
+		s.applied = append(s.applied, m)
+	}
+
+	return nil
+}
+
+// backup copies the bolt file next to it, the copy is consistent with a read transaction
+func (s *DbStorage) backup() (string, error) {
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return "", err
+	}
+
+	path := fmt.Sprintf("%s.v%d-%s.bak", s.opts.Path, version, time.Now().Format("20060102150405"))
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0o600)
+	})
+	return path, err
+}
+
+func (s *DbStorage) migrateLegacyHostings(tx *bolt.Tx) error {
+	b := tx.Bucket(domainsBucket)
+	if b == nil {
+		return nil
+	}
+
+	updates := map[string][]byte{}
+	err := b.ForEach(func(k, v []byte) error {
+		var d DomainRow
+		if err := json.Unmarshal(v, &d); err != nil {
+			return err
+		}
+		if len(d.Hostings) > 0 || d.HostingIP == "" {
+			return nil
+		}
+		d.Hostings = []HostingBackend{{IP: d.HostingIP}}
+		val, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		updates[string(k)] = val
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for k, v := range updates {
+		if err := b.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// writeRaw prepares a bolt file the way an older version of the app left it
+func writeRaw(t *testing.T, path string, fn func(tx *bolt.Tx) error) {
+	t.Helper()
+
+	raw, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		t.Fatalf("Failed to open raw bolt: %v", err)
+	}
+	defer raw.Close()
+
+	if err := raw.Update(fn); err != nil {
+		t.Fatalf("Failed to write raw bolt: %v", err)
+	}
+}
+
+func TestNewStorage_MigratesLegacyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.boltdb")
+	writeRaw(t, path, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(domainsBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("legacy.com"), []byte(`{"domain":"legacy.com","hosting_ip":"1.1.1.1"}`))
+	})
+
+	storage, err := NewStorage(Options{Path: path})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	defer storage.Close()
+
+	if len(storage.AppliedMigrations()) != len(migrations) {
+		t.Errorf("Expected all migrations applied, got %+v", storage.AppliedMigrations())
+	}
+	if version, _ := storage.SchemaVersion(); version != SchemaVersion() {
+		t.Errorf("Expected schema version %d, got %d", SchemaVersion(), version)
+	}
+
+	backups, _ := filepath.Glob(path + ".v0-*.bak")
+	if len(backups) != 1 {
+		t.Errorf("Expected a backup copy, got %v", backups)
+	}
+
+	rows, err := storage.GetAllDomains()
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("Expected 1 domain, got %+v, %v", rows, err)
+	}
+	if len(rows[0].Hostings) != 1 || rows[0].Hostings[0].IP != "1.1.1.1" {
+		t.Errorf("Expected hostings filled from hosting_ip, got %+v", rows[0])
+	}
+
+	if indexed, _ := storage.GetDomainsByHostingIP("1.1.1.1"); len(indexed) != 1 {
+		t.Errorf("Expected legacy row in the hosting index, got %+v", indexed)
+	}
+}
+
+func TestNewStorage_MigratesInlineTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.boltdb")
+	writeRaw(t, path, func(tx *bolt.Tx) error {
+		if err := writeSchemaVersion(tx, 2); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucketIfNotExists(domainsBucket)
+		if err != nil {
+			return err
+		}
+		_ = b.Put([]byte("a.com"), []byte(`{"domain":"a.com","cf_api_token":"inline-token-0001"}`))
+		return b.Put([]byte("b.com"), []byte(`{"domain":"b.com","cf_api_token":"inline-token-0001"}`))
+	})
+
+	storage, err := NewStorage(Options{Path: path})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	defer storage.Close()
+
+	tokens, err := storage.GetTokens()
+	if err != nil || len(tokens) != 1 {
+		t.Fatalf("Expected one token in the store, got %+v, %v", tokens, err)
+	}
+
+	rows, _ := storage.GetAllDomains()
+	for _, row := range rows {
+		if row.TokenRef != tokens[0].ID || row.CfApiToken.Reveal() != "inline-token-0001" {
+			t.Errorf("Expected %s to reference the stored token, got %+v", row.Domain, row)
+		}
+	}
+}
+
+func TestNewStorage_FreshFileSkipsMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fresh.boltdb")
+
+	storage, err := NewStorage(Options{Path: path})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	defer storage.Close()
+
+	if len(storage.AppliedMigrations()) != 0 {
+		t.Errorf("Expected no migrations on an empty file, got %+v", storage.AppliedMigrations())
+	}
+	if pending, _ := storage.PendingMigrations(); len(pending) != 0 {
+		t.Errorf("Expected no pending migrations, got %+v", pending)
+	}
+}
+
+func TestNewStorage_NewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "newer.boltdb")
+	writeRaw(t, path, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put(schemaVersionKey, []byte(strconv.Itoa(SchemaVersion()+1)))
+	})
+
+	if _, err := NewStorage(Options{Path: path}); err == nil {
+		t.Error("Expected error opening a file with a newer schema")
+	}
+}
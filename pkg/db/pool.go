@@ -0,0 +1,111 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// proxyPoolBucket holds the proxies managed at runtime, keyed by name
+var proxyPoolBucket = []byte("proxy_pool")
+
+const (
+	PoolSourceConfig = "config" // from [[Servers.Proxy]], replaced on reload
+	PoolSourceApi    = "api"    // added at runtime, kept on reload
+)
+
+// ProxyRow is the stored definition of a proxy. It has the fields of config.Proxy in the
+// same order, so the two convert into each other, and the JSON names of the stored rows.
+type ProxyRow struct {
+	Name      string   `json:"name"`
+	PublicIPs []string `json:"public_ips"`
+	Address   string   `json:"address"`
+	Port      int      `json:"port"`
+	Scheme    string   `json:"scheme"`
+	CheckType string   `json:"check_type"`
+
+	Weight     int      `json:"weight"`
+	Priority   int      `json:"priority"`
+	Region     string   `json:"region,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	MaxDomains int      `json:"max_domains,omitempty"`
+	NoFailover bool     `json:"no_failover,omitempty"`
+}
+
+// PoolProxy is a proxy of the runtime pool
+type PoolProxy struct {
+	Proxy     ProxyRow  `json:"proxy"`
+	Enabled   bool      `json:"enabled"`
+	Source    string    `json:"source"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (s *DbStorage) GetProxyPool() ([]PoolProxy, error) {
+	proxies := []PoolProxy{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(proxyPoolBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var p PoolProxy
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			proxies = append(proxies, p)
+			return nil
+		})
+	})
+	return proxies, err
+}
+
+func (s *DbStorage) SavePoolProxy(p PoolProxy) error {
+	val, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(proxyPoolBucket).Put([]byte(p.Proxy.Name), val)
+	})
+}
+
+// DeletePoolProxy removes the proxy with its server row, check history and certificate checks
+func (s *DbStorage) DeletePoolProxy(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(proxyPoolBucket)
+		v := b.Get([]byte(name))
+		if v == nil {
+			return nil
+		}
+		var p PoolProxy
+		if err := json.Unmarshal(v, &p); err != nil {
+			return err
+		}
+
+		address := []byte(p.Proxy.Address)
+		if err := tx.Bucket(serverBucket).Delete(address); err != nil {
+			return err
+		}
+		checks := tx.Bucket(serverChecksBucket)
+		if checks.Bucket(address) != nil {
+			if err := checks.DeleteBucket(address); err != nil {
+				return err
+			}
+		}
+		if err := deleteCertChecks(tx, p.Proxy.Address, nil); err != nil {
+			return err
+		}
+		if err := tx.Bucket(heartbeatsBucket).Delete(address); err != nil {
+			return err
+		}
+		return b.Delete([]byte(name))
+	})
+}
+
+// DeleteProxyServer removes the server row, so a disabled proxy is no switch target
+func (s *DbStorage) DeleteProxyServer(host string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(serverBucket).Delete([]byte(host))
+	})
+}
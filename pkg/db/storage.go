@@ -2,12 +2,20 @@ package db
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
 	"time"
 
 	"github.com/boltdb/bolt"
 )
 
-const storage = "changer.boltdb"
+const (
+	defaultPath        = "changer.boltdb"
+	defaultOpenTimeout = time.Second * 5
+)
 
 var (
 	serverBucket  = []byte("servers")
@@ -19,12 +27,64 @@ type Storage interface {
 	GetProxyServers(onlyHealthy bool) ([]ProxyServerRow, error)
 	GetDomainWithCfTokens() ([]DomainRow, error)
 	SaveDomains([]DomainRow) error
+	SyncDomains([]DomainRow, SyncCheck) (DomainsDiff, error)
+	SaveHeldSync(fingerprint string) error
+	GetHeldSync() (string, error)
 	GetAllDomains() ([]DomainRow, error)
+	// GetDomainsWithoutTokens returns every domain sorted by domain with an empty token,
+	// tokens are not resolved or decrypted for readers that only need names and backends
+	GetDomainsWithoutTokens() ([]DomainRow, error)
+	SaveSwitchRecord(*SwitchRecord) error
+	GetSwitchHistory(from, to time.Time) ([]SwitchRecord, error)
+	GetDomainSwitchHistory(domain string, from, to time.Time) ([]SwitchRecord, error)
+	AppendServerChecks([]ServerCheck) error
+	GetServerChecks(host string, from, to time.Time) ([]ServerCheck, error)
+	GetCheckedHosts() ([]string, error)
+	CompactServerChecks(policy RetentionPolicy, now time.Time) (int, error)
+	SaveDnsStates([]DnsState) error
+	GetDnsStates() ([]DnsState, error)
+	GetDomainsByHostingIP(ip string) ([]DomainRow, error)
+	GetDomainsByProxy(host string) ([]DomainRow, error)
+	GetDomainsNotObservedSince(since time.Time) ([]DomainRow, error)
+	// ForEachDomain calls fn for every domain sorted by domain and stops at the first error.
+	// fn must not write to the storage, DbStorage holds a read transaction while it runs.
+	ForEachDomain(fn func(DomainRow) error) error
+	GetTokens() ([]TokenRecord, error)
+	SaveTokens([]TokenRecord) error
+	SetTokenLabel(id, label string) error
+	SetTokenStatus(id, status string, verifiedAt time.Time) error
+	RotateToken(id string, value Token) error
+	GetProxyPool() ([]PoolProxy, error)
+	SavePoolProxy(PoolProxy) error
+	DeletePoolProxy(name string) error
+	DeleteProxyServer(host string) error
+	SaveCertChecks([]CertCheck) error
+	GetCertChecks() ([]CertCheck, error)
+	PruneCertChecks(host string, domains []string) error
+	SaveHeartbeat(Heartbeat) error
+	GetHeartbeats() ([]Heartbeat, error)
 	Close()
 }
 
+// Options configures how the bolt file is opened
+type Options struct {
+	Path        string        // bolt file, defaults to changer.boltdb in the working directory
+	OpenTimeout time.Duration // wait for the file lock, defaults to 5s, negative waits forever
+	ReadOnly    bool          // shared lock for inspection, writes fail, it waits for a writer to close
+	NoSync      bool          // skip fsync after each commit, faster but unsafe on power loss
+	NoGrowSync  bool          // skip fsync when the file grows
+
+	// Token keys in "id:base64key" format, read from the file or else from the env variable.
+	// Without keys tokens are stored in plain text.
+	TokenKeysFile string
+	TokenKeysEnv  string
+}
+
 type DbStorage struct {
-	db *bolt.DB
+	db      *bolt.DB
+	opts    Options
+	applied []Migration
+	keyring *Keyring
 
 	Storage
 }
@@ -33,17 +93,75 @@ func (s *DbStorage) Close() {
 	defer s.db.Close()
 }
 
-func NewStorage() (*DbStorage, error) {
-	db, err := bolt.Open(storage, 0o600, nil)
+func NewStorage(opts Options) (*DbStorage, error) {
+	if opts.Path == "" {
+		opts.Path = defaultPath
+	}
+
+	timeout := opts.OpenTimeout
+	switch {
+	case timeout == 0:
+		timeout = defaultOpenTimeout
+	case timeout < 0:
+		timeout = 0
+	}
+
+	keyring, err := LoadKeyring(opts.TokenKeysFile, opts.TokenKeysEnv)
+	if err != nil {
+		return nil, fmt.Errorf("db: failed to load token keys: %w", err)
+	}
+	if keyring == nil {
+		log.Println("db: no token key configured, Cloudflare tokens are stored in plain text")
+	}
+
+	if opts.ReadOnly {
+		if _, err := os.Stat(opts.Path); err != nil {
+			return nil, fmt.Errorf("db: can't open %s read only: %w", opts.Path, err)
+		}
+	}
+
+	db, err := bolt.Open(opts.Path, 0o600, &bolt.Options{
+		Timeout:    timeout,
+		ReadOnly:   opts.ReadOnly,
+		NoGrowSync: opts.NoGrowSync,
+	})
+	if errors.Is(err, bolt.ErrTimeout) && opts.ReadOnly {
+		// the shared lock waits for the exclusive lock of a writer, inspecting needs the app stopped
+		return nil, fmt.Errorf("db: %s is locked by a writer, stop the app to read it: %w", opts.Path, err)
+	}
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("db: %s is locked, is another instance running? %w", opts.Path, err)
+	}
 	if err != nil {
 		return nil, err
 	}
+	db.NoSync = opts.NoSync
 
 	storage := &DbStorage{
-		db: db,
+		db:      db,
+		opts:    opts,
+		keyring: keyring,
+	}
+
+	if opts.ReadOnly {
+		pending, err := storage.PendingMigrations()
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		if len(pending) > 0 {
+			log.Printf("db: %d migrations pending, opened read only without migrating\n", len(pending))
+		}
+		return storage, nil
+	}
+
+	if err := storage.initDbBuckets(); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	if storage.initDbBuckets() != nil {
+	if err := storage.migrate(); err != nil {
+		db.Close()
 		return nil, err
 	}
 
@@ -58,36 +176,100 @@ func (s *DbStorage) initDbBuckets() error {
 		if _, err := tx.CreateBucketIfNotExists(domainsBucket); err != nil {
 			return err
 		}
+		if _, err := tx.CreateBucketIfNotExists(metaBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(switchHistoryBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(serverChecksBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(dnsStateBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(domainsByHostingBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(domainsByProxyBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(tokensBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(tokenFingerprintsBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(proxyPoolBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(heartbeatsBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(certChecksBucket); err != nil {
+			return err
+		}
 
 		return nil
 	})
 }
 
+// HostingBackend is an origin a domain is served from, Weight 0 means not set
+type HostingBackend struct {
+	IP       string `json:"ip"`
+	Weight   int    `json:"weight,omitempty"`
+	RecordID string `json:"record_id,omitempty"` // Airtable hosting record
+}
+
 type DomainRow struct {
 	Domain     string `json:"domain"`
-	HostingIP  string `json:"hosting_ip"`
-	CfApiToken string `json:"cf_api_token,omitempty"`
+	HostingIP  string `json:"hosting_ip"`             // primary backend, same as the first of Hostings
+	CfApiToken Token  `json:"cf_api_token,omitempty"` // resolved from the token store, inline only in rows saved before it
+	TokenRef   string `json:"token_ref,omitempty"`    // ID of the token in the token store
+
+	Hostings []HostingBackend `json:"hostings,omitempty"` // ordered, primary first
+
+	// Provenance of the row in Airtable
+	DomainRecordID   string    `json:"domain_record_id,omitempty"`
+	AccountRecordID  string    `json:"account_record_id,omitempty"`
+	SourceModifiedAt time.Time `json:"source_modified_at"`
 }
 
-func (d DomainRow) Key() []byte {
-	return []byte(d.Domain)
+// Backends returns the hosting backends of the row, rows saved before
+// multiple backends were supported only have HostingIP
+func (d DomainRow) Backends() []HostingBackend {
+	if len(d.Hostings) == 0 && d.HostingIP != "" {
+		return []HostingBackend{{IP: d.HostingIP}}
+	}
+	return d.Hostings
 }
 
-func (d DomainRow) Value() ([]byte, error) {
-	return json.Marshal(d)
+// HostingRecordID returns the Airtable record of the primary backend
+func (d DomainRow) HostingRecordID() string {
+	if len(d.Hostings) == 0 {
+		return ""
+	}
+	return d.Hostings[0].RecordID
+}
+
+func (d DomainRow) Equal(o DomainRow) bool {
+	return d.Domain == o.Domain &&
+		d.HostingIP == o.HostingIP &&
+		d.CfApiToken == o.CfApiToken &&
+		slices.Equal(d.Backends(), o.Backends()) &&
+		d.DomainRecordID == o.DomainRecordID &&
+		d.AccountRecordID == o.AccountRecordID &&
+		d.SourceModifiedAt.Equal(o.SourceModifiedAt)
+}
+
+func (d DomainRow) Key() []byte {
+	return []byte(d.Domain)
 }
 
 func (s *DbStorage) SaveDomains(domains []DomainRow) error {
 	return s.db.Batch(func(tx *bolt.Tx) error {
-		b := tx.Bucket(domainsBucket)
-
 		for _, d := range domains {
-			key := d.Key()
-			val, err := d.Value()
-			if err != nil {
-				return err
-			}
-			if err := b.Put(key, val); err != nil {
+			if err := s.putDomain(tx, d); err != nil {
 				return err
 			}
 		}
@@ -98,20 +280,9 @@ func (s *DbStorage) SaveDomains(domains []DomainRow) error {
 
 func (s *DbStorage) GetAllDomains() ([]DomainRow, error) {
 	var domains []DomainRow
-	err := s.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(domainsBucket)
-		if b == nil {
-			return nil
-		}
-
-		return b.ForEach(func(k, v []byte) error {
-			var d DomainRow
-			if err := json.Unmarshal(v, &d); err != nil {
-				return err
-			}
-			domains = append(domains, d)
-			return nil
-		})
+	err := s.ForEachDomain(func(d DomainRow) error {
+		domains = append(domains, d)
+		return nil
 	})
 	if err != nil {
 		return nil, err
@@ -120,8 +291,8 @@ func (s *DbStorage) GetAllDomains() ([]DomainRow, error) {
 	return domains, nil
 }
 
-func (s *DbStorage) GetDomainWithCfTokens() ([]DomainRow, error) {
-	var domainsWithTokens []DomainRow
+func (s *DbStorage) GetDomainsWithoutTokens() ([]DomainRow, error) {
+	var domains []DomainRow
 	err := s.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket(domainsBucket)
 		if b == nil {
@@ -132,12 +303,22 @@ func (s *DbStorage) GetDomainWithCfTokens() ([]DomainRow, error) {
 			if err := json.Unmarshal(v, &d); err != nil {
 				return err
 			}
-			if d.CfApiToken != "" {
-				domainsWithTokens = append(domainsWithTokens, d)
-			}
+			d.CfApiToken = ""
+			domains = append(domains, d)
 			return nil
 		})
 	})
+	return domains, err
+}
+
+func (s *DbStorage) GetDomainWithCfTokens() ([]DomainRow, error) {
+	var domainsWithTokens []DomainRow
+	err := s.ForEachDomain(func(d DomainRow) error {
+		if d.CfApiToken != "" {
+			domainsWithTokens = append(domainsWithTokens, d)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
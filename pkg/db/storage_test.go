@@ -0,0 +1,94 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func newTestStorage(t *testing.T) (*DbStorage, Options) {
+	t.Helper()
+
+	opts := Options{Path: filepath.Join(t.TempDir(), "test.boltdb"), OpenTimeout: time.Millisecond * 100}
+	storage, err := NewStorage(opts)
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	t.Cleanup(storage.Close)
+
+	return storage, opts
+}
+
+func TestDbStorage_SyncDomains(t *testing.T) {
+	storage, _ := newTestStorage(t)
+
+	err := storage.SaveDomains([]DomainRow{{Domain: "keep.com"}, {Domain: "gone.com"}})
+	if err != nil {
+		t.Fatalf("Failed to save domains: %v", err)
+	}
+
+	diff, err := storage.SyncDomains([]DomainRow{{Domain: "keep.com"}, {Domain: "new.com"}}, nil)
+	if err != nil {
+		t.Fatalf("Failed to sync domains: %v", err)
+	}
+	if len(diff.Added) != 1 || len(diff.Removed) != 1 || diff.Previous != 2 {
+		t.Errorf("Unexpected diff %+v", diff)
+	}
+
+	rows, err := storage.GetAllDomains()
+	if err != nil {
+		t.Fatalf("Failed to get domains: %v", err)
+	}
+	if len(rows) != 2 || rows[0].Domain != "keep.com" || rows[1].Domain != "new.com" {
+		t.Errorf("Expected keep.com and new.com, got %+v", rows)
+	}
+}
+
+func TestNewStorage_LockTimeout(t *testing.T) {
+	_, opts := newTestStorage(t)
+
+	start := time.Now()
+	if _, err := NewStorage(opts); err == nil {
+		t.Fatal("Expected second instance to fail on the file lock")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Expected to fail fast, took %s", time.Since(start))
+	}
+}
+
+func TestNewStorage_ReadOnly(t *testing.T) {
+	storage, opts := newTestStorage(t)
+	if err := storage.SaveDomains([]DomainRow{{Domain: "a.com"}}); err != nil {
+		t.Fatalf("Failed to save domains: %v", err)
+	}
+	storage.Close()
+
+	opts.ReadOnly = true
+	readOnly, err := NewStorage(opts)
+	if err != nil {
+		t.Fatalf("Failed to open read only: %v", err)
+	}
+	defer readOnly.Close()
+
+	rows, err := readOnly.GetAllDomains()
+	if err != nil || len(rows) != 1 {
+		t.Errorf("Expected to read 1 domain, got %+v, %v", rows, err)
+	}
+	if err := readOnly.SaveDomains([]DomainRow{{Domain: "b.com"}}); err == nil {
+		t.Error("Expected write to fail in read only mode")
+	}
+}
+
+func TestNewStorage_ReadOnlyWhileWriterOpen(t *testing.T) {
+	_, opts := newTestStorage(t)
+	opts.ReadOnly = true
+
+	_, err := NewStorage(opts)
+	if !errors.Is(err, bolt.ErrTimeout) || !strings.Contains(err.Error(), "stop the app") {
+		t.Errorf("Expected a read only open to time out while the writer holds the db, got %v", err)
+	}
+}
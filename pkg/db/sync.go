@@ -0,0 +1,243 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+// heldSyncKey in the meta bucket holds the fingerprint of the last held sync
+var heldSyncKey = []byte("held_sync")
+
+// DomainChange holds the stored and the incoming version of a domain row
+type DomainChange struct {
+	Old DomainRow `json:"old"`
+	New DomainRow `json:"new"`
+}
+
+// HostingChanged reports a change of backend IPs or weights, record IDs are ignored
+func (c DomainChange) HostingChanged() bool {
+	if c.Old.HostingIP != c.New.HostingIP {
+		return true
+	}
+	return !slices.EqualFunc(c.Old.Backends(), c.New.Backends(), func(a, b HostingBackend) bool {
+		return a.IP == b.IP && a.Weight == b.Weight
+	})
+}
+
+func (c DomainChange) TokenChanged() bool {
+	return c.Old.CfApiToken != c.New.CfApiToken
+}
+
+// DomainsDiff is the result of reconciling stored domains with the source
+type DomainsDiff struct {
+	Added   []DomainRow    `json:"added"`
+	Removed []DomainRow    `json:"removed"`
+	Changed []DomainChange `json:"changed"` // includes renamed rows with a hosting or token change
+	Renamed []DomainChange `json:"renamed"` // same Airtable record under a new domain name
+
+	// Refreshed rows only differ in provenance, they are saved but not reported
+	Refreshed []DomainRow `json:"-"`
+
+	Previous int `json:"previous"` // number of stored domains before the sync
+}
+
+func (d DomainsDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 && len(d.Renamed) == 0
+}
+
+// Fingerprint identifies the changes of the diff including token values,
+// so a held sync can only be forced as it was reviewed
+func (d DomainsDiff) Fingerprint() string {
+	h := sha256.New()
+	row := func(op string, r DomainRow) {
+		fmt.Fprintf(h, "%s %s %v %s\n", op, r.Domain, r.Backends(), TokenFingerprint(r.CfApiToken))
+	}
+	for _, r := range d.Added {
+		row("+", r)
+	}
+	for _, r := range d.Removed {
+		row("-", r)
+	}
+	for _, c := range d.Changed {
+		row("~", c.Old)
+		row("~>", c.New)
+	}
+	for _, c := range d.Renamed {
+		row("=", c.Old)
+		row("=>", c.New)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writes returns the rows to delete and the rows to save to apply the diff
+func (d DomainsDiff) writes() (deletes, upserts []DomainRow) {
+	deletes = append([]DomainRow{}, d.Removed...)
+	for _, c := range d.Renamed {
+		deletes = append(deletes, c.Old)
+	}
+
+	upserts = append([]DomainRow{}, d.Added...)
+	upserts = append(upserts, d.Refreshed...)
+	for _, c := range d.Changed {
+		upserts = append(upserts, c.New)
+	}
+	for _, c := range d.Renamed {
+		upserts = append(upserts, c.New)
+	}
+	return deletes, upserts
+}
+
+// DiffDomains compares stored rows with the incoming ones.
+// Incoming rows with the same domain are collapsed, the last one wins.
+func DiffDomains(current, incoming []DomainRow) DomainsDiff {
+	currentMap := make(map[string]DomainRow, len(current))
+	for _, d := range current {
+		currentMap[d.Domain] = d
+	}
+
+	incomingMap := make(map[string]DomainRow, len(incoming))
+	for _, d := range incoming {
+		incomingMap[d.Domain] = d
+	}
+
+	diff := DomainsDiff{Previous: len(currentMap)}
+
+	for domain, n := range incomingMap {
+		o, ok := currentMap[domain]
+		if !ok {
+			diff.Added = append(diff.Added, n)
+			continue
+		}
+		if o.Equal(n) {
+			continue
+		}
+		change := DomainChange{Old: o, New: n}
+		if change.HostingChanged() || change.TokenChanged() {
+			diff.Changed = append(diff.Changed, change)
+		} else {
+			diff.Refreshed = append(diff.Refreshed, n)
+		}
+	}
+
+	removedByRecord := map[string]DomainRow{}
+	for domain, o := range currentMap {
+		if _, ok := incomingMap[domain]; ok {
+			continue
+		}
+		if o.DomainRecordID != "" {
+			removedByRecord[o.DomainRecordID] = o
+		} else {
+			diff.Removed = append(diff.Removed, o)
+		}
+	}
+
+	added := diff.Added[:0]
+	for _, n := range diff.Added {
+		if o, ok := removedByRecord[n.DomainRecordID]; ok && n.DomainRecordID != "" {
+			change := DomainChange{Old: o, New: n}
+			diff.Renamed = append(diff.Renamed, change)
+			if change.HostingChanged() || change.TokenChanged() {
+				diff.Changed = append(diff.Changed, change)
+			}
+			delete(removedByRecord, n.DomainRecordID)
+			continue
+		}
+		added = append(added, n)
+	}
+	diff.Added = added
+
+	for _, o := range removedByRecord {
+		diff.Removed = append(diff.Removed, o)
+	}
+
+	sort.Slice(diff.Added, func(i, j int) bool { return diff.Added[i].Domain < diff.Added[j].Domain })
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].Domain < diff.Removed[j].Domain })
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].New.Domain < diff.Changed[j].New.Domain })
+	sort.Slice(diff.Renamed, func(i, j int) bool { return diff.Renamed[i].New.Domain < diff.Renamed[j].New.Domain })
+
+	return diff
+}
+
+// SyncCheck inspects a pending diff, a non nil error aborts the sync
+type SyncCheck func(diff DomainsDiff) error
+
+// SyncDomains makes the domains bucket mirror the given rows in one transaction:
+// new rows are inserted, changed rows updated and missing rows deleted with their DNS state.
+// Tokens no domain references any more are deleted.
+// If check rejects the diff nothing is written and the diff is returned with the error.
+func (s *DbStorage) SyncDomains(domains []DomainRow, check SyncCheck) (DomainsDiff, error) {
+	var diff DomainsDiff
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(domainsBucket)
+
+		current := []DomainRow{}
+		err := b.ForEach(func(k, v []byte) error {
+			d, err := s.decodeDomain(tx, v)
+			if err != nil {
+				return err
+			}
+			current = append(current, d)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		incoming, err := s.currentTokens(tx, domains)
+		if err != nil {
+			return err
+		}
+		diff = DiffDomains(current, incoming)
+
+		if check != nil {
+			if err := check(diff); err != nil {
+				return err
+			}
+		}
+
+		deletes, upserts := diff.writes()
+		for _, d := range deletes {
+			if err := deleteDomain(tx, d.Domain); err != nil {
+				return err
+			}
+		}
+
+		for _, d := range upserts {
+			if err := s.putDomain(tx, d); err != nil {
+				return err
+			}
+		}
+
+		return deleteUnusedTokens(tx)
+	})
+	return diff, err
+}
+
+// SaveHeldSync records the fingerprint of a held sync, an empty one clears it
+func (s *DbStorage) SaveHeldSync(fingerprint string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(metaBucket)
+		if fingerprint == "" {
+			return b.Delete(heldSyncKey)
+		}
+		return b.Put(heldSyncKey, []byte(fingerprint))
+	})
+}
+
+// GetHeldSync returns the fingerprint of the held sync, or an empty string
+func (s *DbStorage) GetHeldSync() (string, error) {
+	var fingerprint string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(metaBucket); b != nil {
+			fingerprint = string(b.Get(heldSyncKey))
+		}
+		return nil
+	})
+	return fingerprint, err
+}
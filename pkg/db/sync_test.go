@@ -0,0 +1,105 @@
+package db
+
+import "testing"
+
+func TestDiffDomains(t *testing.T) {
+	current := []DomainRow{
+		{Domain: "same.com", HostingIP: "1.1.1.1", CfApiToken: "t1"},
+		{Domain: "moved.com", HostingIP: "1.1.1.1", CfApiToken: "t1"},
+		{Domain: "rotated.com", HostingIP: "1.1.1.1", CfApiToken: "t1"},
+		{Domain: "gone.com", HostingIP: "1.1.1.1", CfApiToken: "t1"},
+	}
+	incoming := []DomainRow{
+		{Domain: "same.com", HostingIP: "1.1.1.1", CfApiToken: "t1"},
+		{Domain: "moved.com", HostingIP: "2.2.2.2", CfApiToken: "t1"},
+		{Domain: "rotated.com", HostingIP: "1.1.1.1", CfApiToken: "t2"},
+		{Domain: "new.com", HostingIP: "3.3.3.3", CfApiToken: "t3"},
+	}
+
+	diff := DiffDomains(current, incoming)
+
+	if len(diff.Added) != 1 || diff.Added[0].Domain != "new.com" {
+		t.Errorf("Expected new.com to be added, got %+v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].Domain != "gone.com" {
+		t.Errorf("Expected gone.com to be removed, got %+v", diff.Removed)
+	}
+	if len(diff.Changed) != 2 {
+		t.Fatalf("Expected 2 changed domains, got %+v", diff.Changed)
+	}
+
+	moved, rotated := diff.Changed[0], diff.Changed[1]
+	if moved.New.Domain != "moved.com" || !moved.HostingChanged() || moved.TokenChanged() {
+		t.Errorf("Expected moved.com to change hosting only, got %+v", moved)
+	}
+	if rotated.New.Domain != "rotated.com" || rotated.HostingChanged() || !rotated.TokenChanged() {
+		t.Errorf("Expected rotated.com to change token only, got %+v", rotated)
+	}
+}
+
+func TestDiffDomains_Empty(t *testing.T) {
+	rows := []DomainRow{{Domain: "same.com", HostingIP: "1.1.1.1"}}
+
+	if diff := DiffDomains(rows, rows); !diff.IsEmpty() {
+		t.Errorf("Expected empty diff, got %+v", diff)
+	}
+}
+
+func TestDiffDomains_Backends(t *testing.T) {
+	current := []DomainRow{
+		{Domain: "legacy.com", HostingIP: "1.1.1.1"},
+		{Domain: "backup.com", HostingIP: "1.1.1.1", Hostings: []HostingBackend{{IP: "1.1.1.1"}}},
+	}
+	incoming := []DomainRow{
+		{Domain: "legacy.com", HostingIP: "1.1.1.1", Hostings: []HostingBackend{{IP: "1.1.1.1"}}},
+		{Domain: "backup.com", HostingIP: "1.1.1.1", Hostings: []HostingBackend{{IP: "1.1.1.1"}, {IP: "2.2.2.2", Weight: 1}}},
+	}
+
+	diff := DiffDomains(current, incoming)
+
+	if len(diff.Changed) != 1 || diff.Changed[0].New.Domain != "backup.com" || !diff.Changed[0].HostingChanged() {
+		t.Errorf("Expected only backup.com hosting change, got %+v", diff.Changed)
+	}
+}
+
+func TestDiffDomains_RenamedAndRefreshed(t *testing.T) {
+	current := []DomainRow{
+		{Domain: "old-name.com", HostingIP: "1.1.1.1", DomainRecordID: "rec1"},
+		{Domain: "touched.com", HostingIP: "1.1.1.1", DomainRecordID: "rec2"},
+	}
+	incoming := []DomainRow{
+		{Domain: "new-name.com", HostingIP: "1.1.1.1", DomainRecordID: "rec1"},
+		{Domain: "touched.com", HostingIP: "1.1.1.1", DomainRecordID: "rec2", AccountRecordID: "acc1"},
+	}
+
+	diff := DiffDomains(current, incoming)
+
+	if len(diff.Added) != 0 || len(diff.Removed) != 0 || len(diff.Changed) != 0 {
+		t.Errorf("Expected no added, removed or changed domains, got %+v", diff)
+	}
+	if len(diff.Renamed) != 1 || diff.Renamed[0].Old.Domain != "old-name.com" || diff.Renamed[0].New.Domain != "new-name.com" {
+		t.Errorf("Expected old-name.com renamed to new-name.com, got %+v", diff.Renamed)
+	}
+	if len(diff.Refreshed) != 1 || diff.Refreshed[0].AccountRecordID != "acc1" {
+		t.Errorf("Expected touched.com to be refreshed, got %+v", diff.Refreshed)
+	}
+}
+
+func TestDiffDomains_RenamedWithChanges(t *testing.T) {
+	current := []DomainRow{{Domain: "old-name.com", HostingIP: "1.1.1.1", CfApiToken: "token", DomainRecordID: "rec1"}}
+	incoming := []DomainRow{{Domain: "new-name.com", HostingIP: "2.2.2.2", DomainRecordID: "rec1"}}
+
+	diff := DiffDomains(current, incoming)
+
+	if len(diff.Renamed) != 1 || len(diff.Added) != 0 || len(diff.Removed) != 0 {
+		t.Errorf("Expected old-name.com renamed to new-name.com, got %+v", diff)
+	}
+	if len(diff.Changed) != 1 || !diff.Changed[0].HostingChanged() || !diff.Changed[0].TokenChanged() {
+		t.Errorf("Expected the rename to be a hosting and token change as well, got %+v", diff.Changed)
+	}
+
+	deletes, upserts := diff.writes()
+	if len(deletes) != 1 || deletes[0].Domain != "old-name.com" || upserts[0].Domain != "new-name.com" {
+		t.Errorf("Expected old-name.com replaced by new-name.com, got %+v, %+v", deletes, upserts)
+	}
+}
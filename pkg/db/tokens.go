@@ -0,0 +1,380 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// tokensBucket holds one record per distinct Cloudflare token, domains reference
+// it by ID. The fingerprints bucket maps the fingerprint of every value a token
+// had to its ID, so a source still sending a rotated value resolves to the current one.
+var (
+	tokensBucket            = []byte("tokens")
+	tokenFingerprintsBucket = []byte("idx_token_fingerprints")
+)
+
+const (
+	TokenUnverified = "unverified"
+	TokenActive     = "active"
+	TokenInvalid    = "invalid"
+
+	tokenIDPrefix = "tk_"
+	tokenIDLength = 12 // hex characters of the fingerprint used in IDs
+)
+
+var ErrTokenNotFound = errors.New("db: token not found")
+
+// TokenRecord is a Cloudflare token shared by domains
+type TokenRecord struct {
+	ID             string    `json:"id"`
+	Fingerprint    string    `json:"fingerprint"` // of the current value
+	Value          Token     `json:"value"`       // encrypted at rest when a token key is configured
+	Label          string    `json:"label,omitempty"`
+	Account        string    `json:"account,omitempty"` // Airtable account record the token was first seen with
+	Status         string    `json:"status"`
+	LastVerifiedAt time.Time `json:"last_verified_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	RotatedAt      time.Time `json:"rotated_at"`
+	Aliases        []string  `json:"aliases,omitempty"` // fingerprints of rotated values, read from the index
+}
+
+// TokenFingerprint identifies a token value without revealing it
+func TokenFingerprint(t Token) string {
+	sum := sha256.Sum256([]byte(t.Reveal()))
+	return hex.EncodeToString(sum[:])
+}
+
+// newTokenID derives a short ID from the fingerprint, longer if the short one is taken
+func newTokenID(b *bolt.Bucket, fingerprint string) string {
+	for n := tokenIDLength; n < len(fingerprint); n += 4 {
+		if id := tokenIDPrefix + fingerprint[:n]; b.Get([]byte(id)) == nil {
+			return id
+		}
+	}
+	return tokenIDPrefix + fingerprint
+}
+
+func (s *DbStorage) putTokenRecord(tx *bolt.Tx, record TokenRecord) error {
+	record.Aliases = nil
+	var err error
+	if record.Value, err = s.sealToken(record.Value, record.ID); err != nil {
+		return err
+	}
+	val, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(tokensBucket).Put([]byte(record.ID), val)
+}
+
+// getToken reads the record with its value decrypted
+func (s *DbStorage) getToken(tx *bolt.Tx, id string) (TokenRecord, error) {
+	var record TokenRecord
+	v := tx.Bucket(tokensBucket).Get([]byte(id))
+	if v == nil {
+		return record, fmt.Errorf("%w: %s", ErrTokenNotFound, id)
+	}
+	if err := json.Unmarshal(v, &record); err != nil {
+		return record, err
+	}
+
+	var err error
+	record.Value, err = s.openToken(record.Value, record.ID)
+	return record, err
+}
+
+// tokenRef returns the ID of the token, storing it first if it is new
+func (s *DbStorage) tokenRef(tx *bolt.Tx, t Token, account string) (string, error) {
+	if t == "" {
+		return "", nil
+	}
+
+	fingerprint := TokenFingerprint(t)
+	index := tx.Bucket(tokenFingerprintsBucket)
+	if id := index.Get([]byte(fingerprint)); id != nil {
+		return string(id), nil
+	}
+
+	now := time.Now()
+	record := TokenRecord{
+		ID:          newTokenID(tx.Bucket(tokensBucket), fingerprint),
+		Fingerprint: fingerprint,
+		Value:       t,
+		Account:     account,
+		Status:      TokenUnverified,
+		CreatedAt:   now,
+	}
+	if err := s.putTokenRecord(tx, record); err != nil {
+		return "", err
+	}
+	return record.ID, index.Put([]byte(fingerprint), []byte(record.ID))
+}
+
+// currentTokens replaces rotated token values of incoming rows with the current ones
+func (s *DbStorage) currentTokens(tx *bolt.Tx, rows []DomainRow) ([]DomainRow, error) {
+	index := tx.Bucket(tokenFingerprintsBucket)
+	current := make([]DomainRow, len(rows))
+	for i, d := range rows {
+		current[i] = d
+		if d.CfApiToken == "" {
+			continue
+		}
+		fingerprint := TokenFingerprint(d.CfApiToken)
+		id := index.Get([]byte(fingerprint))
+		if id == nil {
+			continue
+		}
+		record, err := s.getToken(tx, string(id))
+		if err != nil {
+			return nil, err
+		}
+		if record.Fingerprint != fingerprint {
+			current[i].CfApiToken = record.Value
+		}
+	}
+	return current, nil
+}
+
+// GetTokens returns all stored tokens with their aliases sorted by ID
+func (s *DbStorage) GetTokens() ([]TokenRecord, error) {
+	tokens := []TokenRecord{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tokensBucket)
+		if b == nil {
+			return nil
+		}
+
+		aliases := map[string][]string{}
+		err := tx.Bucket(tokenFingerprintsBucket).ForEach(func(k, v []byte) error {
+			aliases[string(v)] = append(aliases[string(v)], string(k))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			record, err := s.getToken(tx, string(k))
+			if err != nil {
+				return err
+			}
+			for _, fingerprint := range aliases[record.ID] {
+				if fingerprint != record.Fingerprint {
+					record.Aliases = append(record.Aliases, fingerprint)
+				}
+			}
+			tokens = append(tokens, record)
+			return nil
+		})
+	})
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens, err
+}
+
+// SaveTokens stores the records with their IDs and aliases, like the ones of a dump.
+// A record is skipped when its value is stored as another token, or when its ID is
+// taken by a different token, domains with that value then resolve to the stored one.
+func (s *DbStorage) SaveTokens(tokens []TokenRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tokensBucket)
+		index := tx.Bucket(tokenFingerprintsBucket)
+
+		for _, t := range tokens {
+			if err := validToken(t); err != nil {
+				return err
+			}
+			if other := index.Get([]byte(t.Fingerprint)); other != nil && string(other) != t.ID {
+				continue
+			}
+			if v := b.Get([]byte(t.ID)); v != nil {
+				var stored TokenRecord
+				if err := json.Unmarshal(v, &stored); err != nil {
+					return err
+				}
+				if !t.sameToken(stored.Fingerprint) {
+					continue
+				}
+			}
+
+			if err := s.putTokenRecord(tx, t); err != nil {
+				return err
+			}
+			for _, fingerprint := range append([]string{t.Fingerprint}, t.Aliases...) {
+				if other := index.Get([]byte(fingerprint)); other != nil && string(other) != t.ID {
+					continue
+				}
+				if err := index.Put([]byte(fingerprint), []byte(t.ID)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func validToken(t TokenRecord) error {
+	if t.ID == "" || t.Value == "" || t.Fingerprint != TokenFingerprint(t.Value) {
+		return fmt.Errorf("db: token %q has no value or a wrong fingerprint", t.ID)
+	}
+	return nil
+}
+
+// sameToken reports whether the fingerprint is of the current or an earlier value of the token
+func (t TokenRecord) sameToken(fingerprint string) bool {
+	return fingerprint == t.Fingerprint || slices.Contains(t.Aliases, fingerprint)
+}
+
+// deleteUnusedTokens removes the token records no domain references, with their fingerprints
+func deleteUnusedTokens(tx *bolt.Tx) error {
+	used := map[string]bool{}
+	err := tx.Bucket(domainsBucket).ForEach(func(k, v []byte) error {
+		var d DomainRow
+		if err := json.Unmarshal(v, &d); err != nil {
+			return err
+		}
+		used[d.TokenRef] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = deleteWhere(tx.Bucket(tokensBucket), func(k, v []byte) bool { return !used[string(k)] })
+	if err != nil {
+		return err
+	}
+	return deleteWhere(tx.Bucket(tokenFingerprintsBucket), func(k, v []byte) bool { return !used[string(v)] })
+}
+
+// deleteWhere deletes the keys of the bucket matching fn
+func deleteWhere(b *bolt.Bucket, fn func(k, v []byte) bool) error {
+	keys := [][]byte{}
+	err := b.ForEach(func(k, v []byte) error {
+		if fn(k, v) {
+			keys = append(keys, slices.Clone(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateToken applies fn to the stored record in one transaction
+func (s *DbStorage) updateToken(id string, fn func(tx *bolt.Tx, record *TokenRecord) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		record, err := s.getToken(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := fn(tx, &record); err != nil {
+			return err
+		}
+		return s.putTokenRecord(tx, record)
+	})
+}
+
+func (s *DbStorage) SetTokenLabel(id, label string) error {
+	return s.updateToken(id, func(_ *bolt.Tx, record *TokenRecord) error {
+		record.Label = label
+		return nil
+	})
+}
+
+// SetTokenStatus records the result of verifying the token with Cloudflare
+func (s *DbStorage) SetTokenStatus(id, status string, verifiedAt time.Time) error {
+	return s.updateToken(id, func(_ *bolt.Tx, record *TokenRecord) error {
+		record.Status = status
+		record.LastVerifiedAt = verifiedAt
+		return nil
+	})
+}
+
+// RotateToken replaces the value of the token for every domain referencing it.
+// The old value stays known as an alias so a sync still sending it keeps the new value.
+func (s *DbStorage) RotateToken(id string, value Token) error {
+	if value == "" {
+		return errors.New("db: empty token value")
+	}
+
+	return s.updateToken(id, func(tx *bolt.Tx, record *TokenRecord) error {
+		fingerprint := TokenFingerprint(value)
+		index := tx.Bucket(tokenFingerprintsBucket)
+		if other := index.Get([]byte(fingerprint)); other != nil && string(other) != id {
+			return fmt.Errorf("db: value is already stored as token %s", other)
+		}
+
+		record.Value = value
+		record.Fingerprint = fingerprint
+		record.Status = TokenUnverified
+		record.LastVerifiedAt = time.Time{}
+		record.RotatedAt = time.Now()
+		return index.Put([]byte(fingerprint), []byte(id))
+	})
+}
+
+// migrateTokenStore moves inline domain tokens to the token store
+func (s *DbStorage) migrateTokenStore(tx *bolt.Tx) error {
+	if _, err := tx.CreateBucketIfNotExists(tokensBucket); err != nil {
+		return err
+	}
+	if _, err := tx.CreateBucketIfNotExists(tokenFingerprintsBucket); err != nil {
+		return err
+	}
+
+	b := tx.Bucket(domainsBucket)
+	if b == nil {
+		return nil
+	}
+
+	updates := map[string][]byte{}
+	err := b.ForEach(func(k, v []byte) error {
+		var d DomainRow
+		if err := json.Unmarshal(v, &d); err != nil {
+			return err
+		}
+		if d.CfApiToken == "" {
+			return nil
+		}
+
+		plain, err := s.openToken(d.CfApiToken, d.Domain)
+		if err != nil {
+			return fmt.Errorf("token of %s: %w", d.Domain, err)
+		}
+		if d.TokenRef, err = s.tokenRef(tx, plain, d.AccountRecordID); err != nil {
+			return err
+		}
+		d.CfApiToken = ""
+
+		val, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		updates[string(k)] = val
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for k, v := range updates {
+		if err := b.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
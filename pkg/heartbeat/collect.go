@@ -0,0 +1,164 @@
+package heartbeat
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+)
+
+const loadAvgFile = "/proc/loadavg"
+
+// Collector gathers the local metrics of a proxy for a heartbeat
+type Collector struct {
+	cfg    config.Agent
+	client *http.Client
+}
+
+func NewCollector(cfg config.Agent, timeout time.Duration) *Collector {
+	if cfg.DiskPath == "" {
+		cfg.DiskPath = "/"
+	}
+	return &Collector{cfg: cfg, client: &http.Client{Timeout: timeout}}
+}
+
+// Collect returns the current heartbeat, metrics that cannot be read are listed in Errors
+func (c *Collector) Collect(ctx context.Context) db.Heartbeat {
+	hb := db.Heartbeat{Host: c.cfg.Proxy, SentAt: time.Now(), Service: c.cfg.Service}
+
+	if c.cfg.Service != "" {
+		connections, err := c.serviceStatus(ctx)
+		hb.ServiceUp, hb.Connections = err == nil, connections
+		if err != nil {
+			hb.ServiceError = err.Error()
+		}
+	}
+
+	if c.cfg.ConfigVersionFile != "" {
+		version, err := os.ReadFile(c.cfg.ConfigVersionFile)
+		if err != nil {
+			hb.Errors = append(hb.Errors, fmt.Sprintf("config version: %v", err))
+		}
+		hb.ConfigVersion = strings.TrimSpace(string(version))
+	}
+
+	var err error
+	if hb.DiskFree, hb.DiskTotal, err = diskUsage(c.cfg.DiskPath); err != nil {
+		hb.Errors = append(hb.Errors, fmt.Sprintf("disk: %v", err))
+	}
+
+	if hb.Load, err = readLoadAvg(loadAvgFile); err != nil {
+		hb.Errors = append(hb.Errors, fmt.Sprintf("load: %v", err))
+	}
+	return hb
+}
+
+// serviceStatus reads the status page of the service and returns its active connections
+func (c *Collector) serviceStatus(ctx context.Context) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.cfg.StatusURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%s status: %w", c.cfg.Service, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s status returned %s", c.cfg.Service, resp.Status)
+	}
+	if c.cfg.Service == "haproxy" {
+		return parseHaproxyStats(resp.Body)
+	}
+	return parseNginxStatus(resp.Body)
+}
+
+// parseNginxStatus reads the active connections of the stub_status page
+func parseNginxStatus(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "Active connections:")
+		if ok {
+			return strconv.Atoi(strings.TrimSpace(value))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("nginx status has no active connections")
+}
+
+// parseHaproxyStats sums the current sessions of the frontends in the CSV stats
+func parseHaproxyStats(r io.Reader) (int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return 0, fmt.Errorf("haproxy stats: %w", err)
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "# ")
+	}
+	svname, scur := -1, -1
+	for i, name := range header {
+		switch name {
+		case "svname":
+			svname = i
+		case "scur":
+			scur = i
+		}
+	}
+	if svname < 0 || scur < 0 {
+		return 0, fmt.Errorf("haproxy stats have no svname and scur columns")
+	}
+
+	sessions := 0
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return sessions, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("haproxy stats: %w", err)
+		}
+		if len(row) <= max(svname, scur) || row[svname] != "FRONTEND" {
+			continue
+		}
+		n, err := strconv.Atoi(row[scur])
+		if err != nil {
+			return 0, fmt.Errorf("haproxy stats: scur %q: %w", row[scur], err)
+		}
+		sessions += n
+	}
+}
+
+// readLoadAvg returns the 1, 5 and 15 minute load averages
+func readLoadAvg(path string) ([]float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("unexpected %s: %q", path, data)
+	}
+
+	load := make([]float64, 3)
+	for i := range load {
+		if load[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return nil, err
+		}
+	}
+	return load, nil
+}
@@ -0,0 +1,63 @@
+package heartbeat
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+)
+
+func TestParseNginxStatus(t *testing.T) {
+	page := "Active connections: 291 \nserver accepts handled requests\n 16630948 16630948 31070465 \nReading: 6 Writing: 179 Waiting: 106 \n"
+	if n, err := parseNginxStatus(strings.NewReader(page)); err != nil || n != 291 {
+		t.Errorf("Expected 291 connections, got %d, %v", n, err)
+	}
+	if _, err := parseNginxStatus(strings.NewReader("<html>")); err == nil {
+		t.Error("Expected an error for a page without active connections")
+	}
+}
+
+func TestParseHaproxyStats(t *testing.T) {
+	stats := "# pxname,svname,qcur,qmax,scur,smax\n" +
+		"http-in,FRONTEND,,,12,40\n" +
+		"https-in,FRONTEND,,,30,90\n" +
+		"backend,web1,0,0,7,20\n" +
+		"backend,BACKEND,0,0,7,20\n"
+	if n, err := parseHaproxyStats(strings.NewReader(stats)); err != nil || n != 42 {
+		t.Errorf("Expected 42 frontend sessions, got %d, %v", n, err)
+	}
+}
+
+func TestCollector_Collect(t *testing.T) {
+	status := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("Active connections: 3\n"))
+	}))
+	defer status.Close()
+
+	versionFile := filepath.Join(t.TempDir(), "version")
+	_ = os.WriteFile(versionFile, []byte("3f2a9c1e0b7d\n"), 0o600)
+
+	c := NewCollector(config.Agent{
+		Proxy:             "10.0.0.1",
+		Service:           "nginx",
+		StatusURL:         status.URL,
+		ConfigVersionFile: versionFile,
+		DiskPath:          t.TempDir(),
+	}, time.Second)
+
+	hb := c.Collect(context.Background())
+	if hb.Host != "10.0.0.1" || !hb.ServiceUp || hb.Connections != 3 || hb.ConfigVersion != "3f2a9c1e0b7d" {
+		t.Errorf("Unexpected heartbeat %+v", hb)
+	}
+
+	status.Close()
+	if hb := c.Collect(context.Background()); hb.ServiceUp || hb.ServiceError == "" {
+		t.Errorf("Expected nginx down with the status page gone, got %+v", hb)
+	}
+}
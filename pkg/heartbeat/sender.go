@@ -0,0 +1,59 @@
+package heartbeat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+	"go-cf-zone-switch/pkg/signing"
+)
+
+// Sender pushes the heartbeats of an agent to the main instance, signed like the probe reports
+// with the proxy address as the agent name
+type Sender struct {
+	proxy  string
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewSender(cfg config.Agent) *Sender {
+	return &Sender{
+		proxy:  cfg.Proxy,
+		url:    strings.TrimSuffix(cfg.ReportURL, "/") + "/heartbeats",
+		secret: cfg.Secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *Sender) Send(hb db.Heartbeat) error {
+	body, err := json.Marshal(hb)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signing.SignRequest(req, s.secret, s.proxy, body, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("heartbeat: send: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("heartbeat: send returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package heartbeat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+	"go-cf-zone-switch/pkg/httpserver"
+	"go-cf-zone-switch/pkg/signing"
+)
+
+const maxHeartbeatSize = 64 << 10
+
+var ErrReplayed = errors.New("heartbeat: heartbeat is not newer than the last one of the proxy")
+
+// Server receives the signed heartbeats of the proxy agents.
+//
+//	POST /heartbeats  the body is one heartbeat, its host is the signing agent
+type Server struct {
+	listen  string
+	agents  map[string]string // key: proxy address, value: secret
+	tracker *Tracker
+	now     func() time.Time
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time // key: proxy address, signed time of the last accepted heartbeat
+}
+
+func NewServer(cfg config.Heartbeat, tracker *Tracker) *Server {
+	return &Server{listen: cfg.Listen, agents: cfg.Agents, tracker: tracker, now: time.Now, lastSent: map[string]time.Time{}}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /heartbeats", s.receiveHeartbeat)
+	return mux
+}
+
+// Start serves the agents until the context is canceled
+func (s *Server) Start(ctx context.Context) {
+	httpserver.Start(ctx, "heartbeat", s.listen, s.Handler())
+}
+
+func (s *Server) receiveHeartbeat(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxHeartbeatSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	host, signedAt, err := signing.VerifyRequest(r, func(agent string) string { return s.agents[agent] }, body, s.now())
+	if errors.Is(err, signing.ErrUnknownAgent) {
+		log.Printf("heartbeat: rejected heartbeat from %s: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("heartbeat: rejected heartbeat from %s: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var hb db.Heartbeat
+	if err := json.Unmarshal(body, &hb); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.accept(host, signedAt); err != nil {
+		log.Printf("heartbeat: rejected heartbeat: %v", err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	hb.Host = host
+
+	if err := s.tracker.Receive(hb); err != nil {
+		log.Printf("heartbeat: failed to record heartbeat of %s: %v", host, err)
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, ErrNoHost):
+			status = http.StatusBadRequest
+		case errors.Is(err, ErrUnknownProxy):
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// accept records signedAt as the last heartbeat of the proxy, a heartbeat not signed
+// after the last accepted one is rejected as a replay
+func (s *Server) accept(host string, signedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !signedAt.After(s.lastSent[host]) {
+		return fmt.Errorf("%w: %s signed at %s", ErrReplayed, host, signedAt.Format(time.RFC3339))
+	}
+	s.lastSent[host] = signedAt
+	return nil
+}
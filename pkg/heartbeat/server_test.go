@@ -0,0 +1,81 @@
+package heartbeat
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+	"go-cf-zone-switch/pkg/signing"
+)
+
+func TestServer_AcceptsSignedHeartbeats(t *testing.T) {
+	storage := db.NewMemoryStorage()
+	tracker, _, _ := newTestTracker(t, storage)
+	srv := httptest.NewServer(NewServer(config.Heartbeat{Agents: map[string]string{"10.0.0.1": "secret", "10.9.9.9": "secret"}}, tracker).Handler())
+	defer srv.Close()
+
+	sender := NewSender(config.Agent{Proxy: "10.0.0.1", ReportURL: srv.URL, Secret: "secret"})
+	if err := sender.Send(db.Heartbeat{Host: "10.0.0.2", ConfigVersion: "v1", Connections: 12}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	stored, _ := storage.GetHeartbeats()
+	if len(stored) != 1 || stored[0].Host != "10.0.0.1" || stored[0].ConfigVersion != "v1" || stored[0].Connections != 12 {
+		t.Errorf("Expected the heartbeat stored for the signing proxy, got %+v", stored)
+	}
+
+	wrongSecret := NewSender(config.Agent{Proxy: "10.0.0.1", ReportURL: srv.URL, Secret: "other"})
+	if err := wrongSecret.Send(db.Heartbeat{}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Expected 401 for a wrong secret, got %v", err)
+	}
+
+	noSecret := NewSender(config.Agent{Proxy: "fra", ReportURL: srv.URL, Secret: "secret"})
+	if err := noSecret.Send(db.Heartbeat{}); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected 403 for a proxy without a secret, got %v", err)
+	}
+
+	notInPool := NewSender(config.Agent{Proxy: "10.9.9.9", ReportURL: srv.URL, Secret: "secret"})
+	if err := notInPool.Send(db.Heartbeat{}); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected 403 for a proxy not in the pool, got %v", err)
+	}
+	if stored, _ := storage.GetHeartbeats(); len(stored) != 1 {
+		t.Errorf("Expected only the heartbeat of the pooled proxy stored, got %+v", stored)
+	}
+}
+
+func TestServer_RejectsReplayedHeartbeats(t *testing.T) {
+	storage := db.NewMemoryStorage()
+	tracker, _, _ := newTestTracker(t, storage)
+	srv := httptest.NewServer(NewServer(config.Heartbeat{Agents: map[string]string{"10.0.0.1": "secret"}}, tracker).Handler())
+	defer srv.Close()
+
+	post := func(body string, signedAt time.Time) int {
+		req, _ := http.NewRequest("POST", srv.URL+"/heartbeats", strings.NewReader(body))
+		signing.SignRequest(req, "secret", "10.0.0.1", []byte(body), signedAt)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	signedAt := time.Now()
+	if code := post(`{"service":"nginx","service_up":false}`, signedAt); code != http.StatusNoContent {
+		t.Errorf("Expected the first heartbeat accepted, got %d", code)
+	}
+	if code := post(`{"service":"nginx","service_up":true}`, signedAt.Add(time.Second)); code != http.StatusNoContent {
+		t.Errorf("Expected a newer heartbeat accepted, got %d", code)
+	}
+	if code := post(`{"service":"nginx","service_up":false}`, signedAt); code != http.StatusConflict {
+		t.Errorf("Expected 409 for a replayed heartbeat, got %d", code)
+	}
+
+	if stored, _ := storage.GetHeartbeats(); len(stored) != 1 || !stored[0].ServiceUp {
+		t.Errorf("Expected the replay not to overwrite the last heartbeat, got %+v", stored)
+	}
+}
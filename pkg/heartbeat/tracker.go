@@ -0,0 +1,147 @@
+package heartbeat
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+	"go-cf-zone-switch/pkg/servers"
+)
+
+const defaultTimeout = 90 * time.Second
+
+var (
+	ErrNoHost       = errors.New("heartbeat: no proxy host")
+	ErrUnknownProxy = errors.New("heartbeat: not a proxy of the pool")
+)
+
+// Tracker keeps the last heartbeat of every proxy and fails the up checks of a proxy
+// whose agent stopped sending them or reports its service down, before the checks
+// reach next. Proxies that never sent a heartbeat are passed through unchanged.
+type Tracker struct {
+	next    servers.StatusReceiver
+	storage db.Storage
+	proxies servers.ProxyLookup
+	timeout time.Duration
+	now     func() time.Time
+	started time.Time // heartbeats stored before a restart get the timeout from here
+
+	mu   sync.Mutex
+	last map[string]db.Heartbeat // key: proxy host
+}
+
+var _ servers.StatusReceiver = (*Tracker)(nil)
+
+// NewTracker loads the stored heartbeats, so proxies with an agent are expected to report after a restart.
+// Only heartbeats of the proxies found in proxies are accepted.
+func NewTracker(cfg config.Heartbeat, storage db.Storage, proxies servers.ProxyLookup, next servers.StatusReceiver) (*Tracker, error) {
+	stored, err := storage.GetHeartbeats()
+	if err != nil {
+		return nil, fmt.Errorf("heartbeat: load: %w", err)
+	}
+
+	timeout := time.Duration(cfg.TimeoutSec) * time.Second
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	t := &Tracker{
+		next:    next,
+		storage: storage,
+		proxies: proxies,
+		timeout: timeout,
+		now:     time.Now,
+		started: time.Now(),
+		last:    map[string]db.Heartbeat{},
+	}
+	for _, hb := range stored {
+		t.last[hb.Host] = hb
+	}
+	return t, nil
+}
+
+// UsePool forgets the last heartbeat of proxies removed or disabled in the pool,
+// they are passed through unchanged until their agent reports again
+func (t *Tracker) UsePool(pool *servers.Pool) {
+	pool.Subscribe(func(e servers.PoolEvent) {
+		if e.Type != servers.PoolRemoved && e.Type != servers.PoolDisabled {
+			return
+		}
+		// the lock is only held to read and write last, so it does not block the pool
+		t.mu.Lock()
+		delete(t.last, e.Proxy.Address)
+		t.mu.Unlock()
+	})
+}
+
+// Receive records a heartbeat and logs changes of the service state and the applied config version
+func (t *Tracker) Receive(hb db.Heartbeat) error {
+	if hb.Host == "" {
+		return ErrNoHost
+	}
+	proxy, ok := t.proxies.LookupProxy(hb.Host)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownProxy, hb.Host)
+	}
+
+	t.mu.Lock()
+	hb.ReceivedAt = t.now()
+	prev, seen := t.last[hb.Host]
+	t.last[hb.Host] = hb
+	t.mu.Unlock()
+
+	name := proxy.Name
+	switch {
+	case !seen:
+		log.Printf("heartbeat: first heartbeat from %s", name)
+	case prev.ServiceUp && !hb.ServiceUp && hb.Service != "":
+		log.Printf("heartbeat: %s reports %s down: %s", name, hb.Service, hb.ServiceError)
+	}
+	if hb.ConfigVersion != "" && hb.ConfigVersion != prev.ConfigVersion {
+		log.Printf("heartbeat: %s applied config version %s", name, hb.ConfigVersion)
+	}
+
+	return t.storage.SaveHeartbeat(hb)
+}
+
+// ReceiveStatus fails the up checks of proxies with a missing heartbeat or a service reported down
+func (t *Tracker) ReceiveStatus(statuses []servers.ServerStatus) error {
+	t.mu.Lock()
+	now := t.now()
+	combined := make([]servers.ServerStatus, 0, len(statuses))
+	for _, s := range statuses {
+		if s.IsUp {
+			if problem := t.problem(s.Host, now); problem != "" {
+				s.IsUp, s.Error = false, problem
+			}
+		}
+		combined = append(combined, s)
+	}
+	t.mu.Unlock()
+
+	return t.next.ReceiveStatus(combined)
+}
+
+// problem describes why the heartbeats of a proxy fail its check, it must be called with the lock held
+func (t *Tracker) problem(host string, now time.Time) string {
+	hb, ok := t.last[host]
+	if !ok {
+		return ""
+	}
+
+	since := hb.ReceivedAt
+	if since.Before(t.started) {
+		since = t.started
+	}
+	if missing := now.Sub(since); missing >= t.timeout {
+		return fmt.Sprintf("no heartbeat for %s", now.Sub(hb.ReceivedAt).Round(time.Second))
+	}
+	if hb.Service != "" && !hb.ServiceUp {
+		return fmt.Sprintf("agent reports %s down: %s", hb.Service, hb.ServiceError)
+	}
+	return ""
+}
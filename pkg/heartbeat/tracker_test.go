@@ -0,0 +1,151 @@
+package heartbeat
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+	"go-cf-zone-switch/pkg/probe"
+	"go-cf-zone-switch/pkg/servers"
+	"go-cf-zone-switch/pkg/servers/statustest"
+)
+
+func newTestTracker(t *testing.T, storage db.Storage) (*Tracker, *statustest.Receiver, *statustest.Clock) {
+	receiver, clock := &statustest.Receiver{}, statustest.NewClock()
+	proxies := config.Servers{Proxy: []config.Proxy{{Name: "fra", Address: "fra"}, {Name: "lon", Address: "lon"}, {Name: "local", Address: "10.0.0.1"}}}
+	tracker, err := NewTracker(config.Heartbeat{TimeoutSec: 60}, storage, proxies, receiver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.now = clock.Now
+	tracker.started = clock.Now()
+	return tracker, receiver, clock
+}
+
+func up(host string) servers.ServerStatus {
+	return servers.ServerStatus{Host: host, Port: "80", IsUp: true}
+}
+
+func TestTracker_MissingHeartbeatFailsCheck(t *testing.T) {
+	tracker, receiver, clock := newTestTracker(t, db.NewMemoryStorage())
+
+	_ = tracker.Receive(db.Heartbeat{Host: "fra"})
+	clock.Add(30 * time.Second)
+	_ = tracker.ReceiveStatus([]servers.ServerStatus{up("fra"), up("lon")})
+	if !receiver.Statuses[0].IsUp || !receiver.Statuses[1].IsUp {
+		t.Errorf("Expected both up within the timeout, got %+v", receiver.Statuses)
+	}
+
+	clock.Add(45 * time.Second)
+	_ = tracker.ReceiveStatus([]servers.ServerStatus{up("fra"), up("lon")})
+	if got := receiver.Statuses[0]; got.IsUp || got.Error != "no heartbeat for 1m15s" {
+		t.Errorf("Expected fra down without a heartbeat, got %+v", got)
+	}
+	if !receiver.Statuses[1].IsUp {
+		t.Errorf("Expected lon without an agent to pass unchanged, got %+v", receiver.Statuses[1])
+	}
+
+	_ = tracker.Receive(db.Heartbeat{Host: "fra"})
+	_ = tracker.ReceiveStatus([]servers.ServerStatus{up("fra")})
+	if !receiver.Statuses[0].IsUp {
+		t.Errorf("Expected fra up after a new heartbeat, got %+v", receiver.Statuses[0])
+	}
+}
+
+func TestTracker_ServiceDownFailsCheck(t *testing.T) {
+	tracker, receiver, _ := newTestTracker(t, db.NewMemoryStorage())
+
+	_ = tracker.Receive(db.Heartbeat{Host: "fra", Service: "nginx", ServiceError: "connection refused"})
+	failed := servers.ServerStatus{Host: "fra", Error: "timeout"}
+	_ = tracker.ReceiveStatus([]servers.ServerStatus{up("fra"), failed})
+
+	if got := receiver.Statuses[0]; got.IsUp || !strings.Contains(got.Error, "nginx down: connection refused") {
+		t.Errorf("Expected fra down with the reported service error, got %+v", got)
+	}
+	if receiver.Statuses[1].Error != "timeout" {
+		t.Errorf("Expected a failed check to keep its own error, got %+v", receiver.Statuses[1])
+	}
+}
+
+func TestTracker_StoredHeartbeatsGetTimeoutAfterRestart(t *testing.T) {
+	storage := db.NewMemoryStorage()
+	old := time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC)
+	_ = storage.SaveHeartbeat(db.Heartbeat{Host: "fra", ReceivedAt: old, ConfigVersion: "v1"})
+
+	tracker, receiver, clock := newTestTracker(t, storage)
+	_ = tracker.ReceiveStatus([]servers.ServerStatus{up("fra")})
+	if !receiver.Statuses[0].IsUp {
+		t.Errorf("Expected fra up right after the restart, got %+v", receiver.Statuses[0])
+	}
+
+	clock.Add(time.Minute)
+	_ = tracker.ReceiveStatus([]servers.ServerStatus{up("fra")})
+	if receiver.Statuses[0].IsUp {
+		t.Errorf("Expected fra down a timeout after the restart, got %+v", receiver.Statuses[0])
+	}
+
+	_ = tracker.Receive(db.Heartbeat{Host: "fra", ConfigVersion: "v2"})
+	stored, _ := storage.GetHeartbeats()
+	if len(stored) != 1 || stored[0].ConfigVersion != "v2" || !stored[0].ReceivedAt.Equal(clock.Now()) {
+		t.Errorf("Expected the new heartbeat stored with its receive time, got %+v", stored)
+	}
+}
+
+func TestTracker_RejectsUnknownProxies(t *testing.T) {
+	storage := db.NewMemoryStorage()
+	tracker, _, _ := newTestTracker(t, storage)
+
+	if err := tracker.Receive(db.Heartbeat{Host: "10.9.9.9"}); !errors.Is(err, ErrUnknownProxy) {
+		t.Errorf("Expected ErrUnknownProxy, got %v", err)
+	}
+	if stored, _ := storage.GetHeartbeats(); len(stored) != 0 {
+		t.Errorf("Expected no heartbeat stored for an unknown proxy, got %+v", stored)
+	}
+}
+
+func TestTracker_AfterQuorum(t *testing.T) {
+	tracker, receiver, _ := newTestTracker(t, db.NewMemoryStorage())
+	quorum := probe.NewQuorum(config.Quorum{Agents: map[string]string{"ams": "secret", "nyc": "secret"}}, time.Minute, tracker, nil)
+
+	_ = tracker.Receive(db.Heartbeat{Host: "fra", Service: "nginx", ServiceError: "connection refused"})
+	_ = quorum.ReceiveAgent("ams", time.Now(), []servers.ServerStatus{up("fra"), up("lon")})
+	_ = quorum.ReceiveAgent("nyc", time.Now(), []servers.ServerStatus{up("fra"), up("lon")})
+	_ = quorum.ReceiveStatus([]servers.ServerStatus{up("fra"), up("lon")})
+
+	if got := receiver.Statuses[0]; got.IsUp || !strings.Contains(got.Error, "nginx down") {
+		t.Errorf("Expected the heartbeat to fail fra though every vantage point sees it up, got %+v", got)
+	}
+	if !receiver.Statuses[1].IsUp {
+		t.Errorf("Expected lon up, got %+v", receiver.Statuses[1])
+	}
+}
+
+func TestTracker_ForgetsProxiesLeavingThePool(t *testing.T) {
+	storage := db.NewMemoryStorage()
+	pool, err := servers.NewPool(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = pool.Add(config.Proxy{Name: "fra", Address: "10.0.0.1", Port: 80})
+	_ = pool.Add(config.Proxy{Name: "lon", Address: "10.0.0.2", Port: 80})
+
+	receiver := &statustest.Receiver{}
+	tracker, err := NewTracker(config.Heartbeat{}, storage, pool, receiver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.UsePool(pool)
+
+	_ = tracker.Receive(db.Heartbeat{Host: "10.0.0.1", Service: "nginx", ServiceError: "connection refused"})
+	_ = tracker.Receive(db.Heartbeat{Host: "10.0.0.2", Service: "nginx", ServiceError: "connection refused"})
+	_ = pool.SetEnabled("fra", false)
+	_ = pool.Remove("lon")
+
+	_ = tracker.ReceiveStatus([]servers.ServerStatus{up("10.0.0.1"), up("10.0.0.2")})
+	if !receiver.Statuses[0].IsUp || !receiver.Statuses[1].IsUp {
+		t.Errorf("Expected the heartbeats of the disabled and removed proxies forgotten, got %+v", receiver.Statuses)
+	}
+}
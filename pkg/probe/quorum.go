@@ -0,0 +1,199 @@
+package probe
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/servers"
+	"go-cf-zone-switch/pkg/signing"
+)
+
+const defaultStaleIntervals = 3
+
+var (
+	ErrUnknownAgent = signing.ErrUnknownAgent
+	ErrReplayed     = errors.New("probe: report is not newer than the last one of the agent")
+)
+
+type agentCheck struct {
+	status   servers.ServerStatus
+	signedAt time.Time
+}
+
+// Quorum combines the local checks with the latest checks of the agents before
+// they reach the switcher. A proxy is reported down only when the required number
+// of vantage points sees it down. Agents without a fresh check of the proxy are not
+// counted and do not lower the required number, with local fallback the local check
+// decides while the quorum cannot be formed.
+type Quorum struct {
+	next          servers.StatusReceiver
+	notifier      servers.Notifier
+	agents        map[string]string // key: agent, value: secret
+	required      int
+	localFallback bool
+	staleAfter    time.Duration
+	now           func() time.Time
+
+	mu       sync.Mutex
+	checks   map[string]map[string]agentCheck // key: agent, host
+	lastSent map[string]time.Time             // key: agent, signed time of the last accepted report
+	noQuorum map[string]bool                  // key: host, notified once until the quorum is back
+}
+
+var _ servers.StatusReceiver = (*Quorum)(nil)
+
+// NewQuorum passes the combined local rounds to next and notifies when the quorum of proxies
+// is lost and formed again, notifier may be nil
+func NewQuorum(cfg config.Quorum, checkInterval time.Duration, next servers.StatusReceiver, notifier servers.Notifier) *Quorum {
+	required := cfg.Required
+	if required == 0 {
+		required = (len(cfg.Agents)+1)/2 + 1 // majority of the agents and the local monitor
+	}
+
+	staleAfter := time.Duration(cfg.StaleSec) * time.Second
+	if staleAfter == 0 {
+		staleAfter = defaultStaleIntervals * checkInterval
+	}
+
+	if !cfg.LocalFallback {
+		log.Printf("probe: local_fallback is off, proxies are not failed over while fewer than %d vantage points report", required)
+	}
+
+	return &Quorum{
+		next:          next,
+		notifier:      notifier,
+		agents:        cfg.Agents,
+		required:      required,
+		localFallback: cfg.LocalFallback,
+		staleAfter:    staleAfter,
+		now:           time.Now,
+		checks:        map[string]map[string]agentCheck{},
+		lastSent:      map[string]time.Time{},
+		noQuorum:      map[string]bool{},
+	}
+}
+
+// secret returns the secret of the agent, or "" for an unknown agent
+func (q *Quorum) secret(agent string) string {
+	return q.agents[agent]
+}
+
+// ReceiveAgent keeps the checks of an agent signed at signedAt until the next local round.
+// A report not signed after the last accepted one of the agent is rejected as a replay.
+func (q *Quorum) ReceiveAgent(agent string, signedAt time.Time, statuses []servers.ServerStatus) error {
+	if _, ok := q.agents[agent]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownAgent, agent)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !signedAt.After(q.lastSent[agent]) {
+		return fmt.Errorf("%w: %s signed at %s", ErrReplayed, agent, signedAt.Format(time.RFC3339))
+	}
+	q.lastSent[agent] = signedAt
+
+	checks := map[string]agentCheck{}
+	for _, s := range statuses {
+		checks[s.Host] = agentCheck{status: s, signedAt: signedAt}
+	}
+	q.checks[agent] = checks
+	return nil
+}
+
+// ReceiveStatus takes a local round and reports the quorum of every proxy
+func (q *Quorum) ReceiveStatus(statuses []servers.ServerStatus) error {
+	q.mu.Lock()
+	now := q.now()
+	combined := make([]servers.ServerStatus, 0, len(statuses))
+	lost, formed := []string{}, []string{}
+	for _, s := range statuses {
+		status, votes := q.decide(s, now)
+		combined = append(combined, status)
+
+		noQuorum := votes < q.required
+		switch {
+		case noQuorum && !q.noQuorum[s.Host]:
+			lost = append(lost, fmt.Sprintf("%s (%d of %d)", s.Host, votes, q.required))
+		case !noQuorum && q.noQuorum[s.Host]:
+			formed = append(formed, s.Host)
+		}
+		q.noQuorum[s.Host] = noQuorum
+	}
+	q.mu.Unlock()
+
+	if len(lost) > 0 {
+		decides := "they are not failed over until it is back"
+		if q.localFallback {
+			decides = "the local check decides until it is back"
+		}
+		q.notify(fmt.Sprintf("No quorum of vantage points for %s, %s", strings.Join(lost, ", "), decides))
+	}
+	if len(formed) > 0 {
+		q.notify(fmt.Sprintf("Quorum of vantage points formed again for %s", strings.Join(formed, ", ")))
+	}
+
+	return q.next.ReceiveStatus(combined)
+}
+
+func (q *Quorum) notify(message string) {
+	log.Printf("probe: %s", message)
+	if q.notifier == nil {
+		return
+	}
+	if err := q.notifier.Notify(message); err != nil {
+		log.Printf("probe: failed to send notification: %v", err)
+	}
+}
+
+// decide votes on the local status of one proxy and returns the number of vantage points
+// that voted, it must be called with the lock held
+func (q *Quorum) decide(local servers.ServerStatus, now time.Time) (servers.ServerStatus, int) {
+	votes, down := 1, 0
+	if !local.IsUp {
+		down++
+	}
+
+	failed := []string{}
+	for agent, checks := range q.checks {
+		c, ok := checks[local.Host]
+		if !ok || now.Sub(c.signedAt) > q.staleAfter {
+			continue
+		}
+		votes++
+		if !c.status.IsUp {
+			down++
+			failed = append(failed, agent+": "+errorOf(c.status))
+		}
+	}
+	sort.Strings(failed)
+
+	status := local
+	status.IsUp = down < q.required
+	if votes < q.required && q.localFallback {
+		status.IsUp = local.IsUp
+	}
+
+	switch {
+	case !local.IsUp && status.IsUp:
+		log.Printf("probe: %s failed locally (%s) but is up for %d of %d vantage points, %d required down",
+			local.Host, errorOf(local), votes-down, votes, q.required)
+		status.Error = ""
+	case local.IsUp && !status.IsUp:
+		status.Error = "down from " + strings.Join(failed, ", ")
+	}
+	return status, votes
+}
+
+func errorOf(s servers.ServerStatus) string {
+	if s.Error == "" {
+		return "unreachable"
+	}
+	return s.Error
+}
@@ -0,0 +1,140 @@
+package probe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/servers"
+	"go-cf-zone-switch/pkg/servers/statustest"
+)
+
+func newTestQuorum(required int) (*Quorum, *statustest.Receiver, *statustest.Clock) {
+	receiver, clock := &statustest.Receiver{}, statustest.NewClock()
+	q := NewQuorum(config.Quorum{Agents: map[string]string{"ams": "ams-secret", "nyc": "nyc-secret"}, Required: required}, time.Minute, receiver, nil)
+	q.now = clock.Now
+	return q, receiver, clock
+}
+
+func status(host string, up bool) servers.ServerStatus {
+	s := servers.ServerStatus{Host: host, Port: "80", IsUp: up}
+	if !up {
+		s.Error = "connection refused"
+	}
+	return s
+}
+
+func TestQuorum_DownOnlyWhenQuorumAgrees(t *testing.T) {
+	q, receiver, clock := newTestQuorum(0) // majority of 3 is 2
+
+	_ = q.ReceiveAgent("ams", clock.Now(), []servers.ServerStatus{status("fra", true), status("lon", false)})
+	_ = q.ReceiveAgent("nyc", clock.Now(), []servers.ServerStatus{status("fra", true), status("lon", true)})
+	_ = q.ReceiveStatus([]servers.ServerStatus{status("fra", false), status("lon", false)})
+
+	got := receiver.Statuses
+	if !got[0].IsUp || got[0].Error != "" {
+		t.Errorf("Expected fra up, only the local check failed, got %+v", got[0])
+	}
+	if got[1].IsUp {
+		t.Errorf("Expected lon down, the local check and ams agree, got %+v", got[1])
+	}
+}
+
+func TestQuorum_AgentsOutvoteHealthyLocalCheck(t *testing.T) {
+	q, receiver, clock := newTestQuorum(0)
+
+	_ = q.ReceiveAgent("ams", clock.Now(), []servers.ServerStatus{status("fra", false)})
+	_ = q.ReceiveAgent("nyc", clock.Now(), []servers.ServerStatus{status("fra", false)})
+	_ = q.ReceiveStatus([]servers.ServerStatus{status("fra", true)})
+
+	got := receiver.Statuses[0]
+	if got.IsUp || !strings.Contains(got.Error, "ams: connection refused") || !strings.Contains(got.Error, "nyc: connection refused") {
+		t.Errorf("Expected fra down from both agents, got %+v", got)
+	}
+}
+
+func TestQuorum_StaleAgentsAreNotCounted(t *testing.T) {
+	q, receiver, clock := newTestQuorum(0)
+
+	_ = q.ReceiveAgent("ams", clock.Now(), []servers.ServerStatus{status("fra", true)})
+	_ = q.ReceiveAgent("nyc", clock.Now().Add(-4*time.Minute), []servers.ServerStatus{status("fra", false)}) // default stale after 3 check intervals
+
+	_ = q.ReceiveStatus([]servers.ServerStatus{status("fra", false)})
+	if !receiver.Statuses[0].IsUp {
+		t.Errorf("Expected fra up, the report of nyc was signed too long ago, got %+v", receiver.Statuses[0])
+	}
+
+	clock.Add(4 * time.Minute)
+	_ = q.ReceiveStatus([]servers.ServerStatus{status("fra", false)})
+	if !receiver.Statuses[0].IsUp {
+		t.Errorf("Expected the local check alone not to reach the quorum, got %+v", receiver.Statuses[0])
+	}
+}
+
+func TestQuorum_LocalFallback(t *testing.T) {
+	receiver := &statustest.Receiver{}
+	q := NewQuorum(config.Quorum{Agents: map[string]string{"ams": "secret"}, Required: 2, LocalFallback: true}, time.Minute, receiver, nil)
+
+	_ = q.ReceiveStatus([]servers.ServerStatus{status("fra", false)})
+	if receiver.Statuses[0].IsUp {
+		t.Errorf("Expected the local check to decide without a quorum, got %+v", receiver.Statuses[0])
+	}
+}
+
+func TestQuorum_RejectsReplayedReports(t *testing.T) {
+	q, _, clock := newTestQuorum(0)
+
+	if err := q.ReceiveAgent("ams", clock.Now(), nil); err != nil {
+		t.Fatalf("Failed to receive report: %v", err)
+	}
+	if err := q.ReceiveAgent("ams", clock.Now(), nil); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Expected the same timestamp to be rejected, got %v", err)
+	}
+	if err := q.ReceiveAgent("ams", clock.Now().Add(-time.Second), nil); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Expected an older timestamp to be rejected, got %v", err)
+	}
+	if err := q.ReceiveAgent("nyc", clock.Now(), nil); err != nil {
+		t.Errorf("Expected agents to be tracked apart, got %v", err)
+	}
+}
+
+func TestQuorum_RejectsUnknownAgent(t *testing.T) {
+	q, _, clock := newTestQuorum(0)
+
+	if err := q.ReceiveAgent("sfo", clock.Now(), nil); !errors.Is(err, ErrUnknownAgent) {
+		t.Errorf("Expected ErrUnknownAgent, got %v", err)
+	}
+}
+
+type messages []string
+
+func (m *messages) Notify(message string) error {
+	*m = append(*m, message)
+	return nil
+}
+
+func TestQuorum_NotifiesLostAndFormedQuorum(t *testing.T) {
+	receiver, clock, notified := &statustest.Receiver{}, statustest.NewClock(), &messages{}
+	q := NewQuorum(config.Quorum{Agents: map[string]string{"ams": "secret", "nyc": "secret"}}, time.Minute, receiver, notified)
+	q.now = clock.Now
+
+	_ = q.ReceiveStatus([]servers.ServerStatus{status("fra", false), status("lon", true)})
+	_ = q.ReceiveStatus([]servers.ServerStatus{status("fra", false), status("lon", true)})
+	if len(*notified) != 1 || !strings.Contains((*notified)[0], "No quorum of vantage points for fra (1 of 2), lon (1 of 2), they are not failed over") {
+		t.Errorf("Expected one notification for the lost quorum, got %q", *notified)
+	}
+	if !receiver.Statuses[0].IsUp {
+		t.Errorf("Expected fra kept up without local fallback, got %+v", receiver.Statuses[0])
+	}
+
+	_ = q.ReceiveAgent("ams", clock.Now(), []servers.ServerStatus{status("fra", false), status("lon", true)})
+	_ = q.ReceiveStatus([]servers.ServerStatus{status("fra", false), status("lon", true)})
+	if len(*notified) != 2 || (*notified)[1] != "Quorum of vantage points formed again for fra, lon" {
+		t.Errorf("Expected one notification for the formed quorum, got %q", *notified)
+	}
+	if receiver.Statuses[0].IsUp {
+		t.Errorf("Expected fra down with the quorum back, got %+v", receiver.Statuses[0])
+	}
+}
@@ -0,0 +1,60 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/servers"
+	"go-cf-zone-switch/pkg/signing"
+)
+
+// Reporter sends the rounds of an agent to the main instance
+type Reporter struct {
+	name   string
+	url    string
+	secret string
+	client *http.Client
+}
+
+var _ servers.StatusReceiver = (*Reporter)(nil)
+
+func NewReporter(cfg config.Probe) *Reporter {
+	return &Reporter{
+		name:   cfg.Name,
+		url:    strings.TrimSuffix(cfg.ReportURL, "/") + "/reports",
+		secret: cfg.Secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (r *Reporter) ReceiveStatus(statuses []servers.ServerStatus) error {
+	body, err := json.Marshal(statuses)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signing.SignRequest(req, r.secret, r.name, body, time.Now())
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("probe: report: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("probe: report returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/httpserver"
+	"go-cf-zone-switch/pkg/servers"
+	"go-cf-zone-switch/pkg/signing"
+)
+
+const maxReportSize = 1 << 20
+
+// Server receives the signed reports of the agents.
+//
+//	POST /reports  the body is the statuses of one agent round
+type Server struct {
+	listen string
+	quorum *Quorum
+	now    func() time.Time
+}
+
+func NewServer(cfg config.Quorum, quorum *Quorum) *Server {
+	return &Server{listen: cfg.Listen, quorum: quorum, now: time.Now}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /reports", s.receiveReport)
+	return mux
+}
+
+// Start serves the agents until the context is canceled
+func (s *Server) Start(ctx context.Context) {
+	httpserver.Start(ctx, "probe", s.listen, s.Handler())
+}
+
+func (s *Server) receiveReport(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxReportSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	agent, signedAt, err := signing.VerifyRequest(r, s.quorum.secret, body, s.now())
+	if errors.Is(err, ErrUnknownAgent) {
+		log.Printf("probe: rejected report from %s: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("probe: rejected report from %s: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var statuses []servers.ServerStatus
+	if err := json.Unmarshal(body, &statuses); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.quorum.ReceiveAgent(agent, signedAt, statuses)
+	if errors.Is(err, ErrReplayed) {
+		log.Printf("probe: rejected report: %v", err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("probe: rejected report: %v", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
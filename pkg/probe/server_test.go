@@ -0,0 +1,67 @@
+package probe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/servers"
+	"go-cf-zone-switch/pkg/signing"
+)
+
+func TestServer_AcceptsSignedReports(t *testing.T) {
+	q, receiver, _ := newTestQuorum(1)
+	srv := httptest.NewServer(NewServer(config.Quorum{}, q).Handler())
+	defer srv.Close()
+
+	reporter := NewReporter(config.Probe{Name: "ams", ReportURL: srv.URL, Secret: "ams-secret"})
+	if err := reporter.ReceiveStatus([]servers.ServerStatus{status("fra", false)}); err != nil {
+		t.Fatalf("Failed to report: %v", err)
+	}
+
+	_ = q.ReceiveStatus([]servers.ServerStatus{status("fra", true)})
+	if receiver.Statuses[0].IsUp {
+		t.Errorf("Expected the reported agent check to count, got %+v", receiver.Statuses[0])
+	}
+}
+
+func TestServer_RejectsBadReports(t *testing.T) {
+	q, _, _ := newTestQuorum(0)
+	srv := httptest.NewServer(NewServer(config.Quorum{}, q).Handler())
+	defer srv.Close()
+
+	wrongSecret := NewReporter(config.Probe{Name: "ams", ReportURL: srv.URL, Secret: "nyc-secret"})
+	if err := wrongSecret.ReceiveStatus(nil); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Expected 401 for a wrong secret, got %v", err)
+	}
+
+	unknown := NewReporter(config.Probe{Name: "sfo", ReportURL: srv.URL, Secret: "ams-secret"})
+	if err := unknown.ReceiveStatus(nil); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected 403 for an unknown agent, got %v", err)
+	}
+
+	post := func(signedAt time.Time) int {
+		body := []byte("[]")
+		req, _ := http.NewRequest("POST", srv.URL+"/reports", strings.NewReader(string(body)))
+		signing.SignRequest(req, "ams-secret", "ams", body, signedAt)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post(time.Now().Add(-time.Hour)); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an old report, got %d", code)
+	}
+	signedAt := time.Now()
+	if code := post(signedAt); code != http.StatusNoContent {
+		t.Errorf("Expected the first report accepted, got %d", code)
+	}
+	if code := post(signedAt); code != http.StatusConflict {
+		t.Errorf("Expected 409 for a replayed report, got %d", code)
+	}
+}
@@ -0,0 +1,208 @@
+package servers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go-cf-zone-switch/pkg/db"
+)
+
+// CanaryTarget is the domain a canary check requests through a proxy
+// and the hosting IPs the response must come from, none skips the match
+type CanaryTarget struct {
+	Domain     string
+	HostingIPs []string
+}
+
+// CanarySource picks the canary of a proxy, an empty domain means the proxy serves none
+type CanarySource interface {
+	CanaryTarget(proxyHost string) (CanaryTarget, error)
+}
+
+// canaryRefresh is how long the stored domains are reused before they are read again
+const canaryRefresh = time.Minute
+
+// StorageCanaries rotates through the stored domains for each proxy. Every proxy
+// is sent all domains, so standby proxies are checked with real domains too and
+// a single customer with a dead upstream fails only some of the checks.
+type StorageCanaries struct {
+	storage db.Storage
+	now     func() time.Time
+
+	mu       sync.Mutex
+	domains  []CanaryTarget // read at loadedAt
+	loadedAt time.Time
+	next     map[string]int // key: proxy host
+}
+
+func NewStorageCanaries(storage db.Storage) *StorageCanaries {
+	return &StorageCanaries{storage: storage, now: time.Now, next: map[string]int{}}
+}
+
+func (s *StorageCanaries) CanaryTarget(proxyHost string) (CanaryTarget, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now := s.now(); s.domains == nil || now.Sub(s.loadedAt) >= canaryRefresh {
+		domains, err := s.load()
+		if err != nil {
+			return CanaryTarget{}, err
+		}
+		s.domains, s.loadedAt = domains, now
+	}
+	if len(s.domains) == 0 {
+		return CanaryTarget{}, nil
+	}
+
+	target := s.domains[s.next[proxyHost]%len(s.domains)]
+	s.next[proxyHost]++
+	return target, nil
+}
+
+// load reads the stored domains without their tokens, it must be called with the lock held
+func (s *StorageCanaries) load() ([]CanaryTarget, error) {
+	rows, err := s.storage.GetDomainsWithoutTokens()
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]CanaryTarget, 0, len(rows))
+	for _, d := range rows {
+		target := CanaryTarget{Domain: d.Domain}
+		for _, b := range d.Backends() {
+			if b.IP == "" {
+				continue
+			}
+			target.HostingIPs = append(target.HostingIPs, b.IP)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// CanaryChecker sends the HTTP check through the proxy with the Host of a domain it serves
+// and matches the hosting IP in the canary header or else in the body of the response.
+// Without a canary domain it is a plain HTTP check.
+type CanaryChecker struct {
+	http   *HTTPChecker
+	useTLS bool
+}
+
+var _ TimedChecker = (*CanaryChecker)(nil)
+
+func NewCanaryChecker(opts CheckOptions, useTLS bool) *CanaryChecker {
+	return &CanaryChecker{http: NewHTTPChecker(opts, useTLS), useTLS: useTLS}
+}
+
+func (c *CanaryChecker) Check(ctx context.Context, host, port string) error {
+	_, err := c.CheckTimed(ctx, host, port)
+	return err
+}
+
+func (c *CanaryChecker) CheckTimed(ctx context.Context, host, port string) (Timings, error) {
+	target := c.target(host)
+	if target.Domain == "" {
+		return c.http.CheckTimed(ctx, host, port)
+	}
+
+	client := c.http.client
+	if c.useTLS && c.http.opts.TLS.ServerName == "" {
+		tlsConfig := c.http.opts.TLS.Clone()
+		tlsConfig.ServerName = target.Domain
+		client = newCheckClient(tlsConfig)
+	}
+
+	resp, timings, err := c.http.request(ctx, client, host, port, target.Domain)
+	if err != nil {
+		return timings, fmt.Errorf("canary %s: %w", target.Domain, err)
+	}
+	defer resp.Body.Close()
+
+	matchBody := len(target.HostingIPs) > 0 && c.http.opts.CanaryHeader == ""
+	body, err := c.http.matchResponse(resp, matchBody)
+	if err != nil {
+		return timings, fmt.Errorf("canary %s: %w", target.Domain, err)
+	}
+	if err := c.matchHosting(target, resp.Header, body); err != nil {
+		return timings, fmt.Errorf("canary %s: %w", target.Domain, err)
+	}
+	return timings, nil
+}
+
+// target prefers the configured canary domain, a failing source falls back to a plain HTTP check
+func (c *CanaryChecker) target(host string) CanaryTarget {
+	opts := c.http.opts
+	if opts.CanaryDomain != "" {
+		return CanaryTarget{Domain: opts.CanaryDomain, HostingIPs: opts.CanaryHostingIPs}
+	}
+	if opts.Canaries == nil {
+		return CanaryTarget{}
+	}
+
+	target, err := opts.Canaries.CanaryTarget(host)
+	if err != nil {
+		log.Printf("monitor: no canary domain for %s, checking without: %v", host, err)
+		return CanaryTarget{}
+	}
+	return target
+}
+
+func (c *CanaryChecker) matchHosting(target CanaryTarget, header http.Header, body []byte) error {
+	if len(target.HostingIPs) == 0 {
+		return nil
+	}
+	expected := strings.Join(target.HostingIPs, ", ")
+
+	name := c.http.opts.CanaryHeader
+	if name == "" {
+		for _, ip := range target.HostingIPs {
+			if containsWord(body, ip) {
+				return nil
+			}
+		}
+		return fmt.Errorf("body names none of the hosting IPs %s", expected)
+	}
+
+	value := header.Get(name)
+	if value == "" {
+		return fmt.Errorf("response has no %s header", name)
+	}
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' || r == ' ' }) {
+		if host, _, err := net.SplitHostPort(field); err == nil {
+			field = host
+		}
+		for _, ip := range target.HostingIPs {
+			if field == ip {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("served by %s, expected %s", value, expected)
+}
+
+// containsWord reports whether word is in body and not part of a longer word,
+// so 10.0.0.1 does not match 10.0.0.12
+func containsWord(body []byte, word string) bool {
+	for offset := 0; ; {
+		i := bytes.Index(body[offset:], []byte(word))
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(word)
+		if (start == 0 || !isWordByte(body[start-1])) && (end == len(body) || !isWordByte(body[end])) {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
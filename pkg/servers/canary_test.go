@@ -0,0 +1,121 @@
+package servers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+)
+
+// canaryProxy answers like a proxy, naming the upstream it reached for the Host
+func canaryProxy(t *testing.T, upstreams map[string]string) (string, string) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream, ok := upstreams[r.Host]
+		if !ok {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("X-Backend", upstream+":443")
+		fmt.Fprintf(w, "served by %s", upstream)
+	}))
+	t.Cleanup(srv.Close)
+	return hostPort(t, srv.URL)
+}
+
+func TestCanaryChecker_RotatesStoredDomains(t *testing.T) {
+	host, port := canaryProxy(t, map[string]string{"a.com": "10.0.0.1", "b.com": "10.9.9.9", "c.com": "10.0.0.3"})
+
+	storage := db.NewMemoryStorage()
+	_ = storage.SaveDomains([]db.DomainRow{
+		{Domain: "a.com", Hostings: []db.HostingBackend{{IP: ""}, {IP: "10.0.0.1"}}},
+		{Domain: "b.com", HostingIP: "10.0.0.2"},
+		{Domain: "c.com", HostingIP: "10.0.0.3"},
+	})
+	_ = storage.SaveDnsStates([]db.DnsState{
+		{Domain: "a.com", IP: "203.0.113.1", ProxyHost: host, ObservedAt: time.Now()},
+		{Domain: "b.com", IP: "203.0.113.1", ProxyHost: host, ObservedAt: time.Now()},
+		{Domain: "c.com", IP: "203.0.113.9", ProxyHost: "other", ObservedAt: time.Now()},
+	})
+
+	opts, _ := CheckOptionsFromConfig(config.Check{Type: "canary", CanaryHeader: "X-Backend"})
+	opts.Canaries = NewStorageCanaries(storage)
+	checker := NewChecker(opts, "http")
+
+	if err := checker.Check(context.Background(), host, port); err != nil {
+		t.Errorf("Expected a.com served by its hosting, got %v", err)
+	}
+	err := checker.Check(context.Background(), host, port)
+	if err == nil || !strings.Contains(err.Error(), "canary b.com: served by 10.9.9.9:443, expected 10.0.0.2") {
+		t.Errorf("Expected b.com served by the wrong upstream, got %v", err)
+	}
+	if err := checker.Check(context.Background(), host, port); err != nil {
+		t.Errorf("Expected c.com checked though it is observed on another proxy, got %v", err)
+	}
+	if err := checker.Check(context.Background(), host, port); err != nil {
+		t.Errorf("Expected the rotation back at a.com, got %v", err)
+	}
+}
+
+func TestCanaryChecker_ConfiguredDomain(t *testing.T) {
+	host, port := canaryProxy(t, map[string]string{"canary.example": "10.0.0.12"})
+
+	for _, c := range []struct {
+		name string
+		cfg  config.Check
+		err  string
+	}{
+		{name: "body match", cfg: config.Check{CanaryHostingIPs: []string{"10.0.0.12"}}},
+		{name: "no partial ip match", cfg: config.Check{CanaryHostingIPs: []string{"10.0.0.1"}}, err: "body names none of the hosting IPs 10.0.0.1"},
+		{name: "missing header", cfg: config.Check{CanaryHostingIPs: []string{"10.0.0.12"}, CanaryHeader: "X-Upstream"}, err: "no X-Upstream header"},
+		{name: "no hosting to match", cfg: config.Check{}},
+		{name: "unknown host", cfg: config.Check{CanaryDomain: "missing.example"}, err: "canary missing.example: unexpected status 502"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			c.cfg.Type = "canary"
+			if c.cfg.CanaryDomain == "" {
+				c.cfg.CanaryDomain = "canary.example"
+			}
+			opts, err := CheckOptionsFromConfig(c.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = NewChecker(opts, "http").Check(context.Background(), host, port)
+			if c.err == "" && err != nil {
+				t.Errorf("Expected success, got %v", err)
+			}
+			if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+				t.Errorf("Expected error containing %q, got %v", c.err, err)
+			}
+		})
+	}
+}
+
+func TestStorageCanaries_RefreshesStoredDomains(t *testing.T) {
+	storage := db.NewMemoryStorage()
+	_ = storage.SaveDomains([]db.DomainRow{{Domain: "a.com", HostingIP: "10.0.0.1"}})
+
+	now := time.Now()
+	canaries := NewStorageCanaries(storage)
+	canaries.now = func() time.Time { return now }
+
+	if target, err := canaries.CanaryTarget("fra"); err != nil || target.Domain != "a.com" {
+		t.Fatalf("Expected a.com, got %+v, %v", target, err)
+	}
+
+	_ = storage.SaveDomains([]db.DomainRow{{Domain: "0.com", HostingIP: "10.0.0.2"}})
+	if target, _ := canaries.CanaryTarget("fra"); target.Domain != "a.com" {
+		t.Errorf("Expected the cached domains before the refresh, got %+v", target)
+	}
+
+	now = now.Add(canaryRefresh)
+	if target, _ := canaries.CanaryTarget("fra"); target.Domain != "0.com" {
+		t.Errorf("Expected the domains read again after the refresh, got %+v", target)
+	}
+}
@@ -0,0 +1,291 @@
+package servers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+)
+
+const (
+	defaultCertPort         = 443
+	defaultCertSampleSize   = 5
+	defaultCertInterval     = time.Hour
+	defaultCertFailoverDays = 1
+)
+
+var defaultCertAlertDays = []int{30, 7, 1}
+
+// CertOptions are the parsed [Servers.Certs] settings
+type CertOptions struct {
+	Port         string
+	SampleSize   int
+	Interval     time.Duration
+	AlertDays    []int          // highest first
+	Roots        *x509.CertPool // nil uses the system roots
+	FailoverDays int
+}
+
+// CertOptionsFromConfig fills in defaults
+func CertOptionsFromConfig(c config.Certs) CertOptions {
+	opts := CertOptions{
+		Port:         strconv.Itoa(c.Port),
+		SampleSize:   c.SampleSize,
+		Interval:     time.Duration(c.IntervalMin) * time.Minute,
+		AlertDays:    slices.Clone(c.AlertDays),
+		FailoverDays: c.FailoverDays,
+	}
+	if c.Port == 0 {
+		opts.Port = strconv.Itoa(defaultCertPort)
+	}
+	if opts.SampleSize <= 0 {
+		opts.SampleSize = defaultCertSampleSize
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultCertInterval
+	}
+	if len(opts.AlertDays) == 0 {
+		opts.AlertDays = slices.Clone(defaultCertAlertDays)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(opts.AlertDays)))
+	if opts.FailoverDays == 0 {
+		opts.FailoverDays = defaultCertFailoverDays
+	}
+	return opts
+}
+
+// CertProblem describes why the certificate of a check blocks failover, or returns
+// an empty string. A failed handshake is a problem too, the proxy cannot serve the domain over TLS.
+func CertProblem(c db.CertCheck, now time.Time, failoverDays int) string {
+	switch {
+	case !c.HasCert():
+		return fmt.Sprintf("TLS handshake for %s failed: %s", c.Domain, c.Error)
+	case !c.Valid:
+		return fmt.Sprintf("invalid certificate for %s: %s", c.Domain, c.Error)
+	case c.DaysLeft(now) < failoverDays:
+		return fmt.Sprintf("certificate for %s expires %s", c.Domain, c.NotAfter.Format(time.DateOnly))
+	}
+	return ""
+}
+
+// CertMonitor runs TLS handshakes against the proxies for a sample of the domains
+// pointing to them, or of all domains for standby proxies, records expiry and chain validity and alerts on expiring or
+// invalid certificates
+type CertMonitor struct {
+	opts     CertOptions
+	timeout  time.Duration
+	storage  db.Storage
+	proxies  func() []config.Proxy
+	notifier Notifier
+	now      func() time.Time
+
+	round int // rotates the domain sample, so every domain is checked over time
+}
+
+// NewCertMonitor checks the proxies returned by proxies every round, each handshake has the timeout,
+// 0 uses the default of the config. Proxies serving no domains are checked with all stored domains.
+func NewCertMonitor(opts CertOptions, timeout time.Duration, storage db.Storage, proxies func() []config.Proxy, notifier Notifier) *CertMonitor {
+	if timeout <= 0 {
+		timeout = config.DefaultTimeoutSec * time.Second
+	}
+	return &CertMonitor{
+		opts:     opts,
+		timeout:  timeout,
+		storage:  storage,
+		proxies:  proxies,
+		notifier: notifier,
+		now:      time.Now,
+	}
+}
+
+func (m *CertMonitor) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(m.opts.Interval)
+		defer ticker.Stop()
+
+		for {
+			if err := m.CheckCerts(ctx); err != nil {
+				log.Printf("certs: round failed: %v", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				log.Println("certs: stopped due to context cancellation")
+				return
+			}
+		}
+	}()
+}
+
+// CheckCerts runs one round over all proxies
+func (m *CertMonitor) CheckCerts(ctx context.Context) error {
+	states, err := m.storage.GetDnsStates()
+	if err != nil {
+		return fmt.Errorf("get dns states: %w", err)
+	}
+	distribution := db.ProxyDistribution(states)
+
+	stored, err := m.storage.GetCertChecks()
+	if err != nil {
+		return fmt.Errorf("get cert checks: %w", err)
+	}
+	last := map[string]db.CertCheck{}
+	for _, c := range stored {
+		last[c.Host+" "+c.Domain] = c
+	}
+
+	var all []string // every stored domain, for standby proxies that serve none yet
+	checks := []db.CertCheck{}
+	for _, proxy := range m.proxies() {
+		domains := distribution[proxy.Address]
+		if len(domains) == 0 {
+			if all == nil {
+				if all, err = m.domainNames(); err != nil {
+					return fmt.Errorf("get domains: %w", err)
+				}
+			}
+			domains = all
+		}
+		if err := m.storage.PruneCertChecks(proxy.Address, domains); err != nil {
+			log.Printf("certs: failed to prune checks of %s: %v", proxy.Address, err)
+		}
+
+		for _, domain := range sample(domains, m.opts.SampleSize, m.round) {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			check := m.checkCert(ctx, proxy.Address, domain)
+			prev, ok := last[check.Host+" "+check.Domain]
+			checks = append(checks, m.alert(proxy, check, prev, ok))
+		}
+	}
+	m.round++
+
+	return m.storage.SaveCertChecks(checks)
+}
+
+// domainNames returns the sorted names of all stored domains
+func (m *CertMonitor) domainNames() ([]string, error) {
+	rows, err := m.storage.GetDomainsWithoutTokens()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(rows))
+	for _, row := range rows {
+		names = append(names, row.Domain)
+	}
+	return names, nil
+}
+
+// sample returns n of the sorted domains starting at the round, wrapping around
+func sample(domains []string, n, round int) []string {
+	if len(domains) <= n {
+		return domains
+	}
+
+	start := (round * n) % len(domains)
+	picked := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		picked = append(picked, domains[(start+i)%len(domains)])
+	}
+	return picked
+}
+
+// checkCert runs a handshake with the domain as SNI and verifies the chain for the domain
+func (m *CertMonitor) checkCert(ctx context.Context, host, domain string) db.CertCheck {
+	now := m.now()
+	check := db.CertCheck{Host: host, Domain: domain, CheckedAt: now}
+
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	dialer := &tls.Dialer{Config: &tls.Config{
+		ServerName:         domain,
+		InsecureSkipVerify: true, // verified below, so an invalid certificate still gets its expiry recorded
+	}}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, m.opts.Port))
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	leaf := certs[0]
+	check.NotAfter = leaf.NotAfter
+	check.Issuer = leaf.Issuer.String()
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{
+		DNSName:       domain,
+		Roots:         m.opts.Roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	})
+	check.Valid = err == nil
+	if err != nil {
+		check.Error = err.Error()
+	}
+	return check
+}
+
+// alert notifies once per crossed expiry threshold of a certificate, when it becomes invalid
+// and when the handshake starts failing
+func (m *CertMonitor) alert(proxy config.Proxy, check, prev db.CertCheck, seen bool) db.CertCheck {
+	if !check.HasCert() {
+		if !seen || prev.HasCert() {
+			m.notify(fmt.Sprintf("TLS handshake for %s with %s failed: %s", check.Domain, proxy.Name, check.Error))
+		}
+		return check
+	}
+	if seen && prev.NotAfter.Equal(check.NotAfter) {
+		check.AlertedDays = prev.AlertedDays
+	}
+
+	if !check.Valid && (!seen || prev.Valid || !prev.HasCert()) {
+		m.notify(fmt.Sprintf("Certificate of %s on %s is invalid: %s", check.Domain, proxy.Name, check.Error))
+	}
+
+	daysLeft := check.DaysLeft(check.CheckedAt)
+	crossed := 0
+	for _, days := range m.opts.AlertDays {
+		if daysLeft < days {
+			crossed = days
+		}
+	}
+	if crossed == 0 || (check.AlertedDays != 0 && crossed >= check.AlertedDays) {
+		return check
+	}
+	check.AlertedDays = crossed
+
+	expiry := check.NotAfter.Format(time.DateOnly)
+	if daysLeft < 0 {
+		m.notify(fmt.Sprintf("Certificate of %s on %s expired %s", check.Domain, proxy.Name, expiry))
+	} else {
+		m.notify(fmt.Sprintf("Certificate of %s on %s expires in %d days (%s)", check.Domain, proxy.Name, daysLeft, expiry))
+	}
+	return check
+}
+
+func (m *CertMonitor) notify(message string) {
+	log.Printf("certs: %s", message)
+	if m.notifier == nil {
+		return
+	}
+	if err := m.notifier.Notify(message); err != nil {
+		log.Printf("certs: failed to send notification: %v", err)
+	}
+}
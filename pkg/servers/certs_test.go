@@ -0,0 +1,143 @@
+package servers
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+)
+
+type messages []string
+
+func (m *messages) Notify(message string) error {
+	*m = append(*m, message)
+	return nil
+}
+
+func TestCertMonitor_RecordsAndAlerts(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	host, port := hostPort(t, srv.URL)
+	notAfter := srv.Certificate().NotAfter
+
+	storage := db.NewMemoryStorage()
+	_ = storage.SaveDnsStates([]db.DnsState{
+		{Domain: "example.com", IP: "203.0.113.1", ProxyHost: host, ObservedAt: time.Now()},
+		{Domain: "other.test", IP: "203.0.113.1", ProxyHost: host, ObservedAt: time.Now()},
+	})
+
+	portNum, _ := strconv.Atoi(port)
+	opts := CertOptionsFromConfig(config.Certs{Port: portNum})
+	opts.Roots = x509.NewCertPool()
+	opts.Roots.AddCert(srv.Certificate())
+
+	proxies := func() []config.Proxy { return []config.Proxy{{Name: "local", Address: host}} }
+	notified := &messages{}
+	m := NewCertMonitor(opts, time.Second, storage, proxies, notified)
+
+	now := notAfter.Add(-5*24*time.Hour - time.Hour)
+	m.now = func() time.Time { return now }
+
+	if err := m.CheckCerts(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	checks, _ := storage.GetCertChecks()
+	if len(checks) != 2 || !checks[0].Valid || checks[0].Domain != "example.com" || !checks[0].NotAfter.Equal(notAfter) || checks[0].AlertedDays != 7 {
+		t.Fatalf("Expected a valid example.com certificate alerted at 7 days, got %+v", checks)
+	}
+	if checks[1].Valid || !strings.Contains(checks[1].Error, "other.test") {
+		t.Errorf("Expected the certificate invalid for other.test, got %+v", checks[1])
+	}
+	if len(*notified) != 3 || !strings.Contains((*notified)[0], "expires in 5 days") || !strings.Contains((*notified)[1], "other.test on local is invalid") {
+		t.Errorf("Unexpected alerts %q", *notified)
+	}
+
+	// the same thresholds are not alerted again
+	if err := m.CheckCerts(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(*notified) != 3 {
+		t.Errorf("Expected no repeated alerts, got %q", *notified)
+	}
+
+	now = notAfter.Add(-time.Hour)
+	_ = m.CheckCerts(context.Background())
+	if len(*notified) != 5 || !strings.Contains((*notified)[3], "expires in 0 days") {
+		t.Errorf("Expected the 1 day threshold alerted, got %q", *notified)
+	}
+
+	checks, _ = storage.GetCertChecks()
+	if problem := CertProblem(checks[0], now, opts.FailoverDays); !strings.Contains(problem, "expires") {
+		t.Errorf("Expected an expiring certificate to block failover, got %q", problem)
+	}
+}
+
+func TestCertMonitor_AlertsFailedHandshakes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})) // plain HTTP fails the handshake
+	defer srv.Close()
+	host, port := hostPort(t, srv.URL)
+
+	storage := db.NewMemoryStorage()
+	_ = storage.SaveDnsStates([]db.DnsState{{Domain: "example.com", IP: "203.0.113.1", ProxyHost: host, ObservedAt: time.Now()}})
+
+	portNum, _ := strconv.Atoi(port)
+	proxies := func() []config.Proxy { return []config.Proxy{{Name: "local", Address: host}} }
+	notified := &messages{}
+	m := NewCertMonitor(CertOptionsFromConfig(config.Certs{Port: portNum}), time.Second, storage, proxies, notified)
+
+	_ = m.CheckCerts(context.Background())
+	_ = m.CheckCerts(context.Background())
+	if len(*notified) != 1 || !strings.Contains((*notified)[0], "TLS handshake for example.com with local failed") {
+		t.Errorf("Expected the failed handshake alerted once, got %q", *notified)
+	}
+
+	checks, _ := storage.GetCertChecks()
+	if problem := CertProblem(checks[0], time.Now(), 1); !strings.Contains(problem, "handshake") {
+		t.Errorf("Expected a failed handshake to block failover, got %q", problem)
+	}
+}
+
+func TestCertMonitor_ChecksStandbyProxies(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	host, port := hostPort(t, srv.URL)
+
+	storage := db.NewMemoryStorage()
+	_ = storage.SaveDomains([]db.DomainRow{{Domain: "example.com", CfApiToken: "token"}})
+	_ = storage.SaveDnsStates([]db.DnsState{{Domain: "example.com", IP: "203.0.113.9", ProxyHost: "active", ObservedAt: time.Now()}})
+
+	portNum, _ := strconv.Atoi(port)
+	opts := CertOptionsFromConfig(config.Certs{Port: portNum})
+	opts.Roots = x509.NewCertPool()
+	opts.Roots.AddCert(srv.Certificate())
+	proxies := func() []config.Proxy { return []config.Proxy{{Name: "standby", Address: host}} }
+	m := NewCertMonitor(opts, 0, storage, proxies, &messages{})
+
+	if err := m.CheckCerts(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	checks, _ := storage.GetCertChecks()
+	if len(checks) != 1 || checks[0].Host != host || checks[0].Domain != "example.com" || !checks[0].Valid {
+		t.Errorf("Expected the standby proxy checked with the stored domain, got %+v", checks)
+	}
+}
+
+func TestSample_RotatesDomains(t *testing.T) {
+	domains := []string{"a", "b", "c", "d", "e"}
+	if got := strings.Join(sample(domains, 2, 0), ","); got != "a,b" {
+		t.Errorf("Unexpected first sample %s", got)
+	}
+	if got := strings.Join(sample(domains, 2, 2), ","); got != "e,a" {
+		t.Errorf("Expected the sample to wrap around, got %s", got)
+	}
+	if got := sample(domains, 10, 3); len(got) != 5 {
+		t.Errorf("Expected all domains when fewer than the sample, got %v", got)
+	}
+}
@@ -0,0 +1,280 @@
+package servers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+)
+
+const (
+	CheckTCP    = "tcp"
+	CheckHTTP   = "http"
+	CheckCanary = "canary" // http through the proxy with the Host of a served domain
+
+	defaultCheckPath = "/"
+	maxCheckBody     = 1 << 20 // bytes of the response read for body matching
+)
+
+// Checker checks one server, a nil error means healthy.
+// The deadline of the context bounds the whole check.
+type Checker interface {
+	Check(ctx context.Context, host, port string) error
+}
+
+// Timings are the phases of a check
+type Timings struct {
+	Connect time.Duration // until the connection is open, including the TLS handshake
+	TTFB    time.Duration // until the first byte of the response, zero for TCP checks
+}
+
+// TimedChecker also measures the phases of a check, the monitor records them when available
+type TimedChecker interface {
+	Checker
+	CheckTimed(ctx context.Context, host, port string) (Timings, error)
+}
+
+// CheckOptions are the parsed [Servers.Check] settings
+type CheckOptions struct {
+	Type       string
+	Path       string
+	Method     string
+	StatusMin  int
+	StatusMax  int
+	Contains   string
+	Regex      *regexp.Regexp
+	HostHeader string
+	TLS        *tls.Config
+
+	CanaryDomain     string
+	CanaryHostingIPs []string
+	CanaryHeader     string
+	Canaries         CanarySource // domains of the proxies, nil only uses CanaryDomain
+}
+
+// CheckOptionsFromConfig validates the config and fills in defaults
+func CheckOptionsFromConfig(c config.Check) (CheckOptions, error) {
+	opts := CheckOptions{
+		Type:       strings.ToLower(c.Type),
+		Path:       c.Path,
+		Method:     strings.ToUpper(c.Method),
+		Contains:   c.BodyContains,
+		HostHeader: c.HostHeader,
+		StatusMin:  200,
+		StatusMax:  399,
+
+		CanaryDomain:     c.CanaryDomain,
+		CanaryHostingIPs: c.CanaryHostingIPs,
+		CanaryHeader:     c.CanaryHeader,
+	}
+
+	switch opts.Type {
+	case "":
+		opts.Type = CheckTCP
+	case CheckTCP, CheckHTTP, CheckCanary:
+	default:
+		return opts, fmt.Errorf("servers: unknown check type %q, expected tcp, http or canary", c.Type)
+	}
+
+	if opts.Path == "" {
+		opts.Path = defaultCheckPath
+	}
+	if !strings.HasPrefix(opts.Path, "/") {
+		opts.Path = "/" + opts.Path
+	}
+	if opts.Method == "" {
+		opts.Method = http.MethodGet
+	}
+
+	if c.ExpectedStatus != "" {
+		var err error
+		if opts.StatusMin, opts.StatusMax, err = parseStatusRange(c.ExpectedStatus); err != nil {
+			return opts, err
+		}
+	}
+
+	if c.BodyRegex != "" {
+		re, err := regexp.Compile(c.BodyRegex)
+		if err != nil {
+			return opts, fmt.Errorf("servers: check body regex: %w", err)
+		}
+		opts.Regex = re
+	}
+
+	opts.TLS = &tls.Config{
+		InsecureSkipVerify: c.TLSSkipVerify,
+		ServerName:         c.TLSServerName,
+	}
+	if c.TLSCAFile != "" {
+		pem, err := os.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return opts, fmt.Errorf("servers: check CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return opts, fmt.Errorf("servers: no certificates in %s", c.TLSCAFile)
+		}
+		opts.TLS.RootCAs = pool
+	}
+
+	return opts, nil
+}
+
+// parseStatusRange reads "200" or "200-299"
+func parseStatusRange(s string) (int, int, error) {
+	from, to, isRange := strings.Cut(s, "-")
+	min, err := strconv.Atoi(strings.TrimSpace(from))
+	if err != nil {
+		return 0, 0, fmt.Errorf("servers: expected status %q: %w", s, err)
+	}
+	max := min
+	if isRange {
+		if max, err = strconv.Atoi(strings.TrimSpace(to)); err != nil {
+			return 0, 0, fmt.Errorf("servers: expected status %q: %w", s, err)
+		}
+	}
+	if min < 100 || max > 599 || min > max {
+		return 0, 0, fmt.Errorf("servers: expected status %q is not a valid range", s)
+	}
+	return min, max, nil
+}
+
+// NewChecker returns the checker for a server with the schema of its proxy URL
+func NewChecker(opts CheckOptions, schema string) Checker {
+	switch opts.Type {
+	case CheckHTTP:
+		return NewHTTPChecker(opts, schema == "https")
+	case CheckCanary:
+		return NewCanaryChecker(opts, schema == "https")
+	}
+	return TCPChecker{}
+}
+
+// TCPChecker only opens a connection
+type TCPChecker struct{}
+
+var _ TimedChecker = TCPChecker{}
+
+func (c TCPChecker) Check(ctx context.Context, host, port string) error {
+	_, err := c.CheckTimed(ctx, host, port)
+	return err
+}
+
+func (TCPChecker) CheckTimed(ctx context.Context, host, port string) (Timings, error) {
+	start := time.Now()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return Timings{}, err
+	}
+	timings := Timings{Connect: time.Since(start)}
+	return timings, conn.Close()
+}
+
+// HTTPChecker sends a request and matches the status and body of the response
+type HTTPChecker struct {
+	opts   CheckOptions
+	scheme string
+	client *http.Client
+}
+
+func NewHTTPChecker(opts CheckOptions, useTLS bool) *HTTPChecker {
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+
+	return &HTTPChecker{
+		opts:   opts,
+		scheme: scheme,
+		client: newCheckClient(opts.TLS),
+	}
+}
+
+func newCheckClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   tlsConfig,
+			DisableKeepAlives: true, // every check opens a new connection like a visitor would
+		},
+		// a redirect is an answer of the proxy, its status is matched as is
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+var _ TimedChecker = (*HTTPChecker)(nil)
+
+func (c *HTTPChecker) Check(ctx context.Context, host, port string) error {
+	_, err := c.CheckTimed(ctx, host, port)
+	return err
+}
+
+func (c *HTTPChecker) CheckTimed(ctx context.Context, host, port string) (Timings, error) {
+	resp, timings, err := c.request(ctx, c.client, host, port, c.opts.HostHeader)
+	if err != nil {
+		return timings, err
+	}
+	defer resp.Body.Close()
+
+	_, err = c.matchResponse(resp, false)
+	return timings, err
+}
+
+// request sends the check request with the host header, if set, and measures it
+func (c *HTTPChecker) request(ctx context.Context, client *http.Client, host, port, hostHeader string) (*http.Response, Timings, error) {
+	var timings Timings
+	start := time.Now()
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn:              func(httptrace.GotConnInfo) { timings.Connect = time.Since(start) },
+		GotFirstResponseByte: func() { timings.TTFB = time.Since(start) },
+	})
+
+	url := fmt.Sprintf("%s://%s%s", c.scheme, net.JoinHostPort(host, port), c.opts.Path)
+	req, err := http.NewRequestWithContext(ctx, c.opts.Method, url, nil)
+	if err != nil {
+		return nil, timings, err
+	}
+	if hostHeader != "" {
+		req.Host = hostHeader
+	}
+
+	resp, err := client.Do(req)
+	return resp, timings, err
+}
+
+// matchResponse matches the status and body of the response and returns the body
+// when it was read for matching or when readBody is set
+func (c *HTTPChecker) matchResponse(resp *http.Response, readBody bool) ([]byte, error) {
+	if resp.StatusCode < c.opts.StatusMin || resp.StatusCode > c.opts.StatusMax {
+		return nil, fmt.Errorf("unexpected status %d, expected %d-%d", resp.StatusCode, c.opts.StatusMin, c.opts.StatusMax)
+	}
+
+	if !readBody && c.opts.Contains == "" && c.opts.Regex == nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCheckBody))
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	if c.opts.Contains != "" && !strings.Contains(string(body), c.opts.Contains) {
+		return body, fmt.Errorf("body does not contain %q", c.opts.Contains)
+	}
+	if c.opts.Regex != nil && !c.opts.Regex.Match(body) {
+		return body, fmt.Errorf("body does not match %q", c.opts.Regex)
+	}
+	return body, nil
+}
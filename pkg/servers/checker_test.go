@@ -0,0 +1,148 @@
+package servers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+)
+
+func hostPort(t *testing.T, url string) (string, string) {
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(strings.TrimPrefix(url, "http://"), "https://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return host, port
+}
+
+func TestCheckOptionsFromConfig(t *testing.T) {
+	opts, err := CheckOptionsFromConfig(config.Check{})
+	if err != nil || opts.Type != CheckTCP || opts.StatusMin != 200 || opts.StatusMax != 399 || opts.Path != "/" {
+		t.Errorf("Unexpected defaults %+v, %v", opts, err)
+	}
+
+	opts, err = CheckOptionsFromConfig(config.Check{Type: "HTTP", ExpectedStatus: "204", Path: "health", Method: "head"})
+	if err != nil || opts.StatusMin != 204 || opts.StatusMax != 204 || opts.Path != "/health" || opts.Method != http.MethodHead {
+		t.Errorf("Unexpected options %+v, %v", opts, err)
+	}
+
+	for _, c := range []config.Check{
+		{Type: "icmp"},
+		{ExpectedStatus: "300-200"},
+		{ExpectedStatus: "ok"},
+		{BodyRegex: "("},
+		{TLSCAFile: "/nonexistent/ca.pem"},
+	} {
+		if _, err := CheckOptionsFromConfig(c); err == nil {
+			t.Errorf("Expected an error for %+v", c)
+		}
+	}
+}
+
+func TestHTTPChecker(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			if r.Host != "proxy.example" {
+				w.WriteHeader(http.StatusMisdirectedRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"status":"ok","version":"1.2"}`))
+		case "/redirect":
+			http.Redirect(w, r, "/health", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	host, port := hostPort(t, srv.URL)
+
+	tests := []struct {
+		name string
+		cfg  config.Check
+		ok   bool
+	}{
+		{"status and body", config.Check{Type: "http", Path: "/health", HostHeader: "proxy.example", BodyContains: `"ok"`, BodyRegex: `version":"1\.\d+`}, true},
+		{"wrong host header", config.Check{Type: "http", Path: "/health"}, false},
+		{"body mismatch", config.Check{Type: "http", Path: "/health", HostHeader: "proxy.example", BodyContains: "healthy"}, false},
+		{"regex mismatch", config.Check{Type: "http", Path: "/health", HostHeader: "proxy.example", BodyRegex: `^ok$`}, false},
+		{"unavailable", config.Check{Type: "http", Path: "/wedged"}, false},
+		{"redirect is not followed", config.Check{Type: "http", Path: "/redirect", ExpectedStatus: "200"}, false},
+		{"redirect in range", config.Check{Type: "http", Path: "/redirect"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := CheckOptionsFromConfig(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = NewChecker(opts, "http").Check(context.Background(), host, port)
+			if (err == nil) != tt.ok {
+				t.Errorf("Expected ok %v, got %v", tt.ok, err)
+			}
+		})
+	}
+}
+
+func TestHTTPChecker_TLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	host, port := hostPort(t, srv.URL)
+
+	opts, _ := CheckOptionsFromConfig(config.Check{Type: "http"})
+	if err := NewChecker(opts, "https").Check(context.Background(), host, port); err == nil {
+		t.Error("Expected an untrusted certificate to fail the check")
+	}
+
+	opts, _ = CheckOptionsFromConfig(config.Check{Type: "http", TLSSkipVerify: true})
+	if err := NewChecker(opts, "https").Check(context.Background(), host, port); err != nil {
+		t.Errorf("Expected the check to pass without verification, got %v", err)
+	}
+}
+
+func TestTCPChecker_ReportsDialError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	if err := (TCPChecker{}).Check(context.Background(), host, port); err != nil {
+		t.Errorf("Expected listening port to be up, got %v", err)
+	}
+
+	l.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := (TCPChecker{}).Check(ctx, host, port); err == nil {
+		t.Error("Expected an error for a closed port")
+	}
+
+	if up, err := IsServerReachable(host, port, time.Second); up || err == nil {
+		t.Errorf("Expected IsServerReachable to return the dial error, got %v, %v", up, err)
+	}
+}
+
+func TestHTTPChecker_MeasuresTimings(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	host, port := hostPort(t, srv.URL)
+
+	opts, _ := CheckOptionsFromConfig(config.Check{Type: "http"})
+	timings, err := NewHTTPChecker(opts, false).CheckTimed(context.Background(), host, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timings.Connect <= 0 || timings.TTFB < 20*time.Millisecond || timings.TTFB < timings.Connect {
+		t.Errorf("Expected connect before a TTFB of at least 20ms, got %+v", timings)
+	}
+}
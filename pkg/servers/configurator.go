@@ -3,7 +3,9 @@ package servers
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -21,15 +23,23 @@ type ProxyConfigUpdater struct {
 	UpdateInterval time.Duration
 	Endpoint       string
 	Notifier       Notifier
+	Proxies        ProxyDirectory
 }
 
 type Domain struct {
-	Domain string `json:"domain"`
+	Domain   string    `json:"domain"`
+	IP       string    `json:"ip"` // primary backend, kept for proxies reading a single IP
+	Backends []Backend `json:"backends"`
+}
+
+type Backend struct {
 	IP     string `json:"ip"`
+	Weight int    `json:"weight,omitempty"`
 }
 
 type Server struct {
 	Address string
+	Scheme  string
 }
 
 func NewProxyConfigUpdater(storage db.Storage, config *config.Servers, notifier Notifier) *ProxyConfigUpdater {
@@ -40,6 +50,7 @@ func NewProxyConfigUpdater(storage db.Storage, config *config.Servers, notifier
 		UpdateInterval: interval,
 		Endpoint:       config.DomainUpdateEndpoint,
 		Notifier:       notifier,
+		Proxies:        *config,
 	}
 }
 
@@ -64,22 +75,84 @@ func (p *ProxyConfigUpdater) Start(ctx context.Context) {
 	}()
 }
 
-func (p *ProxyConfigUpdater) getAllDomains() ([]Domain, error) {
-	log.Println("configurator: loading domains")
-	domainsRows, err := p.Storage.GetAllDomains()
+// UsePool reads proxies from the pool and sends domains to added and enabled
+// proxies at once instead of after the next interval
+func (p *ProxyConfigUpdater) UsePool(pool *Pool) {
+	p.Proxies = pool
+	pool.Subscribe(func(e PoolEvent) {
+		switch e.Type {
+		case PoolAdded, PoolEnabled, PoolUpdated:
+			if e.Enabled {
+				go p.pushTo(e.Proxy)
+			}
+		}
+	})
+}
+
+// pushTo sends all domains to one proxy
+func (p *ProxyConfigUpdater) pushTo(proxy config.Proxy) {
+	domains, err := p.getAllDomains()
 	if err != nil {
-		return nil, err
+		log.Println("configurator: error loading domains", err)
+		return
+	}
+	if err := p.sendUpdateToServer(Server{Address: proxy.HostPort(), Scheme: proxy.Scheme}, domains); err != nil {
+		log.Printf("Failed to update server %s: %v\n", proxy.HostPort(), err)
+	}
+}
+
+// ConfigVersionHeader carries the version of the domain config sent to a proxy,
+// the proxy writes it to the config_version_file of its agent once applied
+const ConfigVersionHeader = "X-Config-Version"
+
+// ConfigVersion identifies a domain config by its payload
+func ConfigVersion(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:6])
+}
+
+// DomainConfigVersion returns the version of the domain config the proxies are sent now
+func DomainConfigVersion(storage db.Storage) (string, error) {
+	domains, err := domainConfig(storage)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(domains)
+	if err != nil {
+		return "", err
 	}
+	return ConfigVersion(payload), nil
+}
+
+func (p *ProxyConfigUpdater) getAllDomains() ([]Domain, error) {
+	log.Println("configurator: loading domains")
+	return domainConfig(p.Storage)
+}
+
+// domainConfig builds the domains sent to the proxies, domains without a backend are left out
+func domainConfig(storage db.Storage) ([]Domain, error) {
 	domains := []Domain{}
 
-	for _, dr := range domainsRows {
-		if dr.HostingIP == "" {
-			continue
+	err := storage.ForEachDomain(func(dr db.DomainRow) error {
+		backends := []Backend{}
+		for _, b := range dr.Backends() {
+			if b.IP == "" {
+				continue
+			}
+			backends = append(backends, Backend{IP: b.IP, Weight: b.Weight})
+		}
+		if len(backends) == 0 {
+			return nil
 		}
 		domains = append(domains, Domain{
-			Domain: dr.Domain,
-			IP:     dr.HostingIP,
+			Domain:   dr.Domain,
+			IP:       backends[0].IP,
+			Backends: backends,
 		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return domains, nil
@@ -97,6 +170,7 @@ func (p *ProxyConfigUpdater) getActiveProxyServers() ([]Server, error) {
 	for _, sr := range serversRows {
 		s = append(s, Server{
 			Address: net.JoinHostPort(sr.Host, sr.CheckPort),
+			Scheme:  p.Proxies.ProxyByAddress(sr.Host).Scheme,
 		})
 	}
 
@@ -153,8 +227,7 @@ func (p *ProxyConfigUpdater) sendUpdateToServer(server Server, domains []Domain)
 		// }
 		// batch := domains[i:end]
 
-		// TODO: could be http or https?
-		url := "http://" + server.Address + p.Endpoint
+		url := server.Scheme + "://" + server.Address + p.Endpoint
 
 		// Marshal the batch of domains into JSON.
 		// payload, err := json.Marshal(batch)
@@ -170,6 +243,7 @@ func (p *ProxyConfigUpdater) sendUpdateToServer(server Server, domains []Domain)
 			return fmt.Errorf("failed to create request: %w", err)
 		}
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(ConfigVersionHeader, ConfigVersion(payload))
 
 		resp, err := client.Do(req)
 		if err != nil {
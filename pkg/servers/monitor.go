@@ -3,15 +3,24 @@ package servers
 import (
 	"context"
 	"log"
+	"strconv"
+	"sync"
 	"time"
+
+	"go-cf-zone-switch/pkg/config"
 )
 
+const defaultCheckConcurrency = 10
+
 type ServerStatus struct {
 	ID        string
 	Host      string
 	Port      string
 	IsUp      bool
 	LastCheck time.Time
+	Latency   time.Duration // time the check took
+	Connect   time.Duration // time to open the connection, zero when not measured
+	TTFB      time.Duration // time to the first response byte, zero when not measured
 	Error     string
 }
 
@@ -19,31 +28,124 @@ type StatusReceiver interface {
 	ReceiveStatus(statuses []ServerStatus) error
 }
 
+type monitoredServer struct {
+	Host, Port, ID, Schema string
+	checker                Checker
+	proxy                  config.Proxy // the pooled proxy the server was made from
+}
+
 type ServerMonitor struct {
-	servers        []struct{ Host, Port, ID, Schema string }
+	servers        []monitoredServer
 	checkInterval  time.Duration
 	timeout        time.Duration
+	concurrency    int
+	check          CheckOptions
 	statusReceiver StatusReceiver
 	notifier       Notifier
+
+	pool    *Pool
+	changed chan struct{} // the pool changed, check without waiting for the ticker
 }
 
-func NewServerMonitoring(checkInterval, timeout time.Duration, reporter StatusReceiver, notifier Notifier) *ServerMonitor {
+// NewServerMonitoring checks up to concurrency servers at once, 0 uses the default,
+// and each check for up to timeout, 0 uses the default of the config
+func NewServerMonitoring(checkInterval, timeout time.Duration, concurrency int, check CheckOptions, reporter StatusReceiver, notifier Notifier) *ServerMonitor {
+	if concurrency <= 0 {
+		concurrency = defaultCheckConcurrency
+	}
+	if timeout <= 0 {
+		timeout = config.DefaultTimeoutSec * time.Second
+	}
+
 	return &ServerMonitor{
-		servers:        []struct{ Host, Port, ID, Schema string }{},
+		servers:        []monitoredServer{},
 		checkInterval:  checkInterval,
 		timeout:        timeout,
+		concurrency:    concurrency,
+		check:          check,
 		statusReceiver: reporter,
 		notifier:       notifier,
 	}
 }
 
+// AddServer monitors the server with the configured check, schema is "http" or "https"
 func (m *ServerMonitor) AddServer(host, port, id, schema string) {
-	m.servers = append(m.servers, struct {
-		Host   string
-		Port   string
-		ID     string
-		Schema string
-	}{host, port, id, schema})
+	m.servers = append(m.servers, monitoredServer{
+		Host:    host,
+		Port:    port,
+		ID:      id,
+		Schema:  schema,
+		checker: NewChecker(m.check, schema),
+	})
+}
+
+// AddProxy monitors a configured proxy, its check type overrides the global one
+func (m *ServerMonitor) AddProxy(p config.Proxy) {
+	m.servers = append(m.servers, m.proxyServer(p))
+}
+
+func (m *ServerMonitor) proxyServer(p config.Proxy) monitoredServer {
+	check := m.check
+	if p.CheckType != "" {
+		check.Type = p.CheckType
+	}
+
+	return monitoredServer{
+		Host:    p.Address,
+		Port:    strconv.Itoa(p.Port),
+		ID:      p.Name,
+		Schema:  p.Scheme,
+		checker: NewChecker(check, p.Scheme),
+		proxy:   p,
+	}
+}
+
+// UsePool monitors the enabled proxies of the pool instead of added servers.
+// Changes of the pool are picked up at once. It must be called before Start.
+func (m *ServerMonitor) UsePool(pool *Pool) {
+	m.pool = pool
+	m.changed = make(chan struct{}, 1)
+	pool.Subscribe(func(PoolEvent) {
+		select {
+		case m.changed <- struct{}{}:
+		default: // a check is already due
+		}
+	})
+}
+
+// syncPool rebuilds the server list from the pool, checkers of unchanged proxies are kept
+func (m *ServerMonitor) syncPool() {
+	current := map[string]monitoredServer{}
+	for _, s := range m.servers {
+		current[s.ID] = s
+	}
+
+	servers := []monitoredServer{}
+	for _, p := range m.pool.Enabled() {
+		if s, ok := current[p.Name]; ok && s.proxy.Equal(p) {
+			servers = append(servers, s)
+			continue
+		}
+		servers = append(servers, m.proxyServer(p))
+	}
+	m.servers = servers
+}
+
+// stillEnabled drops statuses of proxies disabled or removed during the round,
+// so their deleted server rows are not written again
+func (m *ServerMonitor) stillEnabled(statuses []ServerStatus) []ServerStatus {
+	enabled := map[string]bool{}
+	for _, p := range m.pool.Enabled() {
+		enabled[p.Address] = true
+	}
+
+	filtered := make([]ServerStatus, 0, len(statuses))
+	for _, s := range statuses {
+		if enabled[s.Host] {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered
 }
 
 func (m *ServerMonitor) Start(ctx context.Context) {
@@ -57,6 +159,8 @@ func (m *ServerMonitor) Start(ctx context.Context) {
 			select {
 			case <-ticker.C:
 				m.checkServers(ctx)
+			case <-m.changed:
+				m.checkServers(ctx)
 			case <-ctx.Done():
 				log.Println("monitor: stopped due to context cancellation")
 				return
@@ -65,34 +169,44 @@ func (m *ServerMonitor) Start(ctx context.Context) {
 	}()
 }
 
+// checkServers checks all servers concurrently and reports the round as one batch
 func (m *ServerMonitor) checkServers(ctx context.Context) {
+	if m.pool != nil {
+		m.syncPool()
+	}
+
 	if len(m.servers) == 0 {
 		log.Println("monitor: No servers configured for monitoring")
 		return
 	}
 
-	statuses := make([]ServerStatus, 0, len(m.servers))
+	statuses := make([]ServerStatus, len(m.servers))
+	semaphore := make(chan struct{}, m.concurrency)
+	var wg sync.WaitGroup
 
-	for _, server := range m.servers {
+	for i, server := range m.servers {
 		select {
 		case <-ctx.Done():
+			wg.Wait()
 			return
-		default:
-			status := ServerStatus{
-				Host:      server.Host,
-				Port:      server.Port,
-				LastCheck: time.Now(),
-			}
+		case semaphore <- struct{}{}: // acquire
+		}
 
-			isUp, err := IsServerReachable(server.Host, server.Port, m.timeout)
-			status.IsUp = isUp
+		wg.Add(1)
+		go func(i int, server monitoredServer) {
+			defer wg.Done()
+			defer func() { <-semaphore }() // release
 
-			if err != nil {
-				status.Error = err.Error()
-			}
+			statuses[i] = m.checkServer(ctx, server)
+		}(i, server)
+	}
 
-			statuses = append(statuses, status)
-		}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+	if m.pool != nil {
+		statuses = m.stillEnabled(statuses)
 	}
 
 	if err := m.statusReceiver.ReceiveStatus(statuses); err != nil {
@@ -100,3 +214,30 @@ func (m *ServerMonitor) checkServers(ctx context.Context) {
 		log.Printf("monitor: Failed to report server statuses: %v", err)
 	}
 }
+
+// checkServer runs one check with its own deadline, a slow server does not delay the others
+func (m *ServerMonitor) checkServer(ctx context.Context, server monitoredServer) ServerStatus {
+	status := ServerStatus{
+		Host:      server.Host,
+		Port:      server.Port,
+		LastCheck: time.Now(),
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	var err error
+	if timed, ok := server.checker.(TimedChecker); ok {
+		var timings Timings
+		timings, err = timed.CheckTimed(checkCtx, server.Host, server.Port)
+		status.Connect, status.TTFB = timings.Connect, timings.TTFB
+	} else {
+		err = server.checker.Check(checkCtx, server.Host, server.Port)
+	}
+	status.IsUp = err == nil
+	status.Latency = time.Since(status.LastCheck)
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
@@ -0,0 +1,128 @@
+package servers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+)
+
+type checkFunc func(ctx context.Context, host, port string) error
+
+func (f checkFunc) Check(ctx context.Context, host, port string) error {
+	return f(ctx, host, port)
+}
+
+type batchReceiver struct {
+	mu      sync.Mutex
+	batches [][]ServerStatus
+}
+
+func (r *batchReceiver) ReceiveStatus(statuses []ServerStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, statuses)
+	return nil
+}
+
+func TestServerMonitor_ChecksConcurrently(t *testing.T) {
+	receiver := &batchReceiver{}
+	m := NewServerMonitoring(time.Minute, 50*time.Millisecond, 2, CheckOptions{}, receiver, nil)
+
+	var running, maxRunning int32
+	check := checkFunc(func(ctx context.Context, host, port string) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+
+		if host == "slow" {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+
+	for _, host := range []string{"a", "slow", "b", "c", "d"} {
+		m.AddServer(host, "80", host, "http")
+		m.servers[len(m.servers)-1].checker = check
+	}
+
+	start := time.Now()
+	m.checkServers(context.Background())
+
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("Expected the slow server not to delay the round, took %v", elapsed)
+	}
+	if maxRunning != 2 {
+		t.Errorf("Expected at most 2 checks at once, got %d", maxRunning)
+	}
+
+	if len(receiver.batches) != 1 || len(receiver.batches[0]) != 5 {
+		t.Fatalf("Expected one batch of 5 statuses, got %+v", receiver.batches)
+	}
+	for i, host := range []string{"a", "slow", "b", "c", "d"} {
+		status := receiver.batches[0][i]
+		if status.Host != host || status.IsUp != (host != "slow") {
+			t.Errorf("Unexpected status %+v", status)
+		}
+	}
+	if slow := receiver.batches[0][1]; slow.Error != context.DeadlineExceeded.Error() {
+		t.Errorf("Expected the deadline as error of the slow server, got %+v", slow)
+	}
+}
+
+func TestServerMonitor_CanceledRoundIsNotReported(t *testing.T) {
+	receiver := &batchReceiver{}
+	m := NewServerMonitoring(time.Minute, time.Second, 1, CheckOptions{}, receiver, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.AddServer("a", "80", "a", "http")
+	m.servers[0].checker = checkFunc(func(ctx context.Context, host, port string) error {
+		cancel()
+		return ctx.Err()
+	})
+
+	m.checkServers(ctx)
+
+	if len(receiver.batches) != 0 {
+		t.Errorf("Expected no report after cancellation, got %+v", receiver.batches)
+	}
+}
+
+func TestServerMonitor_UnsetTimeoutChecksReachableProxy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	host, port := hostPort(t, srv.URL)
+
+	cfg := config.Servers{}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	receiver := &batchReceiver{}
+	m := NewServerMonitoring(time.Minute, time.Duration(cfg.TimeoutSec)*time.Second, 0, CheckOptions{}, receiver, nil)
+	m.AddServer(host, port, host, "http")
+	m.checkServers(context.Background())
+
+	if len(receiver.batches) != 1 || !receiver.batches[0][0].IsUp {
+		t.Errorf("Expected the proxy up without timeout_sec, got %+v", receiver.batches)
+	}
+
+	unset := &batchReceiver{}
+	m = NewServerMonitoring(time.Minute, 0, 0, CheckOptions{}, unset, nil)
+	m.AddServer(host, port, host, "http")
+	m.checkServers(context.Background())
+	if len(unset.batches) != 1 || !unset.batches[0][0].IsUp {
+		t.Errorf("Expected the proxy up with a zero timeout, got %+v", unset.batches)
+	}
+}
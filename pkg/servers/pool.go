@@ -0,0 +1,307 @@
+package servers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+)
+
+var (
+	ErrProxyExists   = errors.New("servers: proxy already exists")
+	ErrProxyNotFound = errors.New("servers: proxy not found")
+	ErrInvalidProxy  = errors.New("servers: invalid proxy")
+)
+
+const (
+	PoolAdded    = "added"
+	PoolUpdated  = "updated"
+	PoolRemoved  = "removed"
+	PoolEnabled  = "enabled"
+	PoolDisabled = "disabled"
+)
+
+// ProxyDirectory resolves a stored server address to its proxy,
+// implemented by config.Servers for a fixed list and by Pool
+type ProxyDirectory interface {
+	ProxyByAddress(address string) config.Proxy
+}
+
+var _ ProxyDirectory = config.Servers{}
+
+// ProxyLookup finds the proxy on an address, ok is false when no proxy uses it
+type ProxyLookup interface {
+	LookupProxy(address string) (config.Proxy, bool)
+}
+
+var _ ProxyLookup = config.Servers{}
+
+// PoolEvent is a change of the pool, Proxy and Enabled are the state after it
+// or of the removed proxy
+type PoolEvent struct {
+	Type    string
+	Proxy   config.Proxy
+	Enabled bool
+}
+
+// Pool is the set of proxies changed at runtime and persisted in the storage.
+// Proxies from the config are replaced on Reconcile, proxies added with Add stay.
+type Pool struct {
+	storage db.Storage
+
+	mu          sync.RWMutex
+	proxies     map[string]db.PoolProxy // key: name
+	subscribers []func(PoolEvent)
+}
+
+var _ ProxyDirectory = (*Pool)(nil)
+var _ ProxyLookup = (*Pool)(nil)
+
+// NewPool loads the persisted pool
+func NewPool(storage db.Storage) (*Pool, error) {
+	rows, err := storage.GetProxyPool()
+	if err != nil {
+		return nil, fmt.Errorf("servers: load proxy pool: %w", err)
+	}
+
+	p := &Pool{storage: storage, proxies: map[string]db.PoolProxy{}}
+	for _, row := range rows {
+		p.proxies[row.Proxy.Name] = row
+	}
+	return p, nil
+}
+
+// Subscribe calls fn after every change, fn must not block
+func (p *Pool) Subscribe(fn func(PoolEvent)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.subscribers = append(p.subscribers, fn)
+}
+
+func (p *Pool) publish(events []PoolEvent) {
+	p.mu.RLock()
+	subscribers := p.subscribers
+	p.mu.RUnlock()
+
+	for _, e := range events {
+		log.Printf("pool: proxy %s %s", e.Proxy.Name, e.Type)
+		for _, fn := range subscribers {
+			fn(e)
+		}
+	}
+}
+
+// List returns all proxies sorted by name
+func (p *Pool) List() []db.PoolProxy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	list := make([]db.PoolProxy, 0, len(p.proxies))
+	for _, row := range p.proxies {
+		list = append(list, row)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Proxy.Name < list[j].Proxy.Name })
+	return list
+}
+
+// Enabled returns the proxies to monitor sorted by name
+func (p *Pool) Enabled() []config.Proxy {
+	proxies := []config.Proxy{}
+	for _, row := range p.List() {
+		if row.Enabled {
+			proxies = append(proxies, config.Proxy(row.Proxy))
+		}
+	}
+	return proxies
+}
+
+// ProxyByAddress returns the pooled proxy on the address, unknown addresses stand for themselves
+func (p *Pool) ProxyByAddress(address string) config.Proxy {
+	if proxy, ok := p.LookupProxy(address); ok {
+		return proxy
+	}
+	return config.Servers{}.ProxyByAddress(address)
+}
+
+// LookupProxy returns the pooled proxy on the address, enabled or not
+func (p *Pool) LookupProxy(address string) (config.Proxy, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, row := range p.proxies {
+		if row.Proxy.Address == address {
+			return config.Proxy(row.Proxy), true
+		}
+	}
+	return config.Proxy{}, false
+}
+
+// checkAddress fails when another proxy than name uses the address, it must be called with the lock held
+func (p *Pool) checkAddress(name, address string) error {
+	for _, row := range p.proxies {
+		if row.Proxy.Name != name && row.Proxy.Address == address {
+			return fmt.Errorf("%w: %s uses address %s", ErrProxyExists, row.Proxy.Name, address)
+		}
+	}
+	return nil
+}
+
+// save persists the row and replaces it in memory, it must be called with the lock held
+func (p *Pool) save(row db.PoolProxy) error {
+	row.UpdatedAt = time.Now()
+	if err := p.storage.SavePoolProxy(row); err != nil {
+		return err
+	}
+	p.proxies[row.Proxy.Name] = row
+	return nil
+}
+
+// Add validates the proxy and adds it enabled
+func (p *Pool) Add(proxy config.Proxy) error {
+	if err := proxy.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProxy, err)
+	}
+
+	p.mu.Lock()
+	if _, ok := p.proxies[proxy.Name]; ok {
+		p.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrProxyExists, proxy.Name)
+	}
+	if err := p.checkAddress(proxy.Name, proxy.Address); err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	err := p.save(db.PoolProxy{Proxy: db.ProxyRow(proxy), Enabled: true, Source: db.PoolSourceApi})
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	p.publish([]PoolEvent{{Type: PoolAdded, Proxy: proxy, Enabled: true}})
+	return nil
+}
+
+// Remove deletes the proxy with its stored health and check history
+func (p *Pool) Remove(name string) error {
+	p.mu.Lock()
+	row, ok := p.proxies[name]
+	if !ok {
+		p.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrProxyNotFound, name)
+	}
+	err := p.storage.DeletePoolProxy(name)
+	if err == nil {
+		delete(p.proxies, name)
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	p.publish([]PoolEvent{{Type: PoolRemoved, Proxy: config.Proxy(row.Proxy), Enabled: row.Enabled}})
+	return nil
+}
+
+// SetEnabled starts or stops monitoring the proxy. A disabled proxy loses its
+// stored health, so it is neither a switch target nor sent domain updates.
+func (p *Pool) SetEnabled(name string, enabled bool) error {
+	p.mu.Lock()
+	row, ok := p.proxies[name]
+	if !ok {
+		p.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrProxyNotFound, name)
+	}
+	if row.Enabled == enabled {
+		p.mu.Unlock()
+		return nil
+	}
+
+	row.Enabled = enabled
+	err := p.save(row)
+	if err == nil && !enabled {
+		err = p.storage.DeleteProxyServer(row.Proxy.Address)
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	event := PoolEvent{Type: PoolEnabled, Proxy: config.Proxy(row.Proxy), Enabled: enabled}
+	if !enabled {
+		event.Type = PoolDisabled
+	}
+	p.publish([]PoolEvent{event})
+	return nil
+}
+
+// Reconcile replaces the proxies from the config with the given validated list.
+// Proxies added at runtime stay, a config proxy with the same name takes one over.
+// Enabled states are kept.
+func (p *Pool) Reconcile(proxies []config.Proxy) error {
+	p.mu.Lock()
+
+	wanted := map[string]config.Proxy{}
+	for _, proxy := range proxies {
+		wanted[proxy.Name] = proxy
+	}
+	addresses := map[string]string{}
+	for _, proxy := range proxies {
+		addresses[proxy.Address] = proxy.Name
+	}
+	for name, row := range p.proxies {
+		if _, ok := wanted[name]; ok || row.Source == db.PoolSourceConfig {
+			continue
+		}
+		if other, ok := addresses[row.Proxy.Address]; ok {
+			p.mu.Unlock()
+			return fmt.Errorf("%w: %s added at runtime uses address %s of %s", ErrProxyExists, name, row.Proxy.Address, other)
+		}
+	}
+
+	events := []PoolEvent{}
+	err := func() error {
+		for name, row := range p.proxies {
+			if _, ok := wanted[name]; ok || row.Source != db.PoolSourceConfig {
+				continue
+			}
+			if err := p.storage.DeletePoolProxy(name); err != nil {
+				return err
+			}
+			delete(p.proxies, name)
+			events = append(events, PoolEvent{Type: PoolRemoved, Proxy: config.Proxy(row.Proxy), Enabled: row.Enabled})
+		}
+
+		for _, proxy := range proxies {
+			row, ok := p.proxies[proxy.Name]
+			switch {
+			case !ok:
+				row = db.PoolProxy{Proxy: db.ProxyRow(proxy), Enabled: true, Source: db.PoolSourceConfig}
+				events = append(events, PoolEvent{Type: PoolAdded, Proxy: proxy, Enabled: true})
+			case config.Proxy(row.Proxy).Equal(proxy) && row.Source == db.PoolSourceConfig:
+				continue
+			default:
+				if row.Proxy.Address != proxy.Address {
+					if err := p.storage.DeleteProxyServer(row.Proxy.Address); err != nil {
+						return err
+					}
+				}
+				row.Proxy = db.ProxyRow(proxy)
+				row.Source = db.PoolSourceConfig
+				events = append(events, PoolEvent{Type: PoolUpdated, Proxy: proxy, Enabled: row.Enabled})
+			}
+			if err := p.save(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	p.mu.Unlock()
+
+	p.publish(events)
+	return err
+}
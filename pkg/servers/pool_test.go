@@ -0,0 +1,159 @@
+package servers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+)
+
+func testProxy(name, address string) config.Proxy {
+	p := config.Proxy{Name: name, Address: address, Port: 5214}
+	_ = p.Validate()
+	return p
+}
+
+func newTestPool(t *testing.T, storage db.Storage) (*Pool, *[]PoolEvent) {
+	pool, err := NewPool(storage)
+	if err != nil {
+		t.Fatalf("Failed to load pool: %v", err)
+	}
+	var mu sync.Mutex
+	events := []PoolEvent{}
+	pool.Subscribe(func(e PoolEvent) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	})
+	return pool, &events
+}
+
+func TestPool_AddRemoveEnable(t *testing.T) {
+	storage := db.NewMemoryStorage()
+	pool, events := newTestPool(t, storage)
+
+	if err := pool.Add(testProxy("fra", "10.0.0.1")); err != nil {
+		t.Fatalf("Failed to add: %v", err)
+	}
+	if err := pool.Add(testProxy("fra", "10.0.0.9")); !errors.Is(err, ErrProxyExists) {
+		t.Errorf("Expected duplicate name error, got %v", err)
+	}
+	if err := pool.Add(testProxy("other", "10.0.0.1")); !errors.Is(err, ErrProxyExists) {
+		t.Errorf("Expected duplicate address error, got %v", err)
+	}
+	if err := pool.Add(config.Proxy{Name: "bad", Address: "10.0.0.2"}); !errors.Is(err, ErrInvalidProxy) {
+		t.Errorf("Expected invalid proxy error, got %v", err)
+	}
+
+	// the monitor saved its health meanwhile
+	_ = storage.SaveProxyServers([]db.ProxyServerRow{{Host: "10.0.0.1", IsUp: true}})
+
+	if err := pool.SetEnabled("fra", false); err != nil {
+		t.Fatalf("Failed to disable: %v", err)
+	}
+	if servers, _ := storage.GetProxyServers(true); len(servers) != 0 {
+		t.Errorf("Expected a disabled proxy to be no switch target, got %+v", servers)
+	}
+	if len(pool.Enabled()) != 0 {
+		t.Errorf("Expected no enabled proxies, got %+v", pool.Enabled())
+	}
+
+	// a restart loads the persisted state
+	reloaded, _ := newTestPool(t, storage)
+	if list := reloaded.List(); len(list) != 1 || list[0].Enabled || list[0].Source != db.PoolSourceApi {
+		t.Errorf("Expected the disabled proxy persisted, got %+v", list)
+	}
+
+	_ = pool.SetEnabled("fra", true)
+	if err := pool.Remove("fra"); err != nil {
+		t.Fatalf("Failed to remove: %v", err)
+	}
+	if err := pool.Remove("fra"); !errors.Is(err, ErrProxyNotFound) {
+		t.Errorf("Expected not found error, got %v", err)
+	}
+	if stored, _ := storage.GetProxyPool(); len(stored) != 0 {
+		t.Errorf("Expected the proxy removed from storage, got %+v", stored)
+	}
+
+	types := []string{}
+	for _, e := range *events {
+		types = append(types, e.Type)
+	}
+	if len(types) != 4 || types[0] != PoolAdded || types[1] != PoolDisabled || types[2] != PoolEnabled || types[3] != PoolRemoved {
+		t.Errorf("Unexpected events %v", types)
+	}
+}
+
+func TestPool_Reconcile(t *testing.T) {
+	storage := db.NewMemoryStorage()
+	pool, events := newTestPool(t, storage)
+
+	_ = pool.Reconcile([]config.Proxy{testProxy("fra", "10.0.0.1"), testProxy("ams", "10.0.0.2")})
+	_ = pool.Add(testProxy("runtime", "10.0.0.3"))
+	_ = pool.SetEnabled("ams", false)
+	*events = nil
+
+	moved := testProxy("ams", "10.0.0.4")
+	if err := pool.Reconcile([]config.Proxy{moved}); err != nil {
+		t.Fatalf("Failed to reconcile: %v", err)
+	}
+
+	list := pool.List()
+	if len(list) != 2 || list[0].Proxy.Name != "ams" || list[0].Proxy.Address != "10.0.0.4" || list[0].Enabled || list[1].Proxy.Name != "runtime" {
+		t.Errorf("Expected fra removed, ams moved and still disabled, runtime kept, got %+v", list)
+	}
+	if len(*events) != 2 {
+		t.Errorf("Expected removed and updated events, got %+v", *events)
+	}
+
+	*events = nil
+	_ = pool.Reconcile([]config.Proxy{moved})
+	if len(*events) != 0 {
+		t.Errorf("Expected no events for an unchanged config, got %+v", *events)
+	}
+
+	if err := pool.Reconcile([]config.Proxy{moved, testProxy("clash", "10.0.0.3")}); !errors.Is(err, ErrProxyExists) {
+		t.Errorf("Expected a config proxy on the address of a runtime one to fail, got %v", err)
+	}
+}
+
+func TestServerMonitor_FollowsPool(t *testing.T) {
+	pool, _ := newTestPool(t, db.NewMemoryStorage())
+	_ = pool.Reconcile([]config.Proxy{testProxy("fra", "10.0.0.1")})
+
+	receiver := &batchReceiver{}
+	m := NewServerMonitoring(time.Minute, time.Second, 2, CheckOptions{}, receiver, nil)
+	m.UsePool(pool)
+
+	up := checkFunc(func(ctx context.Context, host, port string) error { return nil })
+	m.syncPool()
+	m.servers[0].checker = up
+
+	_ = pool.Add(testProxy("ams", "10.0.0.2"))
+	select {
+	case <-m.changed:
+	default:
+		t.Error("Expected the monitor to be triggered by the pool change")
+	}
+
+	m.syncPool()
+	if len(m.servers) != 2 || m.servers[1].ID != "fra" {
+		t.Fatalf("Expected both proxies monitored, got %+v", m.servers)
+	}
+	if _, ok := m.servers[1].checker.(checkFunc); !ok {
+		t.Error("Expected the checker of an unchanged proxy kept")
+	}
+	m.servers[0].checker = checkFunc(func(ctx context.Context, host, port string) error {
+		_ = pool.SetEnabled("ams", false) // disabled during the round
+		return nil
+	})
+
+	m.checkServers(context.Background())
+	if len(receiver.batches) != 1 || len(receiver.batches[0]) != 1 || receiver.batches[0][0].Host != "10.0.0.1" {
+		t.Errorf("Expected only the still enabled proxy reported, got %+v", receiver.batches)
+	}
+}
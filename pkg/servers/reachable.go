@@ -6,12 +6,12 @@ import (
 )
 
 // IsServerReachable tries to connect to the given IP and port within a timeout.
-// Returns true if reachable, false otherwise.
+// Returns true if reachable, false with the dial error otherwise.
 func IsServerReachable(host string, port string, timeout time.Duration) (bool, error) {
 	address := net.JoinHostPort(host, port)
 	conn, err := net.DialTimeout("tcp", address, timeout)
 	if err != nil {
-		return false, nil
+		return false, err
 	}
 	err = conn.Close()
 	return true, err
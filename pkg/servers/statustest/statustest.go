@@ -0,0 +1,38 @@
+// Package statustest has the receiver and clock shared by the tests of the status receivers
+package statustest
+
+import (
+	"time"
+
+	"go-cf-zone-switch/pkg/servers"
+)
+
+// Receiver keeps the last statuses it received
+type Receiver struct {
+	Statuses []servers.ServerStatus
+}
+
+var _ servers.StatusReceiver = (*Receiver)(nil)
+
+func (r *Receiver) ReceiveStatus(statuses []servers.ServerStatus) error {
+	r.Statuses = statuses
+	return nil
+}
+
+// Clock is a settable time for the now field of the receivers under test
+type Clock struct {
+	T time.Time
+}
+
+// NewClock starts at 2026-01-01 UTC
+func NewClock() *Clock {
+	return &Clock{T: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *Clock) Now() time.Time {
+	return c.T
+}
+
+func (c *Clock) Add(d time.Duration) {
+	c.T = c.T.Add(d)
+}
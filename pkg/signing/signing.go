@@ -0,0 +1,69 @@
+// Package signing signs the reports of the probe agents and the heartbeats of the proxy agents
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Requests are signed with HMAC-SHA256 of the agent name, the unix timestamp in milliseconds and the body,
+// the headers keep the names of the probe reports that were signed first
+const (
+	headerAgent     = "X-Probe-Agent"
+	headerTimestamp = "X-Probe-Timestamp"
+	headerSignature = "X-Probe-Signature"
+
+	maxClockSkew = 5 * time.Minute
+)
+
+var (
+	ErrBadSignature = errors.New("signing: bad signature")
+	ErrUnknownAgent = errors.New("signing: unknown agent")
+)
+
+func sign(secret, agent, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(agent + "\n" + timestamp + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest sets the signature headers of a request
+func SignRequest(req *http.Request, secret, agent string, body []byte, now time.Time) {
+	timestamp := strconv.FormatInt(now.UnixMilli(), 10)
+	req.Header.Set(headerAgent, agent)
+	req.Header.Set(headerTimestamp, timestamp)
+	req.Header.Set(headerSignature, sign(secret, agent, timestamp, body))
+}
+
+// VerifyRequest returns the agent of a request and when it was signed. The request must be
+// signed with the secret of the agent, secrets returns "" for unknown agents.
+func VerifyRequest(req *http.Request, secrets func(agent string) string, body []byte, now time.Time) (string, time.Time, error) {
+	agent := req.Header.Get(headerAgent)
+	timestamp := req.Header.Get(headerTimestamp)
+
+	millis, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", time.Time{}, ErrBadSignature
+	}
+	signedAt := time.UnixMilli(millis)
+	if skew := now.Sub(signedAt); skew > maxClockSkew || skew < -maxClockSkew {
+		return "", time.Time{}, errors.New("signing: timestamp out of range, check the agent clock")
+	}
+
+	secret := secrets(agent)
+	if secret == "" {
+		return "", time.Time{}, fmt.Errorf("%w: %s", ErrUnknownAgent, agent)
+	}
+	expected := sign(secret, agent, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(req.Header.Get(headerSignature))) {
+		return "", time.Time{}, ErrBadSignature
+	}
+	return agent, signedAt, nil
+}
@@ -0,0 +1,157 @@
+package switcher
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"go-cf-zone-switch/pkg/cf"
+	"go-cf-zone-switch/pkg/db"
+)
+
+// DefaultDnsRefreshInterval is used when dns_refresh_interval_min is not set
+const DefaultDnsRefreshInterval = time.Hour
+
+// dnsStateMaxAge is how long an observed state is trusted, two refresh intervals
+// so that a single failed refresh does not make all states stale
+func dnsStateMaxAge(refreshIntervalMin int) time.Duration {
+	if refreshIntervalMin <= 0 {
+		return 2 * DefaultDnsRefreshInterval
+	}
+	return 2 * time.Minute * time.Duration(refreshIntervalMin)
+}
+
+// domainsOnServer returns the domains with a token that were last observed on the server,
+// or have no recent observed state and must be checked in Cloudflare
+func (r *Switcher) domainsOnServer(host string) ([]db.DomainRow, error) {
+	observed, err := r.storage.GetDomainsByProxy(host)
+	if err != nil {
+		return nil, err
+	}
+	unobserved, err := r.storage.GetDomainsNotObservedSince(r.now().Add(-r.dnsStateMaxAge))
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	domains := []db.DomainRow{}
+	for _, d := range append(observed, unobserved...) {
+		if d.CfApiToken == "" || seen[d.Domain] {
+			continue
+		}
+		seen[d.Domain] = true
+		domains = append(domains, d)
+	}
+
+	log.Printf("switcher: %d domains observed on %s, %d without a DNS state from the last %s",
+		len(observed), host, len(unobserved), r.dnsStateMaxAge)
+	return domains, nil
+}
+
+// proxyHosts maps the public IPs of the known proxy servers to their hosts
+func (r *Switcher) proxyHosts() map[string]string {
+	hosts := map[string]string{}
+	servers, err := r.storage.GetProxyServers(false)
+	if err != nil {
+		log.Printf("switcher: Failed to get proxy servers: %v", err)
+		return hosts
+	}
+	for _, s := range servers {
+		proxy := r.proxy(s.Host)
+		if len(proxy.PublicIPs) == 0 {
+			hosts[s.Host] = s.Host
+		}
+		for _, ip := range proxy.PublicIPs {
+			hosts[ip] = s.Host
+		}
+	}
+	return hosts
+}
+
+func dnsState(domain, ip string, proxies map[string]string, observedAt time.Time) db.DnsState {
+	return db.DnsState{Domain: domain, IP: ip, ProxyHost: proxies[ip], ObservedAt: observedAt}
+}
+
+// saveDnsStates stores where the domains of the switch point to after it
+func (r *Switcher) saveDnsStates(record *db.SwitchRecord) {
+	proxies := r.proxyHosts()
+
+	states := []db.DnsState{}
+	for _, o := range record.Domains {
+		ip := o.OldIP
+		if o.Status == db.OutcomeSwitched {
+			ip = o.NewIP
+		}
+		if ip == "" {
+			continue
+		}
+		states = append(states, dnsState(o.Domain, ip, proxies, record.FinishedAt))
+	}
+
+	if err := r.storage.SaveDnsStates(states); err != nil {
+		log.Printf("switcher: Failed to save DNS states: %v", err)
+	}
+}
+
+// RefreshDnsStates reads the current IP of every domain from Cloudflare
+func (r *Switcher) RefreshDnsStates() error {
+	domains, err := r.storage.GetDomainWithCfTokens()
+	if err != nil {
+		return err
+	}
+	proxies := r.proxyHosts()
+
+	semaphore := make(chan struct{}, maxConcurrentDomainUpdates)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	states := make([]db.DnsState, 0, len(domains))
+
+	for _, group := range r.groupByToken(domains) {
+		for _, domain := range group.domains {
+			wg.Add(1)
+			semaphore <- struct{}{} // acquire
+
+			go func(client cf.Client, d db.DomainRow) {
+				defer wg.Done()
+				defer func() { <-semaphore }() // release
+
+				// observed before the call, so a switch finished meanwhile wins
+				observedAt := time.Now()
+				ip, err := client.GetDomainIP(d.Domain)
+				if err != nil {
+					log.Printf("switcher: Failed to refresh DNS state of %s: %v", d.Domain, err)
+					return
+				}
+
+				mu.Lock()
+				states = append(states, dnsState(d.Domain, ip, proxies, observedAt))
+				mu.Unlock()
+			}(group.client, domain)
+		}
+	}
+
+	wg.Wait()
+	return r.storage.SaveDnsStates(states)
+}
+
+// StartDnsRefresh refreshes DNS states now and then every interval
+func (r *Switcher) StartDnsRefresh(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := r.RefreshDnsStates(); err != nil {
+				log.Printf("switcher: Failed to refresh DNS states: %v", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				log.Println("switcher: DNS refresh stopped due to context cancellation")
+				return
+			}
+		}
+	}()
+}
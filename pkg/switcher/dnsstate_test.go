@@ -0,0 +1,113 @@
+package switcher
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"go-cf-zone-switch/pkg/cf"
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+	"go-cf-zone-switch/pkg/servers"
+)
+
+func TestSwitcher_SwitchesOnlyDomainsOnFailedServer(t *testing.T) {
+	failedIP := "10.0.0.1"
+	newHostIP := "10.0.0.2"
+
+	mockStorage := &MockStorage{
+		GetProxyServersFunc: func(onlyHealthy bool) ([]db.ProxyServerRow, error) {
+			return []db.ProxyServerRow{{Host: newHostIP, IsUp: true}, {Host: failedIP}}, nil
+		},
+		SaveProxyServersFunc: func(rows []db.ProxyServerRow) error { return nil },
+	}
+	_ = mockStorage.SaveDomains([]db.DomainRow{
+		{Domain: "on-failed.com", CfApiToken: "token"},
+		{Domain: "elsewhere.com", CfApiToken: "token"},
+		{Domain: "unknown.com", CfApiToken: "token"},
+	})
+	_ = mockStorage.SaveDnsStates([]db.DnsState{
+		{Domain: "on-failed.com", IP: failedIP, ProxyHost: failedIP, ObservedAt: time.Now()},
+		{Domain: "elsewhere.com", IP: newHostIP, ProxyHost: newHostIP, ObservedAt: time.Now()},
+	})
+
+	var mu sync.Mutex
+	checked := map[string]bool{}
+	sw := NewSwitcher(&config.Config{}, mockStorage, &MockNotifier{})
+	sw.switchAfterFailureCount = 1
+	sw.cfClientFactory = func(token string) cf.Client {
+		return &MockCfClient{
+			GetDomainIPFunc: func(dom string) (string, error) {
+				mu.Lock()
+				checked[dom] = true
+				mu.Unlock()
+				return failedIP, nil
+			},
+			UpdateDomainIPFunc: func(dom, newIP string) error { return nil },
+		}
+	}
+
+	_ = sw.ReceiveStatus([]servers.ServerStatus{{Host: failedIP, IsUp: false}})
+
+	if checked["elsewhere.com"] || !checked["on-failed.com"] || !checked["unknown.com"] {
+		t.Errorf("Expected only domains on the failed server or without state checked, got %v", checked)
+	}
+
+	states, _ := mockStorage.GetDnsStates()
+	distribution := db.ProxyDistribution(states)
+	if len(distribution[newHostIP]) != 3 {
+		t.Errorf("Expected all domains observed on the new server, got %+v", states)
+	}
+}
+
+func TestSwitcher_RefreshDnsStates(t *testing.T) {
+	mockStorage := &MockStorage{
+		GetProxyServersFunc: func(onlyHealthy bool) ([]db.ProxyServerRow, error) {
+			return []db.ProxyServerRow{{Host: "10.0.0.1"}}, nil
+		},
+	}
+
+	sw := NewSwitcher(&config.Config{}, mockStorage, &MockNotifier{})
+	sw.cfClientFactory = func(token string) cf.Client {
+		return &MockCfClient{GetDomainIPFunc: func(dom string) (string, error) {
+			if dom == "direct.com" {
+				return "192.168.0.1", nil
+			}
+			return "10.0.0.1", nil
+		}}
+	}
+	_ = mockStorage.SaveDomains([]db.DomainRow{{Domain: "proxied.com", CfApiToken: "token"}, {Domain: "direct.com", CfApiToken: "token"}})
+
+	if err := sw.RefreshDnsStates(); err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+
+	states, _ := mockStorage.GetDnsStates()
+	if len(states) != 2 || states[0].ProxyHost != "" || states[1].ProxyHost != "10.0.0.1" {
+		t.Errorf("Expected direct.com without proxy and proxied.com on the proxy, got %+v", states)
+	}
+}
+
+func TestSwitcher_DomainsOnServer_IgnoresStaleStates(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	storage := &MockStorage{}
+	_ = storage.SaveDomains([]db.DomainRow{
+		{Domain: "fresh.com", CfApiToken: "token"},
+		{Domain: "stale.com", CfApiToken: "token"},
+		{Domain: "failed.com", CfApiToken: "token"},
+		{Domain: "no-token.com"},
+	})
+	_ = storage.SaveDnsStates([]db.DnsState{
+		{Domain: "fresh.com", IP: "10.0.0.2", ProxyHost: "10.0.0.2", ObservedAt: now.Add(-time.Hour)},
+		{Domain: "stale.com", IP: "10.0.0.2", ProxyHost: "10.0.0.2", ObservedAt: now.Add(-3 * time.Hour)},
+		{Domain: "failed.com", IP: "10.0.0.1", ProxyHost: "10.0.0.1", ObservedAt: now.Add(-time.Hour)},
+	})
+
+	sw := NewSwitcher(&config.Config{}, storage, &MockNotifier{})
+	sw.now = func() time.Time { return now }
+
+	got, err := sw.domainsOnServer("10.0.0.1")
+	if err != nil || len(got) != 2 || got[0].Domain != "failed.com" || got[1].Domain != "stale.com" {
+		t.Errorf("Expected the domain on the failed server and the stale one, got %+v, %v", got, err)
+	}
+}
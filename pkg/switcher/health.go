@@ -0,0 +1,163 @@
+package switcher
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/servers"
+)
+
+const (
+	defaultRecoveryCount = 3
+	defaultFlapHalfLife  = 10 * time.Minute
+	defaultFlapSuppress  = 3.0
+	defaultFlapReuse     = 1.0
+)
+
+// damping decides when a proxy counts as failed, recovered or unstable
+type damping struct {
+	downAfter     time.Duration // failing for this long switches away as well, 0 disables
+	recoveryCount int
+	halfLife      time.Duration
+	suppress      float64 // 0 disables flap damping
+	reuse         float64
+}
+
+// dampingFromConfig fills in defaults, a negative flap_suppress disables flap damping
+func dampingFromConfig(cfg config.Switcher) damping {
+	d := damping{
+		downAfter:     time.Duration(cfg.DownAfterSec) * time.Second,
+		recoveryCount: cfg.RecoveryCount,
+		halfLife:      time.Duration(cfg.FlapHalfLifeMin) * time.Minute,
+		suppress:      cfg.FlapSuppress,
+		reuse:         cfg.FlapReuse,
+	}
+	if d.recoveryCount <= 0 {
+		d.recoveryCount = defaultRecoveryCount
+	}
+	if d.halfLife <= 0 {
+		d.halfLife = defaultFlapHalfLife
+	}
+	switch {
+	case d.suppress == 0:
+		d.suppress = defaultFlapSuppress
+	case d.suppress < 0:
+		d.suppress = 0
+	}
+	if d.reuse <= 0 || d.reuse > d.suppress {
+		d.reuse = min(defaultFlapReuse, d.suppress)
+	}
+	return d
+}
+
+// hostHealth is the view of the switcher on a proxy across checks
+type hostHealth struct {
+	failures  int       // failed checks since the proxy last recovered or was switched away from
+	successes int       // consecutive up checks
+	failing   bool      // failed and not recovered yet
+	downSince time.Time // start of the failing period, restarted after a switch
+
+	seen      bool // a check was observed, lastUp is its result
+	lastUp    bool
+	flapScore float64   // one per up or down change, decaying with the half-life
+	flapAt    time.Time // when flapScore was last updated
+	unstable  bool
+}
+
+// flapScoreAt returns the flap score decayed to now
+func (h *hostHealth) flapScoreAt(now time.Time, halfLife time.Duration) float64 {
+	if h.flapAt.IsZero() {
+		return h.flapScore
+	}
+	return h.flapScore * math.Pow(0.5, now.Sub(h.flapAt).Seconds()/halfLife.Seconds())
+}
+
+func (r *Switcher) health(host string) *hostHealth {
+	h, ok := r.hosts[host]
+	if !ok {
+		h = &hostHealth{}
+		r.hosts[host] = h
+	}
+	return h
+}
+
+// observe applies a check of the proxy and reports whether domains should be switched away from it.
+// A failure count only resets after recoveryCount consecutive up checks, so a proxy
+// alternating between up and down still trips.
+func (r *Switcher) observe(s servers.ServerStatus, now time.Time) bool {
+	h := r.health(s.Host)
+	r.updateFlapScore(s.Host, h, s.IsUp, now)
+
+	if s.IsUp {
+		h.successes++
+		if h.failing && h.successes >= r.damping.recoveryCount {
+			log.Printf("switcher: Host %s recovered after %d up checks", s.Host, h.successes)
+			h.failing, h.failures = false, 0
+		}
+		return false
+	}
+
+	h.successes = 0
+	if !h.failing {
+		h.failing, h.downSince = true, now
+	}
+	h.failures++
+
+	if h.failures >= r.switchAfterFailureCount {
+		log.Printf("switcher: Host %s failed %d times, switching domains...", s.Host, h.failures)
+		return true
+	}
+	if r.damping.downAfter > 0 && now.Sub(h.downSince) >= r.damping.downAfter {
+		log.Printf("switcher: Host %s failing for %s, switching domains...", s.Host, now.Sub(h.downSince).Round(time.Second))
+		return true
+	}
+	return false
+}
+
+// switchedAway restarts the failure count and period of the proxy after a switch
+func (r *Switcher) switchedAway(host string, now time.Time) {
+	h := r.health(host)
+	h.failures, h.downSince = 0, now
+}
+
+func (r *Switcher) updateFlapScore(host string, h *hostHealth, up bool, now time.Time) {
+	if r.damping.suppress == 0 {
+		return
+	}
+
+	h.flapScore, h.flapAt = h.flapScoreAt(now, r.damping.halfLife), now
+	if h.seen && h.lastUp != up {
+		h.flapScore++
+	}
+	h.seen, h.lastUp = true, up
+
+	switch {
+	case !h.unstable && h.flapScore >= r.damping.suppress:
+		h.unstable = true
+		log.Printf("switcher: Host %s is flapping, flap score %.1f", host, h.flapScore)
+		r.Notify(fmt.Sprintf("Server %s is flapping, it is no failover target until stable", host))
+	case h.unstable && h.flapScore < r.damping.reuse:
+		h.unstable = false
+		log.Printf("switcher: Host %s is stable again, flap score %.1f", host, h.flapScore)
+	}
+}
+
+// targetProblem describes why a healthy proxy is no failover target yet, or returns an empty string
+func (r *Switcher) targetProblem(host string, now time.Time) string {
+	h, ok := r.hosts[host]
+	if !ok {
+		return ""
+	}
+	if h.unstable {
+		if score := h.flapScoreAt(now, r.damping.halfLife); score >= r.damping.reuse {
+			return fmt.Sprintf("unstable, flap score %.1f", score)
+		}
+	}
+	if h.failing {
+		return fmt.Sprintf("recovering, %d of %d up checks", h.successes, r.damping.recoveryCount)
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package switcher
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+	"go-cf-zone-switch/pkg/servers"
+)
+
+func newHealthSwitcher(cfg config.Switcher) (*Switcher, *time.Time) {
+	sw := NewSwitcher(&config.Config{Switcher: cfg}, &MockStorage{}, &MockNotifier{})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sw.now = func() time.Time { return now }
+	return sw, &now
+}
+
+func TestSwitcher_AlternatingHostTrips(t *testing.T) {
+	sw, now := newHealthSwitcher(config.Switcher{FailureCount: 3, FlapSuppress: -1})
+
+	tripped := 0
+	for i := 0; i < 6; i++ {
+		if sw.observe(servers.ServerStatus{Host: "flaky", IsUp: i%2 == 1}, *now) {
+			tripped = i
+			break
+		}
+	}
+	if tripped != 4 {
+		t.Errorf("Expected the third failure to trip despite the up checks between, tripped at %d", tripped)
+	}
+}
+
+func TestSwitcher_TripsAfterDownDuration(t *testing.T) {
+	sw, now := newHealthSwitcher(config.Switcher{FailureCount: 100, DownAfterSec: 90})
+
+	if sw.observe(servers.ServerStatus{Host: "slow"}, *now) {
+		t.Error("Expected no trip on the first failure")
+	}
+	*now = now.Add(60 * time.Second)
+	if sw.observe(servers.ServerStatus{Host: "slow"}, *now) {
+		t.Error("Expected no trip after 60s")
+	}
+	*now = now.Add(30 * time.Second)
+	if !sw.observe(servers.ServerStatus{Host: "slow"}, *now) {
+		t.Error("Expected a trip after 90s down")
+	}
+}
+
+func TestSwitcher_RecoveringHostIsNoTarget(t *testing.T) {
+	sw, now := newHealthSwitcher(config.Switcher{RecoveryCount: 2, FlapSuppress: -1})
+
+	sw.observe(servers.ServerStatus{Host: "b"}, *now)
+	sw.observe(servers.ServerStatus{Host: "b", IsUp: true}, *now)
+	if problem := sw.targetProblem("b", *now); problem != "recovering, 1 of 2 up checks" {
+		t.Errorf("Expected a briefly up host to be recovering, got %q", problem)
+	}
+
+	sw.observe(servers.ServerStatus{Host: "b", IsUp: true}, *now)
+	if problem := sw.targetProblem("b", *now); problem != "" {
+		t.Errorf("Expected the host recovered, got %q", problem)
+	}
+	if h := sw.hosts["b"]; h.failures != 0 || h.failing {
+		t.Errorf("Expected the failure count reset on recovery, got %+v", h)
+	}
+}
+
+func TestSwitcher_FlappingHostIsDamped(t *testing.T) {
+	sw, now := newHealthSwitcher(config.Switcher{FailureCount: 100, RecoveryCount: 1})
+	notifier := sw.notifier.(*MockNotifier)
+
+	for i := 0; i < 4; i++ {
+		sw.observe(servers.ServerStatus{Host: "flap", IsUp: i%2 == 0}, *now)
+		*now = now.Add(time.Minute)
+	}
+	sw.observe(servers.ServerStatus{Host: "flap", IsUp: true}, *now)
+
+	if problem := sw.targetProblem("flap", *now); !strings.HasPrefix(problem, "unstable") {
+		t.Errorf("Expected the flapping host unstable, got %q", problem)
+	}
+	if len(notifier.Messages) != 1 || !strings.Contains(notifier.Messages[0], "flap is flapping") {
+		t.Errorf("Expected one flapping notification, got %v", notifier.Messages)
+	}
+
+	// stable for two half-lives brings the score from about 3.6 below the reuse threshold
+	*now = now.Add(20 * time.Minute)
+	if problem := sw.targetProblem("flap", *now); problem != "" {
+		t.Errorf("Expected the host a target again once stable, got %q", problem)
+	}
+}
+
+func TestSwitcher_ForgetsHostsLeavingThePool(t *testing.T) {
+	storage := &MockStorage{SaveProxyServersFunc: func([]db.ProxyServerRow) error { return nil }}
+	sw := NewSwitcher(&config.Config{Switcher: config.Switcher{FailureCount: 5}}, storage, &MockNotifier{})
+	pool, err := servers.NewPool(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = pool.Reconcile([]config.Proxy{{Name: "a", Address: "10.0.0.1", Port: 80}, {Name: "b", Address: "10.0.0.2", Port: 80}})
+	sw.UsePool(pool)
+
+	_ = sw.ReceiveStatus([]servers.ServerStatus{{Host: "10.0.0.1"}, {Host: "10.0.0.2"}})
+	_ = pool.SetEnabled("a", false)
+	_ = pool.Remove("b")
+	_ = sw.ReceiveStatus(nil)
+
+	if len(sw.hosts) != 0 {
+		t.Errorf("Expected the health of disabled and removed proxies dropped, got %v", sw.hosts)
+	}
+}
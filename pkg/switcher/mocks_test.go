@@ -1,6 +1,8 @@
 package switcher
 
 import (
+	"sync"
+
 	"go-cf-zone-switch/pkg/cf"
 	"go-cf-zone-switch/pkg/db"
 )
@@ -11,6 +13,9 @@ type MockCfClient struct {
 	GetDomainIPFunc    func(domain string) (string, error)
 	UpdateDomainIPFunc func(domain, newIP string) error
 
+	// domains sharing a token are updated through one client from several goroutines
+	mu sync.Mutex
+
 	cf.Client
 }
 
@@ -37,6 +42,8 @@ func (m *MockCfClient) GetDomainIP(domain string) (string, error) {
 
 func (m *MockCfClient) UpdateDomainIP(domain, newIP string) error {
 	if m.UpdateDomainIPFunc != nil {
+		m.mu.Lock()
+		defer m.mu.Unlock()
 		m.DomainIpUpdatedTo = newIP
 		m.DomainUpdated = domain
 		return m.UpdateDomainIPFunc(domain, newIP)
@@ -46,22 +53,28 @@ func (m *MockCfClient) UpdateDomainIP(domain, newIP string) error {
 
 type MockNotifier struct {
 	Messages []string
+	mu       sync.Mutex
 }
 
 func (m *MockNotifier) Notify(msg string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.Messages = append(m.Messages, msg)
 	return nil
 }
 
+// MockStorage records calls and overrides some methods, the rest is served by the embedded MemoryStorage
 type MockStorage struct {
 	GetProxyServersCalled bool
 	GetProxyServersFunc   func(onlyHealthy bool) ([]db.ProxyServerRow, error)
 
-	GetDomainWithCfTokensCalled bool
-	GetDomainWithCfTokensFunc   func() ([]db.DomainRow, error)
-
 	SaveProxyServersCalled bool
 	SaveProxyServersFunc   func(rows []db.ProxyServerRow) error
+
+	SwitchRecords []db.SwitchRecord
+	ServerChecks  []db.ServerCheck
+
+	db.MemoryStorage
 }
 
 func (m *MockStorage) SaveProxyServers(rows []db.ProxyServerRow) error {
@@ -74,16 +87,15 @@ func (m *MockStorage) GetProxyServers(onlyHealthy bool) ([]db.ProxyServerRow, er
 	return m.GetProxyServersFunc(onlyHealthy)
 }
 
-func (m *MockStorage) GetDomainWithCfTokens() ([]db.DomainRow, error) {
-	m.GetDomainWithCfTokensCalled = true
-	return m.GetDomainWithCfTokensFunc()
-}
-func (m *MockStorage) Close() {}
-
-func (m *MockStorage) SaveDomains(rows []db.DomainRow) error {
+func (m *MockStorage) SaveSwitchRecord(record *db.SwitchRecord) error {
+	if err := m.MemoryStorage.SaveSwitchRecord(record); err != nil {
+		return err
+	}
+	m.SwitchRecords = append(m.SwitchRecords, *record)
 	return nil
 }
 
-func (m *MockStorage) GetAllDomains() ([]db.DomainRow, error) {
-	return nil, nil
+func (m *MockStorage) AppendServerChecks(checks []db.ServerCheck) error {
+	m.ServerChecks = append(m.ServerChecks, checks...)
+	return m.MemoryStorage.AppendServerChecks(checks)
 }
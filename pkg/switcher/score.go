@@ -0,0 +1,222 @@
+package switcher
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+	"go-cf-zone-switch/pkg/servers"
+)
+
+const (
+	defaultScoreWindow = 15 * time.Minute
+	defaultLoadPenalty = 10 * time.Millisecond
+)
+
+// scoreSettings returns the score window and load penalty of the config, 0 uses the default
+// and a negative load penalty ignores the load
+func scoreSettings(cfg config.Switcher) (time.Duration, time.Duration) {
+	window := time.Duration(cfg.ScoreWindowMin) * time.Minute
+	if window <= 0 {
+		window = defaultScoreWindow
+	}
+
+	penalty := time.Duration(cfg.LoadPenaltyMs) * time.Millisecond
+	switch {
+	case cfg.LoadPenaltyMs == 0:
+		penalty = defaultLoadPenalty
+	case cfg.LoadPenaltyMs < 0:
+		penalty = 0
+	}
+	return window, penalty
+}
+
+// candidate is a healthy server ranked as failover target
+type candidate struct {
+	server   db.ProxyServerRow
+	proxy    config.Proxy
+	latency  time.Duration // mean time to the first byte over the score window
+	measured bool          // false without up checks in the window
+	domains  int           // domains last observed on the server
+	failed   int           // failed checks in the score window
+	score    time.Duration // latency with the load penalty, divided by the weight
+}
+
+func (c candidate) reason() string {
+	latency := "no latency measured"
+	if c.measured {
+		latency = fmt.Sprintf("latency %s", c.latency.Round(time.Millisecond))
+	}
+	reason := fmt.Sprintf("priority %d, %s, %d domains, score %s", c.proxy.Priority, latency, c.domains, c.score.Round(time.Millisecond))
+	if c.failed > 0 {
+		reason += fmt.Sprintf(", %d failed checks", c.failed)
+	}
+	return reason
+}
+
+// rankCandidates returns the healthy and stable failover targets for the incoming domains
+// best first, lowest priority, then measured servers by score and then highest weight.
+// Servers with failed checks in the score window are skipped, unless no other server
+// remains, then the one with the fewest failed checks is returned.
+// The reasons of the skipped servers are returned as well.
+func (r *Switcher) rankCandidates(now time.Time, incoming int) ([]candidate, []string, error) {
+	servers, err := r.storage.GetProxyServers(true)
+	if err != nil {
+		return nil, nil, err
+	}
+	served := r.servedDomains()
+	certProblems := r.certProblems(now)
+
+	candidates := []candidate{}
+	flaky := []candidate{}
+	skipped := []string{}
+	for _, s := range servers {
+		proxy := r.proxy(s.Host)
+		if !s.IsUp || proxy.NoFailover {
+			continue
+		}
+		if proxy.MaxDomains > 0 && len(served[s.Host])+incoming > proxy.MaxDomains {
+			log.Printf("switcher: Server %s serves %d domains, %d more exceed max %d, skipping",
+				s.Host, len(served[s.Host]), incoming, proxy.MaxDomains)
+			skipped = append(skipped, fmt.Sprintf("%s: serves %d domains, %d more exceed max %d",
+				s.Host, len(served[s.Host]), incoming, proxy.MaxDomains))
+			continue
+		}
+
+		if problem := r.targetProblem(s.Host, now); problem != "" {
+			log.Printf("switcher: Server %s skipped, %s", s.Host, problem)
+			skipped = append(skipped, fmt.Sprintf("%s: %s", s.Host, problem))
+			continue
+		}
+		if problem, ok := certProblems[s.Host]; ok {
+			log.Printf("switcher: Server %s skipped, %s", s.Host, problem)
+			skipped = append(skipped, fmt.Sprintf("%s: %s", s.Host, problem))
+			continue
+		}
+
+		c := candidate{server: s, proxy: proxy, domains: len(served[s.Host])}
+		if err := r.scoreLatency(&c, now); err != nil {
+			log.Printf("switcher: Failed to get checks of %s: %v", s.Host, err)
+		}
+		weight := max(proxy.Weight, 1)
+		c.score = (c.latency + r.loadPenalty*time.Duration(c.domains)) / time.Duration(weight)
+
+		if c.failed > 0 {
+			flaky = append(flaky, c)
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+
+	sortCandidates(candidates)
+	sort.SliceStable(flaky, func(i, j int) bool { return flaky[i].failed < flaky[j].failed })
+	if len(candidates) == 0 && len(flaky) > 0 {
+		log.Printf("switcher: No server without failed checks, falling back to %s with %d failed checks",
+			flaky[0].server.Host, flaky[0].failed)
+		candidates, flaky = flaky[:1], flaky[1:]
+	}
+	for _, c := range flaky {
+		log.Printf("switcher: Server %s failed %d checks in the last %s, skipping", c.server.Host, c.failed, r.scoreWindow)
+		skipped = append(skipped, fmt.Sprintf("%s: %d failed checks in the last %s", c.server.Host, c.failed, r.scoreWindow))
+	}
+	return candidates, skipped, nil
+}
+
+// sortCandidates orders by lowest priority, then measured servers by score and then highest weight
+func sortCandidates(candidates []candidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		switch {
+		case a.proxy.Priority != b.proxy.Priority:
+			return a.proxy.Priority < b.proxy.Priority
+		case a.measured != b.measured:
+			return a.measured
+		case a.score != b.score:
+			return a.score < b.score
+		}
+		return a.proxy.Weight > b.proxy.Weight
+	})
+}
+
+// certProblems returns the first certificate problem of each proxy host when the certificate guard is on
+func (r *Switcher) certProblems(now time.Time) map[string]string {
+	problems := map[string]string{}
+	if !r.certGuard {
+		return problems
+	}
+
+	checks, err := r.storage.GetCertChecks()
+	if err != nil {
+		log.Printf("switcher: Failed to get cert checks: %v", err)
+		return problems
+	}
+	for _, c := range checks {
+		if _, ok := problems[c.Host]; ok {
+			continue
+		}
+		if problem := servers.CertProblem(c, now, r.certFailoverDays); problem != "" {
+			problems[c.Host] = problem
+		}
+	}
+	return problems
+}
+
+// scoreLatency sets the mean latency of the up checks in the score window and
+// the number of failed checks. The TTFB is used when measured, the
+// whole check time otherwise.
+func (r *Switcher) scoreLatency(c *candidate, now time.Time) error {
+	checks, err := r.storage.GetServerChecks(c.server.Host, now.Add(-r.scoreWindow), now)
+	if err != nil {
+		return err
+	}
+
+	up := 0
+	var sum time.Duration
+	for _, check := range checks {
+		if !check.IsUp {
+			c.failed++
+			continue
+		}
+		latency := check.TTFB
+		if latency == 0 {
+			latency = check.Latency
+		}
+		sum += latency
+		up++
+	}
+	if up > 0 {
+		c.latency = sum / time.Duration(up)
+		c.measured = true
+	}
+	return nil
+}
+
+// selectHealthyServer selects the preferred failover target for the incoming domains
+// and the reason it was chosen
+func (r *Switcher) selectHealthyServer(incoming int) (*db.ProxyServerRow, string, error) {
+	candidates, skipped, err := r.rankCandidates(r.now(), incoming)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(candidates) == 0 {
+		if len(skipped) > 0 {
+			return nil, "", fmt.Errorf("no healthy server found, skipped %s", strings.Join(skipped, "; "))
+		}
+		return nil, "", fmt.Errorf("no healthy server found")
+	}
+
+	best := candidates[0]
+	reason := best.reason()
+	if len(candidates) > 1 {
+		next := candidates[1]
+		reason += fmt.Sprintf(", next %s with %s", next.server.Host, next.reason())
+	}
+	if len(skipped) > 0 {
+		reason += ", skipped " + strings.Join(skipped, "; ")
+	}
+	return &best.server, reason, nil
+}
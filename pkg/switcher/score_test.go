@@ -0,0 +1,160 @@
+package switcher
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go-cf-zone-switch/pkg/cf"
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+	"go-cf-zone-switch/pkg/servers"
+)
+
+func TestSwitcher_SelectsByLatencyAndLoad(t *testing.T) {
+	mockStorage := &MockStorage{
+		GetProxyServersFunc: func(onlyHealthy bool) ([]db.ProxyServerRow, error) {
+			return []db.ProxyServerRow{
+				{Host: "busy", IsUp: true},
+				{Host: "fast", IsUp: true},
+				{Host: "flaky", IsUp: true},
+				{Host: "slow", IsUp: true},
+				{Host: "unmeasured", IsUp: true},
+			}, nil
+		},
+	}
+	sw := NewSwitcher(&config.Config{Switcher: config.Switcher{LoadPenaltyMs: 20}}, mockStorage, &MockNotifier{})
+
+	now := time.Now()
+	checks := []db.ServerCheck{}
+	for i := 1; i <= 3; i++ {
+		at := now.Add(-time.Duration(i) * time.Minute)
+		checks = append(checks,
+			db.ServerCheck{Host: "busy", At: at, IsUp: true, TTFB: 10 * time.Millisecond},
+			db.ServerCheck{Host: "fast", At: at, IsUp: true, TTFB: 40 * time.Millisecond},
+			db.ServerCheck{Host: "flaky", At: at, IsUp: i != 2, TTFB: time.Millisecond},
+			db.ServerCheck{Host: "slow", At: at, IsUp: true, Latency: 90 * time.Millisecond},
+		)
+	}
+	_ = mockStorage.AppendServerChecks(checks)
+	_ = mockStorage.SaveDnsStates([]db.DnsState{
+		{Domain: "a.com", IP: "busy", ProxyHost: "busy", ObservedAt: now},
+		{Domain: "b.com", IP: "busy", ProxyHost: "busy", ObservedAt: now},
+	})
+
+	candidates, skipped, err := sw.rankCandidates(now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := []string{}
+	for _, c := range candidates {
+		order = append(order, c.server.Host)
+	}
+	// busy scores 10ms + 2 * 20ms, behind fast with 40ms
+	if strings.Join(order, ",") != "fast,busy,slow,unmeasured" {
+		t.Errorf("Unexpected order %v", order)
+	}
+	if len(skipped) != 1 || !strings.HasPrefix(skipped[0], "flaky: 1 failed checks") {
+		t.Errorf("Expected flaky skipped for its recent error, got %v", skipped)
+	}
+}
+
+func TestSwitcher_NotifiesSwitchReason(t *testing.T) {
+	mockStorage := &MockStorage{
+		GetProxyServersFunc: func(onlyHealthy bool) ([]db.ProxyServerRow, error) {
+			return []db.ProxyServerRow{{Host: "10.0.0.2", IsUp: true}}, nil
+		},
+		SaveProxyServersFunc: func(rows []db.ProxyServerRow) error { return nil },
+	}
+	_ = mockStorage.SaveDomains([]db.DomainRow{{Domain: "a.com", CfApiToken: "token"}})
+	_ = mockStorage.AppendServerChecks([]db.ServerCheck{{Host: "10.0.0.2", At: time.Now().Add(-time.Minute), IsUp: true, TTFB: 25 * time.Millisecond}})
+
+	notifier := &MockNotifier{}
+	sw := NewSwitcher(&config.Config{}, mockStorage, notifier)
+	sw.switchAfterFailureCount = 1
+	sw.cfClientFactory = func(token string) cf.Client {
+		return &MockCfClient{
+			GetDomainIPFunc:    func(string) (string, error) { return "10.0.0.1", nil },
+			UpdateDomainIPFunc: func(string, string) error { return nil },
+		}
+	}
+
+	_ = sw.ReceiveStatus([]servers.ServerStatus{{Host: "10.0.0.1", IsUp: false}})
+
+	want := "Server 10.0.0.1 failed, switching its domains to 10.0.0.2: priority 0, latency 25ms, 0 domains, score 25ms"
+	if len(notifier.Messages) == 0 || notifier.Messages[0] != want {
+		t.Errorf("Expected %q first, got %v", want, notifier.Messages)
+	}
+	if record := mockStorage.SwitchRecords[0]; !strings.Contains(record.Reason, "latency 25ms") {
+		t.Errorf("Expected the reason in the switch record, got %+v", record)
+	}
+}
+
+func TestSwitcher_SkipsTargetsWithCertProblems(t *testing.T) {
+	mockStorage := &MockStorage{
+		GetProxyServersFunc: func(onlyHealthy bool) ([]db.ProxyServerRow, error) {
+			return []db.ProxyServerRow{{Host: "expiring", IsUp: true}, {Host: "ok", IsUp: true}, {Host: "refused", IsUp: true}}, nil
+		},
+	}
+	now := time.Now()
+	_ = mockStorage.SaveCertChecks([]db.CertCheck{
+		{Host: "expiring", Domain: "a.com", CheckedAt: now, NotAfter: now.Add(time.Hour), Valid: true},
+		{Host: "ok", Domain: "a.com", CheckedAt: now, NotAfter: now.Add(90 * 24 * time.Hour), Valid: true},
+		{Host: "refused", Domain: "a.com", CheckedAt: now, Error: "remote error: tls: handshake failure"},
+	})
+
+	cfg := &config.Config{Servers: config.Servers{Certs: config.Certs{Enabled: true, NoFailover: true}}}
+	sw := NewSwitcher(cfg, mockStorage, &MockNotifier{})
+
+	server, reason, err := sw.selectHealthyServer(1)
+	if err != nil || server.Host != "ok" || !strings.Contains(reason, "skipped expiring: certificate for a.com expires") ||
+		!strings.Contains(reason, "refused: TLS handshake for a.com failed") {
+		t.Errorf("Expected ok chosen and expiring and refused skipped, got %+v, %q, %v", server, reason, err)
+	}
+}
+
+func TestSwitcher_MaxDomainsCountsIncoming(t *testing.T) {
+	cfg := &config.Config{Servers: config.Servers{Proxy: []config.Proxy{
+		{Name: "small", Address: "small", Port: 80, MaxDomains: 3},
+		{Name: "large", Address: "large", Port: 80, Priority: 1},
+	}}}
+	mockStorage := &MockStorage{
+		GetProxyServersFunc: func(onlyHealthy bool) ([]db.ProxyServerRow, error) {
+			return []db.ProxyServerRow{{Host: "large", IsUp: true}, {Host: "small", IsUp: true}}, nil
+		},
+	}
+	_ = mockStorage.SaveDnsStates([]db.DnsState{{Domain: "a.com", IP: "small", ProxyHost: "small", ObservedAt: time.Now()}})
+	sw := NewSwitcher(cfg, mockStorage, &MockNotifier{})
+
+	if server, _, err := sw.selectHealthyServer(2); err != nil || server.Host != "small" {
+		t.Errorf("Expected small to take 2 more domains, got %+v, %v", server, err)
+	}
+	server, reason, err := sw.selectHealthyServer(3)
+	if err != nil || server.Host != "large" || !strings.Contains(reason, "small: serves 1 domains, 3 more exceed max 3") {
+		t.Errorf("Expected small skipped for 3 more domains, got %+v, %q, %v", server, reason, err)
+	}
+}
+
+func TestSwitcher_FallsBackToFewestFailedChecks(t *testing.T) {
+	mockStorage := &MockStorage{
+		GetProxyServersFunc: func(onlyHealthy bool) ([]db.ProxyServerRow, error) {
+			return []db.ProxyServerRow{{Host: "worse", IsUp: true}, {Host: "better", IsUp: true}}, nil
+		},
+	}
+	sw := NewSwitcher(&config.Config{}, mockStorage, &MockNotifier{})
+
+	now := time.Now()
+	_ = mockStorage.AppendServerChecks([]db.ServerCheck{
+		{Host: "worse", At: now.Add(-time.Minute)},
+		{Host: "worse", At: now.Add(-2 * time.Minute)},
+		{Host: "better", At: now.Add(-time.Minute)},
+		{Host: "better", At: now.Add(-2 * time.Minute), IsUp: true},
+	})
+
+	server, reason, err := sw.selectHealthyServer(1)
+	if err != nil || server.Host != "better" || !strings.Contains(reason, "1 failed checks") ||
+		!strings.Contains(reason, "skipped worse: 2 failed checks") {
+		t.Errorf("Expected better chosen with its failed check, got %+v, %q, %v", server, reason, err)
+	}
+}
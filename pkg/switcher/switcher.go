@@ -3,7 +3,9 @@ package switcher
 import (
 	"fmt"
 	"log"
+	"sort"
 	"sync"
+	"time"
 
 	"go-cf-zone-switch/pkg/cf"
 	"go-cf-zone-switch/pkg/config"
@@ -20,53 +22,69 @@ const (
 type CFClientFactory func(token string) cf.Client
 
 type Switcher struct {
+	proxies                 servers.ProxyDirectory
 	storage                 db.Storage
 	notifier                notifications.Notifier
 	switchAfterFailureCount int
 	cfClientFactory         CFClientFactory
+	scoreWindow             time.Duration // recent checks scored when choosing a failover target
+	loadPenalty             time.Duration // added to the latency score per served domain
+	certGuard               bool          // skip failover targets with an invalid or expiring certificate
+	certFailoverDays        int
+	dnsStateMaxAge          time.Duration // older DNS states are checked in Cloudflare again
 
-	failureCounts map[string]int // key: Host
-	mu            sync.Mutex
+	domainURL func(recordID string) string // Airtable link of a domain in notifications, nil leaves it out
+
+	damping damping
+	hosts   map[string]*hostHealth // key: Host
+	now     func() time.Time
+	mu      sync.Mutex
+
+	forgetMu sync.Mutex
+	forget   []string // hosts removed or disabled in the pool, their health is dropped on the next report
 
 	servers.StatusReceiver
 }
 
 func NewSwitcher(config *config.Config, storage db.Storage, notifier notifications.Notifier) *Switcher {
+	scoreWindow, loadPenalty := scoreSettings(config.Switcher)
+
+	failureCount := config.Switcher.FailureCount
+	if failureCount <= 0 {
+		failureCount = defaultSwitchAfterFailureCount
+	}
+
 	return &Switcher{
+		proxies:                 config.Servers,
 		storage:                 storage,
 		notifier:                notifier,
-		switchAfterFailureCount: defaultSwitchAfterFailureCount,
+		switchAfterFailureCount: failureCount,
 		cfClientFactory:         cf.NewApiClient, // use function to create cf.Client from cf package
-		failureCounts:           make(map[string]int),
+		damping:                 dampingFromConfig(config.Switcher),
+		hosts:                   make(map[string]*hostHealth),
+		now:                     time.Now,
+		scoreWindow:             scoreWindow,
+		loadPenalty:             loadPenalty,
+		certGuard:               config.Servers.Certs.Enabled && config.Servers.Certs.NoFailover,
+		certFailoverDays:        servers.CertOptionsFromConfig(config.Servers.Certs).FailoverDays,
+		dnsStateMaxAge:          dnsStateMaxAge(config.Servers.DnsRefreshIntervalMin),
 	}
 }
 
 func (r *Switcher) ReceiveStatus(statuses []servers.ServerStatus) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	r.forgetHosts()
 
 	serverRows := []db.ProxyServerRow{}
+	checks := []db.ServerCheck{}
+	now := r.now()
 	for _, s := range statuses {
 		log.Printf("switcher: Report received %+v\n", s)
 
-		// Track consecutive failures
-		if !s.IsUp {
-			r.failureCounts[s.Host]++
-		} else {
-			r.failureCounts[s.Host] = 0
-		}
-
-		// If failed N times, trigger switch
-		if r.failureCounts[s.Host] >= r.switchAfterFailureCount {
-			log.Printf("switcher: Host %s failed %d times, switching domains...", s.Host, r.switchAfterFailureCount)
-			healthy, err := r.selectHealthyServer()
-			if err != nil {
-				log.Printf("switcher: No healthy server found: %v", err)
-				r.Notify("No healthy server found")
-			} else {
-				r.changeDomainsFromTo(s.Host, healthy)
-			}
-			r.failureCounts[s.Host] = 0 // reset after switch
+		if r.observe(s, now) {
+			r.failover(s.Host, now)
+			r.switchedAway(s.Host, now) // count again before the next switch
 		}
 
 		serverRows = append(serverRows, db.ProxyServerRow{
@@ -75,6 +93,7 @@ func (r *Switcher) ReceiveStatus(statuses []servers.ServerStatus) error {
 			CheckPort: s.Port,
 			LastCheck: s.LastCheck,
 		})
+		checks = append(checks, serverCheck(s))
 	}
 
 	err := r.storage.SaveProxyServers(serverRows)
@@ -82,77 +101,223 @@ func (r *Switcher) ReceiveStatus(statuses []servers.ServerStatus) error {
 		log.Println("Error saving servers", err)
 	}
 
+	if err := r.storage.AppendServerChecks(checks); err != nil {
+		log.Println("Error saving server checks", err)
+	}
+
 	return nil
 }
 
-// selectHealthyServer selects a server from storage where IsUp = true
-func (r *Switcher) selectHealthyServer() (*db.ProxyServerRow, error) {
-	servers, err := r.storage.GetProxyServers(true)
-	if err != nil {
-		return nil, err
+// serverCheck converts a monitor status to a time series sample
+func serverCheck(s servers.ServerStatus) db.ServerCheck {
+	check := db.ServerCheck{
+		Host:    s.Host,
+		At:      s.LastCheck,
+		IsUp:    s.IsUp,
+		Latency: s.Latency,
+		Connect: s.Connect,
+		TTFB:    s.TTFB,
+		Error:   s.Error,
 	}
-	for _, s := range servers {
-		if s.IsUp {
-			return &s, nil
+	if !check.IsUp && check.Error == "" {
+		check.Error = "unreachable"
+	}
+	return check
+}
+
+// UsePool reads proxies from the pool instead of the config. The health of proxies
+// removed or disabled in the pool is forgotten, they start over when they come back.
+func (r *Switcher) UsePool(pool *servers.Pool) {
+	r.proxies = pool
+	pool.Subscribe(func(e servers.PoolEvent) {
+		if e.Type != servers.PoolRemoved && e.Type != servers.PoolDisabled {
+			return
 		}
+		// ReceiveStatus may hold the lock during a switch, subscribers must not block
+		r.forgetMu.Lock()
+		r.forget = append(r.forget, e.Proxy.Address)
+		r.forgetMu.Unlock()
+	})
+}
+
+// forgetHosts drops the health of the hosts that left the pool, it must be called with the lock held
+func (r *Switcher) forgetHosts() {
+	r.forgetMu.Lock()
+	hosts := r.forget
+	r.forget = nil
+	r.forgetMu.Unlock()
+
+	for _, host := range hosts {
+		delete(r.hosts, host)
 	}
-	return nil, fmt.Errorf("no healthy server found")
 }
 
-// changeDomainsTo is a placeholder for the logic to change domains to the new server
-func (r *Switcher) changeDomainsFromTo(fromIP string, server *db.ProxyServerRow) {
-	log.Printf("switcher: Changing domains to new server: %+v", server)
+// proxy returns the configured proxy of a stored server
+func (r *Switcher) proxy(host string) config.Proxy {
+	return r.proxies.ProxyByAddress(host)
+}
+
+// servedDomains returns the domains last observed on each proxy host
+func (r *Switcher) servedDomains() map[string][]string {
+	states, err := r.storage.GetDnsStates()
+	if err != nil {
+		log.Printf("switcher: Failed to get DNS states: %v", err)
+		return map[string][]string{}
+	}
+	return db.ProxyDistribution(states)
+}
+
+// failover moves the domains of the failed server to the best target that can take all of them
+func (r *Switcher) failover(fromIP string, now time.Time) {
+	failed := func(err error) {
+		r.saveSwitchRecord(&db.SwitchRecord{
+			Trigger:      db.TriggerAutomatic,
+			FailedServer: fromIP,
+			StartedAt:    now,
+			FinishedAt:   now,
+			Error:        err.Error(),
+		})
+	}
 
-	domains, err := r.storage.GetDomainWithCfTokens()
+	onServer, err := r.domainsOnServer(fromIP)
 	if err != nil {
-		log.Printf("switcher: Failed to get domains with CF tokens: %v", err)
+		log.Printf("switcher: Failed to get domains on %s: %v", fromIP, err)
+		failed(err)
 		return
 	}
 
-	semaphore := make(chan struct{}, maxConcurrentDomainUpdates)
-	var wg sync.WaitGroup
+	healthy, reason, err := r.selectHealthyServer(len(onServer))
+	if err != nil {
+		log.Printf("switcher: No healthy server found: %v", err)
+		r.Notify("No healthy server found")
+		failed(err)
+		return
+	}
 
-	for _, domain := range domains {
-		wg.Add(1)
-		semaphore <- struct{}{} // acquire
+	r.changeDomainsFromTo(fromIP, onServer, healthy, reason)
+}
 
-		go func(d db.DomainRow) {
-			defer wg.Done()
-			defer func() { <-semaphore }() // release
+// changeDomainsFromTo moves domains pointing to the failed server to the healthy one
+func (r *Switcher) changeDomainsFromTo(fromIP string, onServer []db.DomainRow, server *db.ProxyServerRow, reason string) {
+	log.Printf("switcher: Changing domains to new server %s: %s", server.Host, reason)
 
-			err := r.updateDomainToServer(fromIP, d, server)
-			if err != nil {
-				log.Printf("switcher: Failed to update domain %s: %v", d.Domain, err)
-				r.Notify(fmt.Sprintf("Failed to update domain %s: %v", d.Domain, err))
-			} else {
-				log.Printf("switcher: Successfully updated domain %s to point to %s", d.Domain, server.Host)
-			}
-		}(domain)
+	record := db.SwitchRecord{
+		Trigger:      db.TriggerAutomatic,
+		FailedServer: fromIP,
+		TargetServer: server.Host,
+		Reason:       reason,
+		StartedAt:    time.Now(),
+	}
+
+	if len(onServer) > 0 {
+		r.Notify(fmt.Sprintf("Server %s failed, switching its domains to %s: %s", fromIP, server.Host, reason))
+	}
+	r.switchDomains(&record, onServer, server)
+}
+
+// switchDomains updates domains concurrently and records every outcome in the ledger
+func (r *Switcher) switchDomains(record *db.SwitchRecord, domains []db.DomainRow, server *db.ProxyServerRow) {
+	semaphore := make(chan struct{}, maxConcurrentDomainUpdates)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	groups := r.groupByToken(domains)
+	log.Printf("switcher: Updating %d domains with %d Cloudflare tokens", len(domains), len(groups))
+
+	for _, group := range groups {
+		for _, domain := range group.domains {
+			wg.Add(1)
+			semaphore <- struct{}{} // acquire
+
+			go func(client cf.Client, d db.DomainRow) {
+				defer wg.Done()
+				defer func() { <-semaphore }() // release
+
+				outcome, err := r.updateDomainToServer(client, record.FailedServer, d, server)
+				if err != nil {
+					log.Printf("switcher: Failed to update domain %s: %v", d.Domain, err)
+					if record.Trigger == db.TriggerAutomatic {
+						r.Notify(fmt.Sprintf("Failed to update domain %s: %v%s", d.Domain, err, r.domainLink(d)))
+					}
+				} else if outcome.Status == db.OutcomeSwitched {
+					log.Printf("switcher: Successfully updated domain %s to point to %s", d.Domain, outcome.NewIP)
+				}
+
+				mu.Lock()
+				record.Domains = append(record.Domains, outcome)
+				mu.Unlock()
+			}(group.client, domain)
+		}
 	}
 
 	wg.Wait()
+	record.FinishedAt = time.Now()
+	r.saveSwitchRecord(record)
+	r.saveDnsStates(record)
 	log.Println("switcher: All domain updates attempted")
 }
 
-func (r *Switcher) updateDomainToServer(unhealthyServerIP string, domainWithCfToken db.DomainRow, toServer *db.ProxyServerRow) error {
-	client := r.cfClientFactory(domainWithCfToken.CfApiToken)
+func (r *Switcher) saveSwitchRecord(record *db.SwitchRecord) {
+	sort.Slice(record.Domains, func(i, j int) bool { return record.Domains[i].Domain < record.Domains[j].Domain })
+
+	if err := r.storage.SaveSwitchRecord(record); err != nil {
+		log.Printf("switcher: Failed to save switch history: %v", err)
+	}
+}
+
+func (r *Switcher) updateDomainToServer(client cf.Client, unhealthyServerIP string, domainWithCfToken db.DomainRow, toServer *db.ProxyServerRow) (db.DomainSwitchOutcome, error) {
+	start := time.Now()
+	outcome := db.DomainSwitchOutcome{
+		Domain: domainWithCfToken.Domain,
+		Status: db.OutcomeFailed,
+	}
+	fail := func(err error) (db.DomainSwitchOutcome, error) {
+		outcome.Error = err.Error()
+		outcome.Duration = time.Since(start)
+		return outcome, err
+	}
 
 	currentIP, err := client.GetDomainIP(domainWithCfToken.Domain)
 	if err != nil {
-		return fmt.Errorf("failed to get current IP for domain %s: %v", domainWithCfToken.Domain, err)
+		return fail(fmt.Errorf("failed to get current IP for domain %s: %v", domainWithCfToken.Domain, err))
 	}
-	if unhealthyServerIP != "" && currentIP != unhealthyServerIP {
+	outcome.OldIP = currentIP
+
+	if unhealthyServerIP != "" && !r.proxy(unhealthyServerIP).HasIP(currentIP) {
 		log.Printf("switcher: Domain %s->%s already points not to %s, skipping update", domainWithCfToken.Domain, currentIP, unhealthyServerIP)
-		return nil
+		outcome.Status = db.OutcomeSkipped
+		outcome.Duration = time.Since(start)
+		return outcome, nil
 	}
 
-	if err = client.UpdateDomainIP(domainWithCfToken.Domain, toServer.Host); err != nil {
-		return err
+	newIP := r.proxy(toServer.Host).IP()
+	if err = client.UpdateDomainIP(domainWithCfToken.Domain, newIP); err != nil {
+		return fail(err)
 	}
+	outcome.NewIP = newIP
+	outcome.Status = db.OutcomeSwitched
+	outcome.Duration = time.Since(start)
 
-	r.Notify(fmt.Sprintf("Domain %s switched from %s to %s", domainWithCfToken.Domain, unhealthyServerIP, toServer.Host))
+	r.Notify(fmt.Sprintf("Domain %s switched from %s to %s%s", domainWithCfToken.Domain, currentIP, newIP, r.domainLink(domainWithCfToken)))
 
-	return nil
+	return outcome, nil
+}
+
+// UseDomainLinks adds the link returned by url for the Airtable record of a domain to its notifications
+func (r *Switcher) UseDomainLinks(url func(recordID string) string) {
+	r.domainURL = url
+}
+
+// domainLink returns the Airtable link of the domain prefixed with a space, or nothing
+func (r *Switcher) domainLink(d db.DomainRow) string {
+	if r.domainURL == nil || d.DomainRecordID == "" {
+		return ""
+	}
+	url := r.domainURL(d.DomainRecordID)
+	if url == "" {
+		return ""
+	}
+	return " " + url
 }
 
 func (r *Switcher) Notify(message string) {
@@ -162,29 +327,13 @@ func (r *Switcher) Notify(message string) {
 	}
 }
 
+// ChangeAllDomainsToServer is a manual switch of the given domains to the server
 func (r *Switcher) ChangeAllDomainsToServer(domains []db.DomainRow, server *db.ProxyServerRow) {
 	log.Printf("switcher: Changing all domains to new server: %+v", server)
 
-	semaphore := make(chan struct{}, maxConcurrentDomainUpdates)
-	var wg sync.WaitGroup
-
-	for _, domain := range domains {
-		wg.Add(1)
-		semaphore <- struct{}{} // acquire
-
-		go func(d db.DomainRow) {
-			defer wg.Done()
-			defer func() { <-semaphore }() // release
-
-			err := r.updateDomainToServer("", d, server)
-			if err != nil {
-				log.Printf("switcher: Failed to update domain %s: %v", d.Domain, err)
-			} else {
-				log.Printf("switcher: Updated domain %s to server %s", d.Domain, server.Host)
-			}
-		}(domain)
-	}
-
-	wg.Wait()
-	log.Println("switcher: All domain updates attempted")
+	r.switchDomains(&db.SwitchRecord{
+		Trigger:      db.TriggerManual,
+		TargetServer: server.Host,
+		StartedAt:    time.Now(),
+	}, domains, server)
 }
@@ -2,7 +2,9 @@ package switcher
 
 import (
 	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"go-cf-zone-switch/pkg/cf"
 	"go-cf-zone-switch/pkg/config"
@@ -19,13 +21,11 @@ func TestSwitcher_ReceiveStatus_TriggersSwitch(t *testing.T) {
 		GetProxyServersFunc: func(onlyHealthy bool) ([]db.ProxyServerRow, error) {
 			return []db.ProxyServerRow{{Host: newHostIP, IsUp: true}}, nil
 		},
-		GetDomainWithCfTokensFunc: func() ([]db.DomainRow, error) {
-			return []db.DomainRow{{Domain: domain, CfApiToken: "token"}}, nil
-		},
 		SaveProxyServersFunc: func(rows []db.ProxyServerRow) error {
 			return nil
 		},
 	}
+	_ = mockStorage.SaveDomains([]db.DomainRow{{Domain: domain, CfApiToken: "token"}})
 	mockNotifier := &MockNotifier{}
 	sw := NewSwitcher(&config.Config{}, mockStorage, mockNotifier)
 
@@ -75,13 +75,11 @@ func TestSwitcher_ReceiveStatus_UpdateDomainError(t *testing.T) {
 		GetProxyServersFunc: func(onlyHealthy bool) ([]db.ProxyServerRow, error) {
 			return []db.ProxyServerRow{{Host: newHostIP, IsUp: true}}, nil
 		},
-		GetDomainWithCfTokensFunc: func() ([]db.DomainRow, error) {
-			return []db.DomainRow{{Domain: domain, CfApiToken: "token"}}, nil
-		},
 		SaveProxyServersFunc: func(rows []db.ProxyServerRow) error {
 			return nil
 		},
 	}
+	_ = mockStorage.SaveDomains([]db.DomainRow{{Domain: domain, CfApiToken: "token"}})
 	mockNotifier := &MockNotifier{}
 	sw := NewSwitcher(&config.Config{}, mockStorage, mockNotifier)
 
@@ -135,14 +133,11 @@ func TestSwitcher_ReceiveStatus_EmptyHealthyServers(t *testing.T) {
 			// Return an empty list to simulate no healthy servers.
 			return []db.ProxyServerRow{}, nil
 		},
-		GetDomainWithCfTokensFunc: func() ([]db.DomainRow, error) {
-			// Return a non-empty domain list.
-			return []db.DomainRow{{Domain: domain, CfApiToken: "token"}}, nil
-		},
 		SaveProxyServersFunc: func(rows []db.ProxyServerRow) error {
 			return nil
 		},
 	}
+	_ = mockStorage.SaveDomains([]db.DomainRow{{Domain: domain, CfApiToken: "token"}})
 	mockNotifier := &MockNotifier{}
 	sw := NewSwitcher(&config.Config{}, mockStorage, mockNotifier)
 	// Lower threshold for testing.
@@ -182,14 +177,11 @@ func TestSwitcher_ReceiveStatus_EmptyDomains(t *testing.T) {
 			// Return a healthy server.
 			return []db.ProxyServerRow{{Host: newHostIP, IsUp: true}}, nil
 		},
-		GetDomainWithCfTokensFunc: func() ([]db.DomainRow, error) {
-			// Return an empty list to simulate no domains.
-			return []db.DomainRow{}, nil
-		},
 		SaveProxyServersFunc: func(rows []db.ProxyServerRow) error {
 			return nil
 		},
 	}
+	// no domains stored
 	mockNotifier := &MockNotifier{}
 	sw := NewSwitcher(&config.Config{}, mockStorage, mockNotifier)
 	// Lower threshold for testing.
@@ -229,3 +221,134 @@ func TestSwitcher_ReceiveStatus_EmptyDomains(t *testing.T) {
 		t.Errorf("Expected no notifications to be sent, got %d", len(mockNotifier.Messages))
 	}
 }
+
+func TestSwitcher_ReceiveStatus_RecordsHistory(t *testing.T) {
+	failedIP := "10.0.0.1"
+	newHostIP := "100.0.0.4"
+
+	mockStorage := &MockStorage{
+		GetProxyServersFunc: func(onlyHealthy bool) ([]db.ProxyServerRow, error) {
+			return []db.ProxyServerRow{{Host: newHostIP, IsUp: true}}, nil
+		},
+		SaveProxyServersFunc: func(rows []db.ProxyServerRow) error {
+			return nil
+		},
+	}
+	_ = mockStorage.SaveDomains([]db.DomainRow{
+		{Domain: "moved.com", CfApiToken: "token"},
+		{Domain: "elsewhere.com", CfApiToken: "token"},
+		{Domain: "broken.com", CfApiToken: "token"},
+	})
+	sw := NewSwitcher(&config.Config{}, mockStorage, &MockNotifier{})
+	sw.switchAfterFailureCount = 1
+	sw.cfClientFactory = func(token string) cf.Client {
+		return &MockCfClient{
+			GetDomainIPFunc: func(dom string) (string, error) {
+				if dom == "elsewhere.com" {
+					return "10.9.9.9", nil
+				}
+				return failedIP, nil
+			},
+			UpdateDomainIPFunc: func(dom, newIP string) error {
+				if dom == "broken.com" {
+					return fmt.Errorf("update error")
+				}
+				return nil
+			},
+		}
+	}
+
+	_ = sw.ReceiveStatus([]servers.ServerStatus{{Host: failedIP, IsUp: false}})
+
+	if len(mockStorage.SwitchRecords) != 1 {
+		t.Fatalf("Expected 1 switch record, got %d", len(mockStorage.SwitchRecords))
+	}
+
+	record := mockStorage.SwitchRecords[0]
+	if record.Trigger != db.TriggerAutomatic || record.FailedServer != failedIP || record.TargetServer != newHostIP {
+		t.Errorf("Unexpected switch record %+v", record)
+	}
+	if record.FinishedAt.Before(record.StartedAt) {
+		t.Errorf("Expected finish after start, got %+v", record)
+	}
+
+	expected := map[string]string{
+		"broken.com":    db.OutcomeFailed,
+		"elsewhere.com": db.OutcomeSkipped,
+		"moved.com":     db.OutcomeSwitched,
+	}
+	for _, outcome := range record.Domains {
+		if outcome.Status != expected[outcome.Domain] {
+			t.Errorf("Expected %s to be %s, got %+v", outcome.Domain, expected[outcome.Domain], outcome)
+		}
+	}
+	if moved := record.Domains[2]; moved.OldIP != failedIP || moved.NewIP != newHostIP {
+		t.Errorf("Expected moved.com old and new IP, got %+v", moved)
+	}
+
+	if len(mockStorage.ServerChecks) != 1 || mockStorage.ServerChecks[0].IsUp || mockStorage.ServerChecks[0].Error == "" {
+		t.Errorf("Expected a failed check with a reason appended, got %+v", mockStorage.ServerChecks)
+	}
+}
+
+func TestSwitcher_UsesProxyModel(t *testing.T) {
+	cfg := &config.Config{Servers: config.Servers{Proxy: []config.Proxy{
+		{Name: "failed", Address: "failed.internal", Port: 80, PublicIPs: []string{"203.0.113.1", "203.0.113.2"}},
+		{Name: "standby", Address: "standby.internal", Port: 80, PublicIPs: []string{"203.0.113.5"}, NoFailover: true},
+		{Name: "full", Address: "full.internal", Port: 80, PublicIPs: []string{"203.0.113.6"}, MaxDomains: 1},
+		{Name: "backup", Address: "backup.internal", Port: 80, PublicIPs: []string{"203.0.113.7"}, Priority: 2},
+		{Name: "primary", Address: "primary.internal", Port: 80, PublicIPs: []string{"203.0.113.8", "203.0.113.9"}, Priority: 1},
+	}}}
+	if err := cfg.Servers.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	mockStorage := &MockStorage{
+		GetProxyServersFunc: func(onlyHealthy bool) ([]db.ProxyServerRow, error) {
+			return []db.ProxyServerRow{
+				{Host: "backup.internal", IsUp: true},
+				{Host: "full.internal", IsUp: true},
+				{Host: "primary.internal", IsUp: true},
+				{Host: "standby.internal", IsUp: true},
+			}, nil
+		},
+		SaveProxyServersFunc: func(rows []db.ProxyServerRow) error { return nil },
+	}
+	_ = mockStorage.SaveDomains([]db.DomainRow{{Domain: "a.com", CfApiToken: "token"}, {Domain: "b.com", CfApiToken: "token"}})
+	_ = mockStorage.SaveDnsStates([]db.DnsState{{Domain: "c.com", IP: "203.0.113.6", ProxyHost: "full.internal", ObservedAt: time.Now()}})
+
+	var mu sync.Mutex
+	updated := map[string]string{}
+	sw := NewSwitcher(cfg, mockStorage, &MockNotifier{})
+	sw.switchAfterFailureCount = 1
+	sw.cfClientFactory = func(token string) cf.Client {
+		return &MockCfClient{
+			GetDomainIPFunc: func(dom string) (string, error) {
+				if dom == "b.com" {
+					return "203.0.113.2", nil // second public IP of the failed proxy
+				}
+				return "203.0.113.1", nil
+			},
+			UpdateDomainIPFunc: func(dom, newIP string) error {
+				mu.Lock()
+				updated[dom] = newIP
+				mu.Unlock()
+				return nil
+			},
+		}
+	}
+
+	_ = sw.ReceiveStatus([]servers.ServerStatus{{Host: "failed.internal", IsUp: false}})
+
+	if updated["a.com"] != "203.0.113.8" || updated["b.com"] != "203.0.113.8" {
+		t.Errorf("Expected domains switched to the first public IP of the preferred proxy, got %v", updated)
+	}
+	if record := mockStorage.SwitchRecords[0]; record.TargetServer != "primary.internal" {
+		t.Errorf("Expected primary as target, got %+v", record)
+	}
+
+	states, _ := mockStorage.GetDnsStates()
+	if distribution := db.ProxyDistribution(states); len(distribution["primary.internal"]) != 2 {
+		t.Errorf("Expected switched domains observed on the primary proxy, got %v", distribution)
+	}
+}
@@ -0,0 +1,30 @@
+package switcher
+
+import (
+	"go-cf-zone-switch/pkg/cf"
+	"go-cf-zone-switch/pkg/db"
+)
+
+// tokenGroup is the domains sharing one Cloudflare token and the client made for it
+type tokenGroup struct {
+	client  cf.Client
+	domains []db.DomainRow
+}
+
+// groupByToken creates one Cloudflare client per token instead of one per domain.
+// Stored rows carry the current value of their token, rotated ones included.
+func (r *Switcher) groupByToken(domains []db.DomainRow) []tokenGroup {
+	groups := []tokenGroup{}
+	index := map[string]int{}
+	for _, d := range domains {
+		key := d.CfApiToken.Reveal()
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, tokenGroup{client: r.cfClientFactory(d.CfApiToken.Reveal())})
+		}
+		groups[i].domains = append(groups[i].domains, d)
+	}
+	return groups
+}
@@ -0,0 +1,47 @@
+package switcher
+
+import (
+	"sort"
+	"sync"
+	"testing"
+
+	"go-cf-zone-switch/pkg/cf"
+	"go-cf-zone-switch/pkg/config"
+	"go-cf-zone-switch/pkg/db"
+)
+
+func TestSwitcher_OneClientPerToken(t *testing.T) {
+	var mu sync.Mutex
+	updated := map[string][]string{}
+	sw := NewSwitcher(&config.Config{}, &MockStorage{
+		GetProxyServersFunc: func(onlyHealthy bool) ([]db.ProxyServerRow, error) { return nil, nil },
+	}, &MockNotifier{})
+	sw.cfClientFactory = func(token string) cf.Client {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := updated[token]; ok {
+			t.Errorf("Expected one client for token %s", token)
+		}
+		updated[token] = []string{}
+		return &MockCfClient{
+			UpdateDomainIPFunc: func(dom, newIP string) error {
+				mu.Lock()
+				updated[token] = append(updated[token], dom)
+				mu.Unlock()
+				return nil
+			},
+		}
+	}
+
+	sw.ChangeAllDomainsToServer([]db.DomainRow{
+		{Domain: "a.com", CfApiToken: "token-1", TokenRef: "tk_1"},
+		{Domain: "b.com", CfApiToken: "token-2", TokenRef: "tk_2"},
+		{Domain: "c.com", CfApiToken: "token-1", TokenRef: "tk_1"},
+		{Domain: "d.com", CfApiToken: "token-2"},
+	}, &db.ProxyServerRow{Host: "10.0.0.2"})
+
+	sort.Strings(updated["token-1"])
+	if len(updated) != 2 || len(updated["token-1"]) != 2 || len(updated["token-2"]) != 2 || updated["token-1"][1] != "c.com" {
+		t.Errorf("Expected domains grouped by token, got %v", updated)
+	}
+}